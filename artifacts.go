@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// artifact describes a job in an artifacts document.
+type artifact struct {
+	URL     string            `json:"url"`
+	Target  string            `json:"target,omitempty"`
+	Digest  string            `json:"digest,omitempty"`  // algorithm:hex, or a SHA-256 in hex
+	Digests map[string]string `json:"digests,omitempty"` // algorithm to hex
+	Unpack  *bool             `json:"unpack,omitempty"`  // defaults to -unpack
+
+	ID       string   `json:"id,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	After    []string `json:"after,omitempty"` // ids of artifacts to fetch first
+}
+
+// artifactsDoc is an artifacts document, or lockfile.
+type artifactsDoc struct {
+	// Prefer is the digest algorithm used to verify artifacts
+	// that have several; otherwise, the strongest supported one is.
+	Prefer    string     `json:"prefer,omitempty"`
+	Artifacts []artifact `json:"artifacts"`
+}
+
+// digests returns every digest of an artifact, by algorithm.
+func (a *artifact) digests() map[string]string {
+	ds := map[string]string{}
+	for algo, sum := range a.Digests {
+		ds[strings.ToLower(algo)] = strings.ToLower(sum)
+	}
+	if a.Digest != "" {
+		algo, sum := "sha256", a.Digest
+		if i := strings.IndexByte(sum, ':'); i >= 0 {
+			algo, sum = sum[:i], sum[i+1:]
+		}
+		ds[strings.ToLower(algo)] = strings.ToLower(sum)
+	}
+	return ds
+}
+
+// digest picks the digest to verify an artifact with, in algorithm:hex form.
+// Algorithms this version doesn't know are ignored,
+// so lockfiles can carry newer ones.
+func (a *artifact) digest(prefer string) (string, error) {
+	ds := a.digests()
+	if len(ds) == 0 {
+		return "", nil
+	}
+	if sum, ok := ds[prefer]; ok && gofetch.NewHash(prefer) != nil {
+		return prefer + ":" + sum, nil
+	}
+	for _, algo := range digestAlgorithms {
+		if sum, ok := ds[algo]; ok {
+			return algo + ":" + sum, nil
+		}
+	}
+	return "", fmt.Errorf("no supported digest algorithm for %s", a.URL)
+}
+
+// readArtifacts reads a JSON document describing artifacts:
+// either an array of them, or an object with an artifacts array.
+func readArtifacts(name string) ([]*job, error) {
+	doc, err := decodeArtifacts(name)
+	if err != nil {
+		return nil, err
+	}
+	return artifactJobs(doc)
+}
+
+// artifactJobs makes the jobs for an artifacts document.
+func artifactJobs(doc *artifactsDoc) ([]*job, error) {
+	var jobs []*job
+	ids := map[string]*job{}
+	for i, a := range doc.Artifacts {
+		if a.URL == "" || a.Target == "" && !*list {
+			return nil, fmt.Errorf("artifact %d: url and target are required", i)
+		}
+		j := newJob(a.URL, a.Target)
+		if a.Unpack != nil {
+			j.unpack, j.unpackSet = *a.Unpack, true
+		}
+		if d, err := a.digest(doc.Prefer); err != nil {
+			return nil, fmt.Errorf("artifact %d: %w", i, err)
+		} else if d != "" {
+			j.digest = d
+		}
+		j.priority = a.Priority
+		if a.ID != "" {
+			if ids[a.ID] != nil {
+				return nil, fmt.Errorf("artifact %d: duplicate id %q", i, a.ID)
+			}
+			ids[a.ID] = j
+		}
+		jobs = append(jobs, j)
+	}
+
+	for i, a := range doc.Artifacts {
+		for _, id := range a.After {
+			dep := ids[id]
+			if dep == nil {
+				return nil, fmt.Errorf("artifact %d: unknown id %q", i, id)
+			}
+			jobs[i].deps = append(jobs[i].deps, dep)
+		}
+	}
+	if err := checkCycles(jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func decodeArtifacts(name string) (*artifactsDoc, error) {
+	var buf []byte
+	var err error
+	if name == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var doc artifactsDoc
+	if buf = bytes.TrimSpace(buf); bytes.HasPrefix(buf, []byte("[")) {
+		err = json.Unmarshal(buf, &doc.Artifacts)
+	} else {
+		err = json.Unmarshal(buf, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading artifacts: %w", err)
+	}
+	return &doc, nil
+}
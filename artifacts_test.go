@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadArtifacts(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    []job
+		wantErr bool
+	}{
+		{
+			name: "array",
+			doc:  `[{"url": "https://host/a.tar.gz", "target": "a", "unpack": true, "digest": "abcd"}]`,
+			want: []job{{source: "https://host/a.tar.gz", target: "a", unpack: true, digest: "sha256:abcd"}},
+		},
+		{
+			name: "object",
+			doc: `{"artifacts": [
+				{"url": "https://host/b", "target": "b", "digest": "sha512:ef01"},
+				{"url": "https://host/c", "target": "c", "unpack": false}
+			]}`,
+			want: []job{
+				{source: "https://host/b", target: "b", digest: "sha512:ef01"},
+				{source: "https://host/c", target: "c"},
+			},
+		},
+		{
+			name: "digests",
+			doc: `{"prefer": "sha256", "artifacts": [
+				{"url": "https://host/d", "target": "d", "digests": {"sha256": "AB", "sha512": "cd"}},
+				{"url": "https://host/e", "target": "e", "digests": {"sha512": "cd", "sha3-512": "ef"}}
+			]}`,
+			want: []job{
+				{source: "https://host/d", target: "d", digest: "sha256:ab"},
+				{source: "https://host/e", target: "e", digest: "sha512:cd"},
+			},
+		},
+		{name: "unsupported", doc: `[{"url": "https://host/a", "target": "a", "digest": "md5:ab"}]`, wantErr: true},
+		{name: "no target", doc: `[{"url": "https://host/a"}]`, wantErr: true},
+		{name: "no url", doc: `[{"target": "a"}]`, wantErr: true},
+		{name: "malformed", doc: `{"artifacts": {}}`, wantErr: true},
+		{name: "unknown id", doc: `[{"url": "https://host/a", "target": "a", "after": ["b"]}]`, wantErr: true},
+		{
+			name:    "duplicate id",
+			doc:     `[{"url": "https://host/a", "target": "a", "id": "x"}, {"url": "https://host/b", "target": "b", "id": "x"}]`,
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			doc: `[{"url": "https://host/a", "target": "a", "id": "a", "after": ["b"]},
+				{"url": "https://host/b", "target": "b", "id": "b", "after": ["a"]}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "artifacts.json")
+		ioutil.WriteFile(name, []byte(tt.doc), 0666)
+
+		jobs, err := readArtifacts(name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readArtifacts() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if len(jobs) != len(tt.want) {
+			t.Errorf("%s: readArtifacts() = %d jobs, want %d", tt.name, len(jobs), len(tt.want))
+			continue
+		}
+		for i, j := range jobs {
+			w := tt.want[i]
+			if j.source != w.source || j.target != w.target || j.digest != w.digest || j.unpack != w.unpack {
+				t.Errorf("%s: job %d = %+v, want %+v", tt.name, i, *j, w)
+			}
+		}
+	}
+}
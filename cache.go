@@ -0,0 +1,436 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// The cache stores response bodies by their SHA-256,
+// under blobs/sha256/, and indexes them by url and validators,
+// under index/, so that every version of a url can be revalidated.
+// It's private to the user: directories are 0700, files 0600.
+type cacheEntry struct {
+	URL    string      `json:"url"`
+	Digest string      `json:"digest"` // of the body, as sha256:hex
+	Size   int64       `json:"size"`
+	Header http.Header `json:"header"`
+	Time   time.Time   `json:"time"`
+}
+
+// cacheSize bounds the size of the blobs in the cache;
+// the least recently used are removed to make room for new ones.
+var cacheSize = sizeFlag(1 << 30)
+
+func init() {
+	flag.Var(&cacheSize, "cache-size", "keep at most `bytes` in the cache (default 1G)")
+}
+
+// cacheDir returns the cache directory, or "" if it's disabled.
+func cacheDir() string {
+	// the raw bytes of a response may not be its contents
+	if *cache == "off" || *raw {
+		return ""
+	}
+	if *cache != "" {
+		return *cache
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-fetch", "cache")
+}
+
+func cacheIndex(dir, url string) string {
+	key := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "index", hex.EncodeToString(key[:]))
+}
+
+// cacheKey names the index entry for a version of url.
+func cacheKey(dir, url string, header http.Header) string {
+	key := sha256.Sum256([]byte(header.Get("ETag") + "\n" + header.Get("Last-Modified")))
+	return filepath.Join(cacheIndex(dir, url), hex.EncodeToString(key[:]))
+}
+
+func cacheBlob(dir, digest string) string {
+	return filepath.Join(dir, "blobs", "sha256", digest[len("sha256:"):])
+}
+
+// cacheLookup finds the cached versions of url, most recent first.
+func cacheLookup(dir, url string) []*cacheEntry {
+	files, err := ioutil.ReadDir(cacheIndex(dir, url))
+	if err != nil {
+		return nil
+	}
+	var entries []*cacheEntry
+	for _, fi := range files {
+		buf, err := ioutil.ReadFile(filepath.Join(cacheIndex(dir, url), fi.Name()))
+		if err != nil {
+			continue
+		}
+		var e cacheEntry
+		if json.Unmarshal(buf, &e) != nil || e.URL != url || len(e.Digest) != len("sha256:")+2*sha256.Size {
+			continue
+		}
+		if _, err := os.Stat(cacheBlob(dir, e.Digest)); err != nil {
+			continue
+		}
+		entries = append(entries, &e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	return entries
+}
+
+// cacheable reports whether the response to req may be cached, or
+// served from the cache: requests with credentials are never cached.
+func cacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.URL.User != nil {
+		return false
+	}
+	for _, k := range credentialHeaders {
+		if req.Header.Get(k) != "" {
+			return false
+		}
+	}
+	return !hasDirective(req.Header, "no-store")
+}
+
+// storable reports whether a response may be stored in the cache:
+// only if it can be revalidated, and no Cache-Control forbids it.
+func storable(res *http.Response) bool {
+	return res.StatusCode == http.StatusOK &&
+		(res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != "") &&
+		!hasDirective(res.Header, "no-store") && !hasDirective(res.Header, "private")
+}
+
+// hasDirective reports whether a Cache-Control header has directive.
+func hasDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if i := strings.IndexAny(d, "= "); i >= 0 {
+				d = d[:i]
+			}
+			if strings.EqualFold(d, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cachedSend sends a GET request, revalidating the cached versions of
+// its url if there are any, and caching the response, once it's been read.
+func cachedSend(req *http.Request) (*http.Response, error) {
+	dir := cacheDir()
+	if *offline {
+		return offlineSend(dir, req)
+	}
+	if dir == "" || !cacheable(req) {
+		return client.Do(req)
+	}
+
+	url := req.URL.String()
+	var entries []*cacheEntry
+	if req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		entries = cacheLookup(dir, url)
+	}
+
+	res, err := client.Do(revalidate(req, entries))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		if e := notModified(entries, res.Header); e != nil {
+			if cached := cachedResponse(dir, e, res.Request); cached != nil {
+				return cached, nil
+			}
+		}
+		// the cache can't serve it, so ask again, unconditionally
+		if res, err = client.Do(req); err != nil {
+			return nil, err
+		}
+	}
+	if storable(res) {
+		if w, err := newCacheWriter(dir, url, res); err == nil {
+			res.Body = w
+		}
+	}
+	return res, nil
+}
+
+// revalidate adds validators for the cached versions to req.
+func revalidate(req *http.Request, entries []*cacheEntry) *http.Request {
+	var etags []string
+	for _, e := range entries {
+		if etag := e.Header.Get("ETag"); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	switch {
+	case len(etags) > 0:
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", strings.Join(etags, ", "))
+	case len(entries) > 0:
+		req = req.Clone(req.Context())
+		req.Header.Set("If-Modified-Since", entries[0].Header.Get("Last-Modified"))
+	}
+	return req
+}
+
+// notModified finds the version a 304 response selects.
+func notModified(entries []*cacheEntry, header http.Header) *cacheEntry {
+	etag := header.Get("ETag")
+	var tagged int
+	for _, e := range entries {
+		if t := e.Header.Get("ETag"); t != "" {
+			tagged++
+			if t == etag {
+				return e
+			}
+		}
+	}
+	// without an ETag, only an unambiguous version is served
+	if etag == "" && (tagged == 0 || len(entries) == 1) {
+		return entries[0]
+	}
+	return nil
+}
+
+// offlineSend serves a request from the cache, without revalidating it.
+func offlineSend(dir string, req *http.Request) (*http.Response, error) {
+	if dir == "" {
+		return nil, errors.New("offline: the cache is disabled")
+	}
+	url := req.URL.String()
+	if cacheable(req) {
+		if entries := cacheLookup(dir, url); len(entries) > 0 {
+			if res := cachedResponse(dir, entries[0], req); res != nil {
+				return res, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("offline: %s is not cached", url)
+}
+
+// cachedResponse makes a response out of a cache entry,
+// if its blob still has the expected digest.
+// Corrupt blobs are removed.
+func cachedResponse(dir string, e *cacheEntry, req *http.Request) *http.Response {
+	blob := cacheBlob(dir, e.Digest)
+	if !hasDigest(blob, e.Digest) {
+		os.Remove(blob)
+		return nil
+	}
+	f, err := os.Open(blob)
+	if err != nil {
+		return nil
+	}
+	// mark it as recently used
+	now := time.Now()
+	os.Chtimes(blob, now, now)
+
+	header := e.Header.Clone()
+	header.Set("Content-Length", strconv.FormatInt(e.Size, 10))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          f,
+		ContentLength: e.Size,
+		Request:       req,
+	}
+}
+
+// linkCached replaces the file at path with a hard link to its
+// cached copy, if there is one, so that it's stored only once.
+// Where links aren't supported, the file is kept as is.
+func linkCached(path string, sum []byte, size int64) error {
+	dir := cacheDir()
+	if dir == "" {
+		return nil
+	}
+	blob := cacheBlob(dir, "sha256:"+hex.EncodeToString(sum))
+	if fi, err := os.Stat(blob); err != nil || fi.Size() != size {
+		return nil
+	}
+	// the link gets the mode of the download;
+	// the cache directory keeps the blob private
+	if fi, err := os.Stat(path); err != nil || os.Chmod(blob, fi.Mode().Perm()) != nil {
+		return nil
+	}
+
+	tmp := gofetch.PartialName(path)
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// cacheWriter saves a response body to the cache as it's read,
+// adding it when it's read to the end.
+type cacheWriter struct {
+	io.ReadCloser
+	dir  string
+	tmp  *os.File
+	hash hash.Hash
+	size int64
+	e    cacheEntry
+	want int64 // the Content-Length, or -1
+}
+
+func newCacheWriter(dir, url string, res *http.Response) (*cacheWriter, error) {
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(tmpDir, partPrefix+"*"+partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{
+		ReadCloser: res.Body,
+		dir:        dir,
+		tmp:        tmp,
+		hash:       sha256.New(),
+		e:          cacheEntry{URL: url, Header: res.Header, Time: time.Now().UTC()},
+		want:       res.ContentLength,
+	}, nil
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if w.tmp != nil && n > 0 {
+		if _, werr := w.tmp.Write(p[:n]); werr != nil {
+			w.discard()
+		}
+		w.hash.Write(p[:n])
+		w.size += int64(n)
+	}
+	if err == io.EOF && w.tmp != nil {
+		w.commit()
+	}
+	return n, err
+}
+
+func (w *cacheWriter) Close() error {
+	w.discard()
+	return w.ReadCloser.Close()
+}
+
+func (w *cacheWriter) discard() {
+	if w.tmp != nil {
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+		w.tmp = nil
+	}
+}
+
+// commit moves the body to its blob, and indexes it.
+// Failing to cache doesn't fail the download.
+func (w *cacheWriter) commit() {
+	defer w.discard()
+	if w.want >= 0 && w.size != w.want || w.size > int64(cacheSize) {
+		return
+	}
+	if w.tmp.Close() != nil {
+		return
+	}
+
+	w.e.Digest = "sha256:" + hex.EncodeToString(w.hash.Sum(nil))
+	w.e.Size = w.size
+	blob := cacheBlob(w.dir, w.e.Digest)
+	// keep an intact blob, which may be linked to
+	if !hasDigest(blob, w.e.Digest) {
+		if os.MkdirAll(filepath.Dir(blob), 0700) != nil || os.Rename(w.tmp.Name(), blob) != nil {
+			return
+		}
+		w.tmp = nil
+	}
+	defer pruneCache(w.dir, blob)
+
+	buf, err := json.Marshal(w.e)
+	if err != nil {
+		return
+	}
+	index := cacheKey(w.dir, w.e.URL, w.e.Header)
+	if os.MkdirAll(filepath.Dir(index), 0700) != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(index), partPrefix+"*"+partSuffix)
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil && cerr == nil {
+		if os.Rename(tmp.Name(), index) == nil {
+			return
+		}
+	}
+	os.Remove(tmp.Name())
+}
+
+// pruneCache removes the least recently used blobs, other than keep,
+// until the cache fits in -cache-size, and the index entries for them.
+func pruneCache(dir, keep string) {
+	blobs, err := ioutil.ReadDir(filepath.Dir(keep))
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, fi := range blobs {
+		total += fi.Size()
+	}
+	if total <= int64(cacheSize) {
+		return
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].ModTime().Before(blobs[j].ModTime()) })
+	removed := map[string]bool{}
+	for _, fi := range blobs {
+		if total <= int64(cacheSize) {
+			break
+		}
+		path := filepath.Join(filepath.Dir(keep), fi.Name())
+		if path != keep && os.Remove(path) == nil {
+			removed["sha256:"+fi.Name()] = true
+			total -= fi.Size()
+		}
+	}
+
+	filepath.Walk(filepath.Join(dir, "index"), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		var e cacheEntry
+		if buf, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(buf, &e) == nil && removed[e.Digest] {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
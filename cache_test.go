@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCachedSend(t *testing.T) {
+	var version = "v1"
+	var full int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/private":
+			w.Header().Set("Cache-Control", "max-age=60, private")
+		}
+		etag := `"` + version + `"`
+		w.Header().Set("ETag", etag)
+		if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Write([]byte(version))
+	}))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	defer func(old string) { *cache = old }(*cache)
+	*cache = dir
+
+	get := func(path string, header http.Header) string {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := cachedSend(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		buf, _ := ioutil.ReadAll(res.Body)
+		return string(buf)
+	}
+
+	tests := []struct {
+		path    string
+		header  http.Header
+		version string
+		full    int // full responses, 200 instead of 304
+	}{
+		{path: "/file", version: "v1", full: 1},
+		{path: "/file", version: "v1", full: 0},
+		{path: "/file", version: "v2", full: 1},
+		{path: "/file", version: "v2", full: 0},
+		{path: "/file", version: "v1", full: 0},
+		{path: "/file", version: "v1", header: http.Header{"Authorization": {"Bearer x"}}, full: 1},
+		{path: "/no-store", version: "v1", full: 1},
+		{path: "/no-store", version: "v1", full: 1},
+		{path: "/private", version: "v1", full: 1},
+		{path: "/private", version: "v1", full: 1},
+	}
+	for i, tt := range tests {
+		version, full = tt.version, 0
+		if got := get(tt.path, tt.header); got != tt.version || full != tt.full {
+			t.Errorf("%d: GET %s = %q with %d full responses, want %q with %d",
+				i, tt.path, got, full, tt.version, tt.full)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().Perm()&0077 != 0 {
+				t.Errorf("%s is accessible to others: %v", path, fi.Mode())
+			}
+			return nil
+		})
+	}
+
+	// a corrupt blob is downloaded again
+	version, full = "v1", 0
+	for _, e := range cacheLookup(dir, srv.URL+"/file") {
+		ioutil.WriteFile(cacheBlob(dir, e.Digest), []byte("xx"), 0600)
+	}
+	if got := get("/file", nil); got != "v1" || full != 1 {
+		t.Errorf("corrupt cache: GET = %q with %d full responses", got, full)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+		w.Write([]byte(strings.Repeat("x", 100) + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	defer func(old string, size sizeFlag) { *cache, cacheSize = old, size }(*cache, cacheSize)
+	*cache, cacheSize = dir, 250
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		res, err := cachedSend(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		cached := len(cacheLookup(dir, srv.URL+path)) > 0
+		if want := path != "/a"; cached != want {
+			t.Errorf("%s cached = %v, want %v", path, cached, want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	v1 := &cacheEntry{Header: http.Header{"Etag": {`"v1"`}}}
+	v2 := &cacheEntry{Header: http.Header{"Etag": {`"v2"`}}}
+	lm := &cacheEntry{Header: http.Header{"Last-Modified": {"Sun, 06 Nov 1994 08:49:37 GMT"}}}
+
+	tests := []struct {
+		entries []*cacheEntry
+		etag    string
+		want    *cacheEntry
+	}{
+		{entries: []*cacheEntry{v1, v2}, etag: `"v2"`, want: v2},
+		{entries: []*cacheEntry{v1, v2}, etag: `"v3"`},
+		{entries: []*cacheEntry{v1, v2}},
+		{entries: []*cacheEntry{v1}, want: v1},
+		{entries: []*cacheEntry{lm}, want: lm},
+	}
+	for i, tt := range tests {
+		header := http.Header{}
+		if tt.etag != "" {
+			header.Set("ETag", tt.etag)
+		}
+		if got := notModified(tt.entries, header); got != tt.want {
+			t.Errorf("%d: notModified() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestOfflineSend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("cached"))
+	}))
+	defer srv.Close()
+
+	defer func(old string, off bool) { *cache, *offline = old, off }(*cache, *offline)
+	*cache = t.TempDir()
+
+	get := func(path string) (string, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		res, err := cachedSend(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		buf, err := ioutil.ReadAll(res.Body)
+		return string(buf), err
+	}
+
+	if _, err := get("/cached"); err != nil {
+		t.Fatal(err)
+	}
+	*offline = true
+	if got, err := get("/cached"); err != nil || got != "cached" {
+		t.Errorf("offline GET /cached = %q, %v", got, err)
+	}
+	if _, err := get("/missing"); err == nil {
+		t.Error("offline GET /missing: want error")
+	}
+	*cache = "off"
+	if _, err := get("/cached"); err == nil {
+		t.Error("offline without a cache: want error")
+	}
+}
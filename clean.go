@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// Partial files and staging directories are created next to their
+// final destination, and named so that they can be found and removed
+// if a previous run was interrupted.
+// Lock files are named likewise.
+const (
+	partPrefix = gofetch.PartialPrefix
+	partSuffix = gofetch.PartialSuffix
+	lockSuffix = ".lock"
+)
+
+func isPartName(name string) bool {
+	return strings.HasPrefix(name, partPrefix) &&
+		(strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, lockSuffix))
+}
+
+func cleanMain(args []string) {
+	flags := flag.NewFlagSet("clean", flag.ExitOnError)
+	age := flags.Duration("age", 24*time.Hour, "only remove files older than `duration`")
+	dryRun := flags.Bool("n", false, "print what would be removed, without removing it")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "go-fetch clean [flags] [dir]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	before := time.Now().Add(-*age)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isPartName(fi.Name()) {
+			return nil
+		}
+		if strings.HasSuffix(fi.Name(), lockSuffix) && fi.Mode().IsRegular() {
+			if fi.ModTime().Before(before) {
+				return removeLock(path, *dryRun)
+			}
+			return nil
+		}
+		if fi.ModTime().Before(before) {
+			fmt.Println(path)
+			if !*dryRun {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+		}
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// removeLock removes a lock file, unless it's held by a running go-fetch.
+// Like releasing the lock, it's removed while locked,
+// and whoever waits on it will notice, and lock anew.
+func removeLock(path string, dryRun bool) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if err := lockFile(f, false); err != nil {
+		return nil
+	}
+	defer unlockFile(f)
+
+	fmt.Println(path)
+	if dryRun {
+		return nil
+	}
+	return os.Remove(path)
+}
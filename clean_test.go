@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClean(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	files := []struct {
+		name string
+		dir  bool
+		old  bool
+		kept bool
+	}{
+		{name: ".go-fetch-old.part", old: true},
+		{name: ".go-fetch-new.part", kept: true},
+		{name: ".go-fetch-dir.part", dir: true, old: true},
+		{name: "sub/.go-fetch-nested.part", old: true},
+		{name: "old.txt", old: true, kept: true},
+		{name: ".go-fetch-lock", old: true, kept: true},
+		{name: ".go-fetch-stale.lock", old: true},
+		{name: ".go-fetch-held.lock", old: true, kept: true},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		dir := t.TempDir()
+		for _, f := range files {
+			path := filepath.Join(dir, f.name)
+			os.MkdirAll(filepath.Dir(path), 0777)
+			if f.dir {
+				os.Mkdir(path, 0777)
+				ioutil.WriteFile(filepath.Join(path, "file"), nil, 0666)
+			} else {
+				ioutil.WriteFile(path, nil, 0666)
+			}
+			if f.old {
+				os.Chtimes(path, old, old)
+			}
+		}
+
+		held, err := os.OpenFile(filepath.Join(dir, ".go-fetch-held.lock"), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lockFile(held, false); err != nil {
+			t.Fatal(err)
+		}
+
+		args := []string{dir}
+		if dryRun {
+			args = []string{"-n", dir}
+		}
+		captureStdout(t, func() { cleanMain(args) })
+
+		for _, f := range files {
+			_, err := os.Stat(filepath.Join(dir, f.name))
+			if kept := err == nil; kept != (f.kept || dryRun) {
+				t.Errorf("clean(dry run %v): %s kept = %v", dryRun, f.name, kept)
+			}
+		}
+		unlockFile(held)
+		held.Close()
+	}
+}
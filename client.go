@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// client is used for every request,
+// once configured by the command line flags.
+var client = http.DefaultClient
+
+func configureClient() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// without Accept-Encoding, the body isn't transparently decoded
+	transport.DisableCompression = *raw || *keepEncoding || !compressed
+
+	// keep a connection for each parallel download from the same host
+	if *parallel > http.DefaultMaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = *parallel
+	}
+
+	// like the default transport's dialer, but configurable
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if *connectTimeout > 0 {
+		dialer.Timeout = *connectTimeout
+		transport.TLSHandshakeTimeout = *connectTimeout
+	}
+	if *dnsResolver != "" || *doh != "" {
+		dialer.Resolver = newResolver(*dnsResolver, *doh)
+	}
+	transport.DialContext = dialer.DialContext
+
+	if *ipv4 || *ipv6 {
+		if *ipv4 && *ipv6 {
+			log.Fatal("-4 and -6 are mutually exclusive")
+		}
+		family := "4"
+		if *ipv6 {
+			family = "6"
+		}
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if network == "tcp" {
+				network += family
+			}
+			return dial(ctx, network, addr)
+		}
+	}
+
+	if len(resolves) > 0 {
+		transport.DialContext = dialResolved(transport.DialContext)
+	}
+
+	if *proxy != "" {
+		u, err := parseProxy(*proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+		preconnects = nil
+	} else {
+		preconnects = newPreconnector(transport)
+		transport.DialContext = preconnects.DialContext
+	}
+
+	if *unixSocket != "" {
+		// every connection goes to the socket, whatever the host
+		transport.Proxy = nil
+		preconnects = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", *unixSocket)
+		}
+	}
+
+	if *cacert != "" || *insecure || *cert != "" || *pin != "" {
+		transport.TLSClientConfig = tlsConfig()
+	}
+
+	on := *retryOn
+	if conf.RetryOn != "" && !isFlagSet("retry-on") {
+		on = conf.RetryOn
+	}
+	statuses, err := gofetch.ParseStatuses(on)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rt http.RoundTripper = &gofetch.RetryTransport{
+		Base:     transport,
+		Retries:  *retries,
+		Statuses: statuses,
+		Logf:     log.Printf,
+	}
+	if !transport.DisableCompression {
+		rt = encodingTransport{rt}
+	}
+
+	if err := loadCookieJar(); err != nil {
+		log.Fatal(err)
+	}
+
+	// the timeout includes reading the body
+	client = &http.Client{Transport: rt, Timeout: *maxTime, Jar: cookieJar}
+	if *offline {
+		client = &http.Client{Transport: offlineTransport{}}
+	}
+}
+
+var errOffline = errors.New("offline: network access is disabled")
+
+// offlineTransport fails every request.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, errOffline
+}
+
+func tlsConfig() *tls.Config {
+	config := &tls.Config{}
+
+	if *cacert != "" {
+		pem, err := ioutil.ReadFile(*cacert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// trust the system roots as well
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("no certificates found in %q", *cacert)
+		}
+		config.RootCAs = pool
+	}
+
+	if *cert != "" {
+		c, err := clientCertificate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{c}
+	}
+
+	if *pin != "" {
+		pins, err := parsePins(*pin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs.PeerCertificates, pins)
+		}
+	}
+
+	if *insecure {
+		if *pin == "" {
+			log.Print("WARNING: -insecure disables TLS certificate verification; downloads can be tampered with!")
+		}
+		config.InsecureSkipVerify = true
+	}
+
+	return config
+}
+
+// parsePins parses a list of sha256//BASE64 public key hashes,
+// separated by semicolons, like curl's --pinnedpubkey.
+func parsePins(s string) ([][]byte, error) {
+	var pins [][]byte
+	for _, p := range strings.Split(s, ";") {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "sha256//") {
+			return nil, fmt.Errorf("invalid pin %q: want sha256//BASE64", p)
+		}
+		h, err := base64.StdEncoding.DecodeString(p[len("sha256//"):])
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q: want a base64 SHA-256 hash", p)
+		}
+		pins = append(pins, h)
+	}
+	return pins, nil
+}
+
+// verifyPins checks that some certificate in the chain
+// has a public key (SPKI) that matches a pin.
+func verifyPins(chain []*x509.Certificate, pins [][]byte) error {
+	for _, c := range chain {
+		h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		for _, p := range pins {
+			if bytes.Equal(h[:], p) {
+				return nil
+			}
+		}
+	}
+	return errors.New("no certificate matches -pin")
+}
+
+// parseProxy parses a proxy URL, with optional user:password.
+// Without a scheme, the proxy is assumed to be HTTP, like curl does.
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("http://" + s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+}
+
+// clientCertificate loads the -cert and -key PEM files,
+// or the -cert PKCS#12 file, which includes both.
+func clientCertificate() (tls.Certificate, error) {
+	ext := strings.ToLower(filepath.Ext(*cert))
+	if ext != ".p12" && ext != ".pfx" {
+		key := *key
+		if key == "" {
+			key = *cert // both in the same file
+		}
+		return tls.LoadX509KeyPair(*cert, key)
+	}
+
+	data, err := ioutil.ReadFile(*cert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	blocks, err := pkcs12.ToPEM(data, *certPassword)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("reading %q: %w", *cert, err)
+	}
+
+	var keyPEM []byte
+	var certs [][]byte
+	for _, b := range blocks {
+		if b.Type == "CERTIFICATE" {
+			certs = append(certs, pem.EncodeToMemory(b))
+		} else {
+			keyPEM = pem.EncodeToMemory(b)
+		}
+	}
+
+	// the leaf must come first, but the file may store the chain in any order
+	err = fmt.Errorf("no certificate in %q matches its key", *cert)
+	for i := range certs {
+		certs[0], certs[i] = certs[i], certs[0]
+		c, kerr := tls.X509KeyPair(bytes.Join(certs, nil), keyPEM)
+		if kerr == nil {
+			return c, nil
+		}
+		certs[0], certs[i] = certs[i], certs[0]
+	}
+	return tls.Certificate{}, err
+}
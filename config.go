@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// config holds defaults shared across invocations.
+type config struct {
+	// Unpack maps file extensions (".tgz") and content types
+	// ("application/zip") to "always", "never" or "decompress".
+	Unpack map[string]string `json:"unpack"`
+
+	// RetryOn lists the retryable HTTP statuses, like -retry-on.
+	RetryOn string `json:"retry_on"`
+
+	// Regions expand {region} placeholders, in order of preference:
+	// if a regional mirror fails, the next one is tried.
+	Regions []string `json:"regions"`
+
+	// IPFSGateway is the trustless gateway of ipfs:// urls, like -ipfs-gateway.
+	IPFSGateway string `json:"ipfs_gateway"`
+}
+
+var conf config
+
+// loadConfig reads the config file, if there is one.
+func loadConfig() error {
+	if *configFile == "off" {
+		return nil
+	}
+	name := *configFile
+	if name == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		name = filepath.Join(dir, "go-fetch", "config.json")
+	}
+
+	buf, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) && *configFile == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, &conf); err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	if _, err := gofetch.ParseStatuses(conf.RetryOn); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	for key, mode := range conf.Unpack {
+		switch mode {
+		case "always", "never", "decompress":
+		default:
+			return fmt.Errorf("config: invalid unpack mode %q for %q", mode, key)
+		}
+	}
+	return nil
+}
+
+// unpackMode finds the configured unpack behavior for a download:
+// the longest matching extension of name wins, then its content type.
+func unpackMode(name, contentType string) string {
+	name = strings.ToLower(name)
+	var mode, ext string
+	for key, m := range conf.Unpack {
+		if strings.HasPrefix(key, ".") && len(key) > len(ext) &&
+			strings.HasSuffix(name, strings.ToLower(key)) {
+			mode, ext = m, key
+		}
+	}
+	if mode == "" && contentType != "" {
+		mode = conf.Unpack[strings.ToLower(contentType)]
+	}
+	return mode
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string, c config) { *configFile, conf = old, c }(*configFile, conf)
+
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{name: "valid", doc: `{"unpack": {".tgz": "always", ".zip": "never", ".gz": "decompress"}}`},
+		{name: "mode", doc: `{"unpack": {".tgz": "sometimes"}}`, wantErr: true},
+		{name: "retry", doc: `{"retry_on": "403,5xx"}`},
+		{name: "status", doc: `{"retry_on": "often"}`, wantErr: true},
+		{name: "malformed", doc: `{"unpack": [".tgz"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		*configFile, conf = filepath.Join(dir, tt.name+".json"), config{}
+		ioutil.WriteFile(*configFile, []byte(tt.doc), 0666)
+		if err := loadConfig(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: loadConfig() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	*configFile = filepath.Join(dir, "missing.json")
+	if err := loadConfig(); err == nil {
+		t.Error("missing -config file: want error")
+	}
+	*configFile = "off"
+	if err := loadConfig(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnpackMode(t *testing.T) {
+	defer func(c config) { conf = c }(conf)
+	conf.Unpack = map[string]string{
+		".gz":             "decompress",
+		".tar.gz":         "always",
+		".ZIP":            "never",
+		"application/zip": "always",
+	}
+
+	tests := []struct {
+		name, contentType, want string
+	}{
+		{"file.gz", "", "decompress"},
+		{"file.tar.gz", "", "always"},
+		{"FILE.TAR.GZ", "", "always"},
+		{"file.zip", "application/zip", "never"},
+		{"download", "application/zip", "always"},
+		{"download", "Application/Zip", "always"},
+		{"file.txt", "text/plain", ""},
+	}
+	for _, tt := range tests {
+		if got := unpackMode(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("unpackMode(%q, %q) = %q, want %q", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
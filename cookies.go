@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type cookieFlag []*http.Cookie
+
+// cookies are sent with the requests for sources,
+// and kept across redirects to the same host.
+var cookies cookieFlag
+
+func init() {
+	flag.Var(&cookies, "cookie", "send the cookie `name=value` with download requests (repeatable, or ; separated)")
+}
+
+func (c *cookieFlag) String() string {
+	var s []string
+	for _, k := range *c {
+		s = append(s, k.Name+"="+k.Value)
+	}
+	return strings.Join(s, "; ")
+}
+
+func (c *cookieFlag) Set(s string) error {
+	// there's no cookie parser, other than for requests,
+	// and it may accept names without values
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part != "" && !strings.Contains(part, "=") {
+			return fmt.Errorf("invalid cookie %q: want name=value", s)
+		}
+	}
+	req := http.Request{Header: http.Header{"Cookie": {s}}}
+	parsed := req.Cookies()
+	if len(parsed) == 0 {
+		return fmt.Errorf("invalid cookie %q: want name=value", s)
+	}
+	for _, k := range parsed {
+		k.Path = "/"
+	}
+	*c = append(*c, parsed...)
+	return nil
+}
+
+// cookieJar handles cookies across redirects, and requests.
+var cookieJar = newFileJar()
+
+// addCookies sets the -cookie values for a source.
+func addCookies(u *url.URL) {
+	if len(cookies) > 0 {
+		cookieJar.SetCookies(u, cookies)
+	}
+}
+
+// fileJar is a cookie jar that remembers the cookies it's given,
+// so that they can be saved to the -cookie-jar.
+type fileJar struct {
+	*cookiejar.Jar
+	mtx     sync.Mutex
+	entries map[string]jarEntry // by domain, path and name
+}
+
+type jarEntry struct {
+	*http.Cookie
+	host     string
+	hostOnly bool // or also for subdomains
+}
+
+func newFileJar() *fileJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &fileJar{Jar: jar, entries: map[string]jarEntry{}}
+}
+
+func (j *fileJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		c := *c
+		host := strings.ToLower(u.Hostname())
+		e := jarEntry{Cookie: &c, host: host, hostOnly: true}
+
+		// like the jar, refuse cookies for other sites
+		if d := strings.ToLower(strings.TrimPrefix(c.Domain, ".")); d != "" && d != host {
+			if !strings.HasSuffix(host, "."+d) {
+				continue
+			}
+			if ps, _ := publicsuffix.PublicSuffix(d); ps == d {
+				continue
+			}
+			e.host, e.hostOnly = d, false
+		}
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = "/"
+			if i := strings.LastIndex(u.Path, "/"); i > 0 {
+				c.Path = u.Path[:i]
+			}
+		}
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+
+		key := e.host + ";" + c.Path + ";" + c.Name
+		if c.MaxAge < 0 || !c.Expires.IsZero() && c.Expires.Before(now) {
+			delete(j.entries, key)
+		} else {
+			j.entries[key] = e
+		}
+	}
+}
+
+// loadCookieJar reads the -cookie-jar, if it exists.
+func loadCookieJar() error {
+	if *cookieJarFile == "" {
+		return nil
+	}
+	f, err := os.Open(*cookieJarFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = line[len("#HttpOnly_"):]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain, subdomains, path, secure, expires, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: invalid cookie line", *cookieJarFile, n)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid cookie expiry: %q", *cookieJarFile, n, fields[4])
+		}
+		if expires != 0 && time.Unix(expires, 0).Before(now) {
+			continue
+		}
+
+		host := strings.TrimPrefix(fields[0], ".")
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			HttpOnly: httpOnly,
+		}
+		if expires != 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		if fields[1] == "TRUE" {
+			c.Domain = host
+		}
+		u := &url.URL{Scheme: "http", Host: host, Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		cookieJar.SetCookies(u, []*http.Cookie{c})
+	}
+	return scan.Err()
+}
+
+// saveCookieJar writes the cookies received to the -cookie-jar.
+func saveCookieJar() error {
+	if *cookieJarFile == "" {
+		return nil
+	}
+
+	j := cookieJar
+	j.mtx.Lock()
+	var lines []string
+	for _, e := range j.entries {
+		domain, sub := e.host, "FALSE"
+		if !e.hostOnly {
+			domain, sub = "."+e.host, "TRUE"
+		}
+		if e.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		secure, expires := "FALSE", int64(0) // a session cookie
+		if e.Secure {
+			secure = "TRUE"
+		}
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		lines = append(lines, strings.Join([]string{
+			domain, sub, e.Path, secure, strconv.FormatInt(expires, 10), e.Name, e.Value,
+		}, "\t"))
+	}
+	j.mtx.Unlock()
+	sort.Strings(lines)
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+	// cookies are credentials
+	return ioutil.WriteFile(*cookieJarFile, []byte(b.String()), 0600)
+}
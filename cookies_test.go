@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCookieFlag(t *testing.T) {
+	var c cookieFlag
+	if err := c.Set("a=1; b=2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("c=3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.String(); got != "a=1; b=2; c=3" {
+		t.Errorf("-cookie = %q", got)
+	}
+	if err := c.Set("invalid"); err == nil {
+		t.Error("Set(invalid) want error")
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", MaxAge: 3600, HttpOnly: true})
+			http.Redirect(w, r, "/download", http.StatusFound)
+		case "/download":
+			if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+				http.Error(w, "no session", http.StatusForbidden)
+				return
+			}
+			if c, err := r.Cookie("consent"); err != nil || c.Value != "yes" {
+				http.Error(w, "no consent", http.StatusForbidden)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/login")
+
+	defer func(j *fileJar, c cookieFlag, f string) { cookieJar, cookies, *cookieJarFile = j, c, f }(cookieJar, cookies, *cookieJarFile)
+	cookieJar, cookies = newFileJar(), nil
+	*cookieJarFile = filepath.Join(t.TempDir(), "cookies.txt")
+	cookies.Set("consent=yes")
+
+	client := &http.Client{Jar: cookieJar}
+	addCookies(u)
+	res, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("cookies not sent: %s", res.Status)
+	}
+
+	if err := saveCookieJar(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ioutil.ReadFile(*cookieJarFile)
+	if !strings.Contains(string(saved), "#HttpOnly_127.0.0.1\tFALSE\t/\tFALSE\t") ||
+		!strings.Contains(string(saved), "\tsession\tabc\n") {
+		t.Errorf("-cookie-jar saved %q", saved)
+	}
+
+	// an expired cookie is dropped, when loaded
+	ioutil.WriteFile(*cookieJarFile, append(saved, "127.0.0.1\tFALSE\t/\tFALSE\t1\told\tgone\n"...), 0600)
+	cookieJar = newFileJar()
+	if err := loadCookieJar(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cookieJar.Cookies(u) {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	if got := strings.Join(names, "; "); got != "consent=yes; session=abc" && got != "session=abc; consent=yes" {
+		t.Errorf("-cookie-jar loaded %q", got)
+	}
+
+	ioutil.WriteFile(*cookieJarFile, []byte("invalid line\n"), 0600)
+	if err := loadCookieJar(); err == nil {
+		t.Error("loadCookieJar() of an invalid file: want error")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// fetchData decodes an RFC 2397 data URI.
+func fetchData(source string) (io.ReadCloser, *meta, error) {
+	i := strings.IndexByte(source, ',')
+	if i < 0 {
+		return nil, nil, errors.New("malformed data URI")
+	}
+	params, data := source[len("data:"):i], source[i+1:]
+
+	data, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := []byte(data)
+	if strings.HasSuffix(params, ";base64") {
+		// tolerate missing padding, and embedded white space
+		data = strings.Join(strings.Fields(data), "")
+		buf, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// data URIs carry no name
+	typ, _, _ := mime.ParseMediaType(strings.TrimSuffix(params, ";base64"))
+	return ioutil.NopCloser(bytes.NewReader(buf)), &meta{name: "data", contentType: typ}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestFetchData(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{source: "data:,hello", want: "hello"},
+		{source: "data:text/plain,hello%20world", want: "hello world"},
+		{source: "data:text/plain;charset=utf-8,a,b", want: "a,b"},
+		{source: "data:;base64,aGVsbG8=", want: "hello"},
+		{source: "data:application/octet-stream;base64,aGVsbG8", want: "hello"},
+		{source: "data:;base64,aGVs%0AbG8=", want: "hello"},
+		{source: "data:;base64,aGVs bG8=", want: "hello"},
+		{source: "data:", wantErr: true},
+		{source: "data:,%zz", wantErr: true},
+		{source: "data:;base64,!!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, m, err := fetchData(tt.source)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("fetchData(%q): want error", tt.source)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("fetchData(%q) error: %v", tt.source, err)
+			continue
+		}
+		got, _ := ioutil.ReadAll(r)
+		if string(got) != tt.want || m.name != "data" {
+			t.Errorf("fetchData(%q) = %q, %q; want %q", tt.source, got, m.name, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// downloads tracks the files saved during this run, so that jobs
+// that would download the same contents (the same digest, or urls that
+// redirect to the same final url) copy the first one instead.
+var downloads = dedup{entries: map[string]*dedupEntry{}}
+
+type dedup struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	owner *job
+	done  chan struct{}
+	path  string // the saved file, if the owner succeeded
+	meta  *meta
+}
+
+// reuse opens the file saved by another job for key,
+// waiting for it if it's still running.
+// Otherwise, it returns nil, and j becomes the owner of key.
+func (d *dedup) reuse(j *job, key string) (io.ReadCloser, *meta) {
+	d.mu.Lock()
+	e := d.entries[key]
+	if e == nil {
+		d.entries[key] = &dedupEntry{owner: j, done: make(chan struct{})}
+		d.mu.Unlock()
+		return nil, nil
+	}
+	d.mu.Unlock()
+
+	<-e.done
+	if e.path == "" {
+		return nil, nil
+	}
+	// don't read a file while it's rewritten
+	target := j.target
+	if j.targetIsDir {
+		target = filepath.Join(target, filepath.Base(e.meta.name))
+	}
+	if target, err := filepath.Abs(target); err != nil || target == e.path {
+		return nil, nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, nil
+	}
+	return f, e.meta
+}
+
+// release publishes the file j saved, if it kept the raw download,
+// to the jobs waiting for the keys it owns.
+func (d *dedup) release(j *job, saved bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if e.owner != j {
+			continue
+		}
+		if saved && !j.unpack && !j.stdout {
+			e.path, e.meta = j.destination, j.meta
+		}
+		e.owner = nil
+		close(e.done)
+	}
+}
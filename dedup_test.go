@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/final" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string, e map[string]*dedupEntry) { *history, downloads.entries = old, e }(*history, downloads.entries)
+	*history = "off"
+	downloads.entries = map[string]*dedupEntry{}
+
+	sum := sha256.Sum256([]byte("hello"))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	dir := t.TempDir()
+	tests := []struct {
+		source string
+		digest string
+	}{
+		{source: "/final", digest: digest},
+		{source: "/other", digest: digest},
+		{source: "/latest"},
+		{source: "/final"},
+	}
+	for i, tt := range tests {
+		target := filepath.Join(dir, string(rune('a'+i)))
+		j := newJob(srv.URL+tt.source, target)
+		j.digest = tt.digest
+		if err := j.run(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadFile(target); string(got) != "hello" {
+			t.Errorf("%s wrote %q", tt.source, got)
+		}
+	}
+	// a shared digest needs no request; a shared final url is still requested
+	if requests != 3 {
+		t.Errorf("got %d downloads, want 3", requests)
+	}
+}
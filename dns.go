@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// newResolver creates a resolver that uses the DNS server at addr,
+// or if that's empty, the DNS-over-HTTPS (RFC 8484) server at doh.
+func newResolver(addr, doh string) *net.Resolver {
+	if doh != "" {
+		// looking up the DoH server itself uses the system resolver
+		client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, url: doh, client: client}, nil
+			},
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	var dialer net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+type resolveFlag map[string][]string
+
+// resolves maps host:port to the addresses to dial instead.
+var resolves = resolveFlag{}
+
+func init() {
+	flag.Var(resolves, "resolve", "given `host:port:addr[,addr]`, connect to addr instead of resolving host (repeatable)")
+}
+
+func (r resolveFlag) String() string {
+	var s []string
+	for k, addrs := range r {
+		s = append(s, k+":"+strings.Join(addrs, ","))
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+func (r resolveFlag) Set(s string) error {
+	var parts []string
+	if strings.HasPrefix(s, "[") {
+		// an IPv6 literal host
+		if i := strings.Index(s, "]:"); i > 0 {
+			parts = append([]string{s[1:i]}, strings.SplitN(s[i+2:], ":", 2)...)
+		}
+	} else {
+		parts = strings.SplitN(s, ":", 3)
+	}
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return fmt.Errorf("expected host:port:addr, got %q", s)
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 16); err != nil {
+		return fmt.Errorf("invalid port in %q", s)
+	}
+	var addrs []string
+	for _, a := range strings.Split(parts[2], ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(a, "["), "]")
+		if net.ParseIP(a) == nil {
+			return fmt.Errorf("invalid address %q in %q", a, s)
+		}
+		addrs = append(addrs, net.JoinHostPort(a, parts[1]))
+	}
+	r[net.JoinHostPort(asciiHost(parts[0]), parts[1])] = addrs
+	return nil
+}
+
+// asciiHost converts an internationalized host name to punycode,
+// as it's resolved and dialed.
+func asciiHost(host string) string {
+	if a, err := idna.Lookup.ToASCII(host); err == nil {
+		return a
+	}
+	return strings.ToLower(host)
+}
+
+// dialResolved wraps dial to connect to the -resolve addresses
+// of mapped hosts, trying each in turn.
+func dialResolved(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs := resolves[strings.ToLower(addr)]
+		if addrs == nil {
+			return dial(ctx, network, addr)
+		}
+		var err error
+		for _, a := range addrs {
+			var c net.Conn
+			if c, err = dial(ctx, network, a); err == nil {
+				return c, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// dohConn carries DNS messages over HTTPS.
+//
+// Since it's not a net.PacketConn, the resolver frames messages
+// as it does for TCP: each is prefixed by its 2 byte length.
+type dohConn struct {
+	ctx    context.Context
+	url    string
+	client *http.Client
+	req    bytes.Buffer
+	res    bytes.Buffer
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	c.req.Write(p)
+	buf := c.req.Bytes()
+	if len(buf) < 2 || len(buf) < 2+int(binary.BigEndian.Uint16(buf)) {
+		return len(p), nil
+	}
+	msg := buf[2 : 2+int(binary.BigEndian.Uint16(buf))]
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(c.ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, errors.New("DoH error: " + res.Status)
+	}
+	ans, err := ioutil.ReadAll(io.LimitReader(res.Body, 65535+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(ans) > 65535 {
+		return 0, errors.New("DoH response too large")
+	}
+
+	c.req.Reset()
+	binary.Write(&c.res, binary.BigEndian, uint16(len(ans)))
+	c.res.Write(ans)
+	return len(p), nil
+}
+
+func (c *dohConn) Read(p []byte) (int, error) { return c.res.Read(p) }
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return nil }
+func (c *dohConn) RemoteAddr() net.Addr               { return nil }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNewResolver(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(dnsAnswer(buf[:n], net.IPv4(192, 0, 2, 1)), addr)
+		}
+	}()
+
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(query, net.IPv4(192, 0, 2, 2)))
+	}))
+	defer doh.Close()
+
+	tests := []struct {
+		addr, doh string
+		want      []string
+	}{
+		{addr: udp.LocalAddr().String(), want: []string{"192.0.2.1"}},
+		{doh: doh.URL, want: []string{"192.0.2.2"}},
+	}
+	for _, tt := range tests {
+		got, err := newResolver(tt.addr, tt.doh).LookupHost(context.Background(), "artifacts.example.")
+		if err != nil {
+			t.Errorf("newResolver(%q, %q): %v", tt.addr, tt.doh, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("newResolver(%q, %q) = %q, want %q", tt.addr, tt.doh, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		key     string
+		want    []string
+		wantErr bool
+	}{
+		{in: "Example.com:443:127.0.0.1", key: "example.com:443", want: []string{"127.0.0.1:443"}},
+		{in: "example.com:80:192.0.2.1,[::1]", key: "example.com:80", want: []string{"192.0.2.1:80", "[::1]:80"}},
+		{in: "[::1]:8080:127.0.0.1", key: "[::1]:8080", want: []string{"127.0.0.1:8080"}},
+		{in: "bücher.example:443:127.0.0.1", key: "xn--bcher-kva.example:443", want: []string{"127.0.0.1:443"}},
+		{in: "example.com:443", wantErr: true},
+		{in: "[::1:443:127.0.0.1", wantErr: true},
+		{in: "example.com:https:127.0.0.1", wantErr: true},
+		{in: "example.com:443:localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := resolveFlag{}
+		err := r.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(r[tt.key], tt.want) {
+			t.Errorf("Set(%q) = %v, want %q: %q", tt.in, r, tt.key, tt.want)
+		}
+	}
+}
+
+func TestConfigureClient_resolve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	defer func(old *http.Client) { client = old }(client)
+	defer func() { delete(resolves, "artifacts.example:"+u.Port()) }()
+	if err := resolves.Set("artifacts.example:" + u.Port() + ":127.0.0.2," + u.Hostname()); err != nil {
+		t.Fatal(err)
+	}
+	configureClient()
+
+	// nothing listens on the first address, so the second is tried
+	res, err := client.Get("http://artifacts.example:" + u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "artifacts.example:" + u.Port(); string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// dnsAnswer answers A queries with ip, and others with no records.
+func dnsAnswer(query []byte, ip net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += 1 + int(query[end])
+	}
+	end += 5 // root label, type and class
+	if end > len(query) {
+		return nil
+	}
+
+	var res bytes.Buffer
+	res.Write(query[:2])                                 // id
+	binary.Write(&res, binary.BigEndian, uint16(0x8180)) // response, recursion
+	binary.Write(&res, binary.BigEndian, uint16(1))      // questions
+	if binary.BigEndian.Uint16(query[end-4:]) != 1 {
+		res.Write(make([]byte, 6))
+		res.Write(query[12:end])
+		return res.Bytes()
+	}
+	binary.Write(&res, binary.BigEndian, uint16(1)) // answers
+	res.Write(make([]byte, 4))
+	res.Write(query[12:end])
+	binary.Write(&res, binary.BigEndian, []uint16{0xc00c, 1, 1, 0, 60, 4})
+	res.Write(ip.To4())
+	return res.Bytes()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+var compressed = true
+
+func init() {
+	flag.BoolVar(&compressed, "compressed", compressed, "ask for a compressed transfer (Content-Encoding gzip or zstd), and decode it; progress counts the bytes transferred")
+	flag.Var(noFlag{&compressed}, "no-compressed", "don't ask for a compressed transfer; same as -compressed=false")
+}
+
+// noFlag is a bool flag that negates another.
+type noFlag struct{ b *bool }
+
+func (f noFlag) IsBoolFlag() bool { return true }
+
+func (f noFlag) String() string {
+	if f.b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*f.b)
+}
+
+func (f noFlag) Set(v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*f.b = !b
+	return nil
+}
+
+// encodingTransport asks for compressed responses, and decodes them,
+// like the default transport does for gzip, but also for brotli and zstd.
+// Unlike -unpack, this is about how the payload is sent, not its format.
+type encodingTransport struct {
+	http.RoundTripper
+}
+
+func (t encodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// ranges are of the encoded bytes
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" || req.Method == http.MethodHead {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", gofetch.AcceptEncoding)
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || res.Body == nil || res.Body == http.NoBody {
+		return res, err
+	}
+
+	coding := res.Header.Get("Content-Encoding")
+	if coding == "" {
+		return res, nil
+	}
+	body := &decodedReader{}
+	dec, err := gofetch.Decode(readCloser{io.TeeReader(res.Body, &body.encoded), res.Body}, coding)
+	if err != nil {
+		log.Printf("not decoding %s: %v", req.URL.Redacted(), err)
+		return res, nil
+	}
+	body.ReadCloser = dec
+
+	// like the default transport, the response describes the decoded body;
+	// the encoded size is kept for progress
+	body.size = res.ContentLength
+	res.Body = body
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// decodedBody returns the decoder of a response body, if it's decoded.
+func decodedBody(body io.Reader) *decodedReader {
+	if w, ok := body.(*cacheWriter); ok {
+		body = w.ReadCloser
+	}
+	d, _ := body.(*decodedReader)
+	return d
+}
+
+// decodedReader is a decoded body,
+// that counts the encoded bytes read, for progress.
+type decodedReader struct {
+	io.ReadCloser
+	size    int64   // encoded, or -1
+	encoded counter // bytes read from the encoded body
+}
+
+// readCloser reads from one reader, and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestEncodingTransport(t *testing.T) {
+	want := strings.Repeat("hello, world\n", 20)
+
+	var gz, br bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(want))
+	zw.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write([]byte(want))
+	bw.Close()
+	zst, _ := hex.DecodeString("28b52ffd0468ad00007068656c6c6f2c20776f726c640a68010073a0a612e29bddbd")
+
+	bodies := map[string][]byte{
+		"gzip":     gz.Bytes(),
+		"br":       br.Bytes(),
+		"zstd":     zst,
+		"compress": []byte("as is"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coding := strings.TrimPrefix(r.URL.Path, "/")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), coding) && coding != "compress" {
+			http.Error(w, "not accepted", http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Encoding", coding)
+		w.Write(bodies[coding])
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: encodingTransport{http.DefaultTransport}}
+	for coding, body := range bodies {
+		res, err := client.Get(srv.URL + "/" + coding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", coding, err)
+		}
+
+		if coding == "compress" {
+			if !bytes.Equal(got, body) || res.Header.Get("Content-Encoding") != coding {
+				t.Errorf("%s: got %q, want it as is", coding, got)
+			}
+		} else if string(got) != want || res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: got %q, want %q", coding, got, want)
+		}
+	}
+}
+
+func TestEncodingTransport_encodedSize(t *testing.T) {
+	want := strings.Repeat("hello, world\n", 200)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(want))
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(gz.Len()))
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: encodingTransport{http.DefaultTransport}}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	got, _ := ioutil.ReadAll(res.Body)
+
+	d := decodedBody(res.Body)
+	if d == nil || string(got) != want || res.ContentLength != -1 {
+		t.Fatalf("got %d bytes, Content-Length %d, decoder %v", len(got), res.ContentLength, d)
+	}
+	if d.size != int64(gz.Len()) || d.encoded.load() != int64(gz.Len()) {
+		t.Errorf("encoded size %d, read %d; want %d", d.size, d.encoded.load(), gz.Len())
+	}
+}
+
+func TestNoFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want bool
+	}{
+		{args: nil, want: true},
+		{args: []string{"-no-compressed"}, want: false},
+		{args: []string{"-no-compressed=false"}, want: true},
+		{args: []string{"-compressed=false"}, want: false},
+		{args: []string{"-no-compressed", "-compressed"}, want: true},
+	} {
+		b := true
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		fs.BoolVar(&b, "compressed", b, "")
+		fs.Var(noFlag{&b}, "no-compressed", "")
+		if err := fs.Parse(tt.args); err != nil || b != tt.want {
+			t.Errorf("%q: compressed = %v, %v", tt.args, b, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package fetch
+
+import (
+	"io"
+	"sync"
+)
+
+// aheadBlock is the size of the blocks read ahead.
+const aheadBlock = 1 << 20
+
+// aheadReader reads from a reader on its own goroutine, ahead of its
+// consumer, so that reading, decompressing and unpacking a download
+// can each use a different core.
+type aheadReader struct {
+	blocks chan []byte // read, in order
+	free   chan []byte // to read into
+	done   chan struct{}
+	once   sync.Once
+	err    error // set before blocks is closed
+	buf    []byte
+	cur    []byte // the unread part of buf
+}
+
+// readAhead starts reading r, up to n blocks ahead.
+func readAhead(r io.Reader, n int) *aheadReader {
+	a := &aheadReader{
+		blocks: make(chan []byte, n),
+		free:   make(chan []byte, n),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		a.free <- make([]byte, aheadBlock)
+	}
+	go a.fill(r)
+	return a
+}
+
+func (a *aheadReader) fill(r io.Reader) {
+	defer close(a.blocks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-a.free:
+		case <-a.done:
+			a.err = io.ErrClosedPipe
+			return
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			a.blocks <- buf[:n]
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if err != nil {
+			a.err = err
+			return
+		}
+	}
+}
+
+func (a *aheadReader) Read(p []byte) (int, error) {
+	for len(a.cur) == 0 {
+		if a.buf != nil {
+			a.free <- a.buf[:cap(a.buf)]
+			a.buf = nil
+		}
+		buf, ok := <-a.blocks
+		if !ok {
+			return 0, a.err
+		}
+		a.buf, a.cur = buf, buf
+	}
+	n := copy(p, a.cur)
+	a.cur = a.cur[n:]
+	return n, nil
+}
+
+// Close stops reading ahead, after the current block.
+func (a *aheadReader) Close() error {
+	a.once.Do(func() { close(a.done) })
+	return nil
+}
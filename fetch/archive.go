@@ -0,0 +1,1100 @@
+package fetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krolaw/zipstream"
+	"github.com/ncruces/go-fetch/internal/zstd"
+	"golang.org/x/text/encoding"
+)
+
+func (u *unpacker) uncompress(r *bufio.Reader) error {
+	// archives can't be extracted to stdout, other than a single entry
+	f := u.f
+	extract := f.unpack != UnpackDecompress && (!u.stdout || f.entry != "")
+
+	// a forced format overrides magic, which overrides the hint
+	var format string
+	if u.format != "" {
+		format, u.format = nextFormat(u.format)
+	} else {
+		magic, _ := r.Peek(264)
+		format = SniffFormat(magic)
+		format, u.hint = hintedFormat(format, u.hint)
+	}
+
+	switch {
+	case format == "gz":
+		if f.decompressJobs > 0 && bgzfSize(r) > 0 {
+			// members record their size, decompress them in parallel
+			u.targetName = strings.TrimSuffix(u.targetName, ".gz")
+			zb := newGzipBlocks(r, f.decompressJobs, u.trailer)
+			defer zb.Close()
+			if err := u.uncompress(bufio.NewReader(zb)); err != nil {
+				return err
+			}
+			_, err := io.Copy(ioutil.Discard, zb)
+			return err
+		}
+		if f.decompressJobs > 0 {
+			// read and decompress on their own goroutines
+			in := readAhead(r, f.decompressJobs)
+			defer in.Close()
+			r = bufio.NewReader(in)
+		}
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		if zr.Name != "" {
+			u.targetName = zr.Name
+		} else {
+			u.targetName = strings.TrimSuffix(u.targetName, ".gz")
+		}
+
+		// read the rest of the stream, to check it,
+		// and find any trailing data
+		var zm io.Reader = &gzipMembers{zr: zr, r: r, trailer: u.trailer}
+		if f.decompressJobs > 0 {
+			out := readAhead(zm, f.decompressJobs)
+			defer out.Close()
+			zm = out
+		}
+		if err := u.uncompress(bufio.NewReader(zm)); err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, zm)
+		return err
+
+	case format == "bz2":
+		u.targetName = strings.TrimSuffix(u.targetName, ".bz2")
+		br := bzip2.NewReader(r)
+		return u.uncompress(bufio.NewReader(&bzip2Trailer{r: br, logf: f.log}))
+
+	case format == "zst":
+		u.targetName = strings.TrimSuffix(u.targetName, ".zst")
+		return u.uncompress(bufio.NewReader(zstd.NewReader(r)))
+
+	case extract && format == "zip":
+		if f.zipPassword != "" {
+			z, err := spoolZip(r, f.zipPassword)
+			if err != nil {
+				return err
+			}
+			defer z.Close()
+			return u.extract(z)
+		}
+		return u.extract(zipstream.NewReader(r))
+
+	case extract && format == "tar":
+		return u.extract(newTarArchive(r))
+
+	case f.entry != "" && u.nested == 0:
+		return errors.New("extracting an entry needs a zip or tar archive")
+
+	case u.list != nil:
+		return u.listFile(r)
+
+	default:
+		out, err := u.targetFile()
+		if err != nil {
+			return err
+		}
+		return f.write(r, out)
+	}
+}
+
+var gzipMagic = []byte("\x1f\x8b")
+
+// SniffFormat finds the format of a stream from its first bytes
+// (264 are enough): gz, bz2, zst, zip, tar, or raw.
+func SniffFormat(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gz"
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return "bz2"
+	case bytes.HasPrefix(magic, []byte("\x28\xb5\x2f\xfd")):
+		return "zst"
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")),
+		bytes.HasPrefix(magic, []byte("PK\x05\x06")), // empty
+		bytes.HasPrefix(magic, []byte("PK\x07\x08")): // spanned
+		return "zip"
+	case len(magic) > 257 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		return "tar"
+	}
+	return "raw"
+}
+
+// HintFormat guesses the format of a download from its Content-Type,
+// and its name (from Content-Disposition, or the url). The name wins
+// if it agrees, e.g. an application/x-tar named .tar.gz.
+func HintFormat(name, contentType string) string {
+	var typeFormat, nameFormat string
+	switch typ := strings.ToLower(contentType); {
+	case typ == "application/x-tar" || typ == "application/tar" || strings.HasSuffix(typ, ".tar"):
+		typeFormat = "tar"
+	case typ == "application/x-gtar" || typ == "application/x-compressed-tar" || strings.HasSuffix(typ, ".tar+gzip"):
+		typeFormat = "tar.gz"
+	}
+
+	name = strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.zst", ".tgz", ".tbz2", ".tbz", ".tzst", ".tar"} {
+		if strings.HasSuffix(name, ext) {
+			nameFormat, _ = ParseFormat(ext[1:])
+			break
+		}
+	}
+
+	if nameFormat != "" && strings.HasPrefix(nameFormat, typeFormat) {
+		return nameFormat
+	}
+	return typeFormat
+}
+
+// hintedFormat reconciles the sniffed format of a layer with the one
+// hinted at by the download, returning it and the rest of the hint.
+// Magic wins; only formats without magic, like old tar, are hinted.
+func hintedFormat(sniffed, hint string) (string, string) {
+	for hint != "" && hint != "raw" {
+		layer, rest := nextFormat(hint)
+		switch {
+		case layer == sniffed:
+			return sniffed, rest
+		case sniffed == "gz" || sniffed == "bz2" || sniffed == "zst":
+			// compression the hint doesn't describe,
+			// e.g. a gzipped application/x-tar
+			return sniffed, hint
+		case sniffed != "raw":
+			return sniffed, ""
+		case layer == "tar":
+			return layer, rest
+		}
+		// a compression layer without its magic, e.g. because
+		// the server sent it with a Content-Encoding, is skipped
+		hint = rest
+	}
+	return sniffed, ""
+}
+
+// formatAliases expands the short names of formats.
+var formatAliases = map[string]string{
+	"tgz":   "tar.gz",
+	"tbz":   "tar.bz2",
+	"tbz2":  "tar.bz2",
+	"tzst":  "tar.zst",
+	"gzip":  "gz",
+	"bzip2": "bz2",
+	"zstd":  "zst",
+}
+
+// ParseFormat checks a format, expanding aliases: raw, or an archive
+// (tar or zip) or a file, compressed in layers (gz, bz2 or zst), like
+// tar.gz; tgz, tbz, tbz2, tzst, gzip, bzip2 and zstd are aliases.
+func ParseFormat(format string) (string, error) {
+	if f, ok := formatAliases[format]; ok {
+		format = f
+	}
+	if format == "raw" {
+		return format, nil
+	}
+	layers := strings.Split(format, ".")
+	for i, layer := range layers {
+		switch layer {
+		case "gz", "bz2", "zst":
+		case "tar", "zip":
+			if i == 0 {
+				continue
+			}
+			fallthrough
+		default:
+			return "", fmt.Errorf("unsupported format: %q", format)
+		}
+	}
+	return format, nil
+}
+
+// nextFormat splits the outermost layer of a format from the rest,
+// which is raw, once all layers are unwrapped.
+func nextFormat(format string) (layer, rest string) {
+	if i := strings.LastIndexByte(format, '.'); i >= 0 {
+		return format[i+1:], format[:i]
+	}
+	return format, "raw"
+}
+
+// gzipMembers reads a multi-member gzip stream,
+// stopping at anything other than another member,
+// e.g. an appended signature.
+type gzipMembers struct {
+	zr      *gzip.Reader
+	r       *bufio.Reader
+	eof     bool
+	trailer func(io.Reader) error
+}
+
+func (g *gzipMembers) Read(p []byte) (int, error) {
+	if g.eof {
+		return 0, io.EOF
+	}
+	g.zr.Multistream(false)
+	for {
+		n, err := g.zr.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+
+		if magic, _ := g.r.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+			g.eof = true
+			return 0, g.trailer(g.r)
+		}
+		if err := g.zr.Reset(g.r); err != nil {
+			return 0, err
+		}
+		g.zr.Multistream(false)
+	}
+}
+
+// bzip2Trailer ignores data after the end of a bzip2 stream.
+type bzip2Trailer struct {
+	r    io.Reader
+	eof  bool
+	logf func(format string, v ...interface{})
+}
+
+func (b *bzip2Trailer) Read(p []byte) (int, error) {
+	if b.eof {
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p)
+	if err == bzip2.StructuralError("bad magic value in continuation file") {
+		b.logf("ignoring trailing data after bzip2 stream")
+		err = io.EOF
+	}
+	b.eof = err == io.EOF
+	return n, err
+}
+
+// trailer handles data found after the end of a compressed stream,
+// saving it to the trailer file, if configured, and otherwise ignoring it.
+// It returns io.EOF on success.
+func (u *unpacker) trailer(r io.Reader) error {
+	if u.f.trailer == "" {
+		n, err := io.Copy(ioutil.Discard, r)
+		if n > 0 {
+			u.f.log("ignoring %d bytes of trailing data", n)
+		}
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	f, err := os.Create(u.f.trailer)
+	if err != nil {
+		return err
+	}
+	if err := u.f.write(r, f); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (u *unpacker) extract(a io.Reader) error {
+	if u.list != nil {
+		return u.listArchive(a)
+	}
+	if u.f.entry != "" {
+		return u.extractEntry(a)
+	}
+	if u.f.clean && u.nested == 0 {
+		if err := cleanDir(u.target); err != nil {
+			return err
+		}
+	}
+	if u.f.stripTop && u.nested == 0 {
+		return u.unarchiveTop(a, u.target)
+	}
+	return u.unarchive(a, u.target)
+}
+
+// extractEntry writes the one entry wanted of an archive to the target.
+func (u *unpacker) extractEntry(a io.Reader) error {
+	want := path.Clean(strings.TrimPrefix(u.f.entry, "./"))
+	for {
+		e, err := u.next(a)
+		if err == io.EOF {
+			return fmt.Errorf("archive has no entry %q", u.f.entry)
+		}
+		if err != nil {
+			return err
+		}
+		if path.Clean(strings.TrimPrefix(e.name, "./")) != want {
+			continue
+		}
+		if !e.Mode().IsRegular() || e.hardlink {
+			return fmt.Errorf("archive entry %q isn't a regular file", u.f.entry)
+		}
+		if err := u.f.checkPolicy(e); err != nil {
+			return err
+		}
+
+		u.targetName = path.Base(want)
+		f, err := u.targetFile()
+		if err != nil {
+			return err
+		}
+		if e.hasMode && f != os.Stdout {
+			f.Chmod(u.f.policyMode(e).Perm())
+		}
+		return u.f.write(a, f)
+	}
+}
+
+// cleanDir removes the contents of dir, the target of unpacking,
+// refusing to clean a root or home directory, or any containing
+// the working directory.
+func cleanDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("can't clean %s: not a directory", dir)
+	}
+
+	refuse := filepath.Dir(dir) == dir
+	if home, err := os.UserHomeDir(); err == nil {
+		refuse = refuse || filepath.Clean(home) == dir
+	}
+	if wd, err := os.Getwd(); err == nil {
+		rel, err := filepath.Rel(dir, wd)
+		refuse = refuse || err == nil && rel != "." && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	if refuse {
+		return fmt.Errorf("refusing to clean %s", dir)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// RemoveAll doesn't follow links out of dir
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unarchiveTop unarchives to a staging directory, then moves its
+// contents to dir, less the top directory, if all entries are in one.
+func (u *unpacker) unarchiveTop(r io.Reader, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	stage, err := ioutil.TempDir(dir, PartialPrefix+"*"+PartialSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	if err := u.unarchive(r, stage); err != nil {
+		return err
+	}
+	u.destination = dir
+
+	root := stage
+	entries, err := ioutil.ReadDir(stage)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(stage, entries[0].Name())
+	}
+
+	synced := parentDirs(dir + string(filepath.Separator))
+	if err := u.f.mergeDir(root, dir, synced); err != nil {
+		return err
+	}
+	u.created.move(longPath(root), longPath(dir))
+	return u.f.syncDirs(synced)
+}
+
+// mergeDir moves the contents of src into dst,
+// merging directories that exist in both.
+func (f *Fetcher) mergeDir(src, dst string, synced map[string]struct{}) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	synced[dst] = struct{}{}
+	for _, e := range entries {
+		from := filepath.Join(src, e.Name())
+		to := filepath.Join(dst, e.Name())
+		if e.IsDir() {
+			if fi, err := os.Lstat(to); err == nil && fi.IsDir() {
+				if err := f.mergeDir(from, to, synced); err != nil {
+					return err
+				}
+				continue
+			}
+		} else if skip, err := f.overwriteFile(to, e.Name(), e.ModTime()); err != nil {
+			return err
+		} else if skip {
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next returns the next archive entry that should be extracted,
+// with its name relative to the subdirectory being extracted.
+func (u *unpacker) next(a io.Reader) (*archiveEntry, error) {
+	for {
+		e, err := u.f.unarchiveNext(a)
+		if err != nil {
+			return e, err
+		}
+		name, ok := u.relName(e.name)
+		if !ok || u.f.filter != nil && !u.f.filter(path.Clean(strings.TrimPrefix(name, "./"))) {
+			continue
+		}
+		e.name = name
+		if e.hardlink {
+			e.link, _ = u.relName(e.link)
+		}
+		return e, nil
+	}
+}
+
+// relName makes an entry name relative to the subdirectory being
+// extracted, and strips the configured leading elements from it.
+// Entries outside the subdirectory, or too shallow, are skipped.
+func (u *unpacker) relName(name string) (string, bool) {
+	strip := u.f.strip
+	if u.nested > 0 {
+		strip = 0 // only the downloaded archive is stripped
+	}
+	if u.subdir == "" && strip <= 0 {
+		return name, true
+	}
+
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if u.subdir != "" {
+		rel := strings.TrimPrefix(name, u.subdir+"/")
+		if rel == name {
+			return "", false
+		}
+		name = rel
+	}
+	for i := 0; i < strip; i++ {
+		k := strings.IndexByte(name, '/')
+		if k < 0 {
+			return "", false
+		}
+		name = name[k+1:]
+	}
+	return name, true
+}
+
+func (u *unpacker) unarchive(r io.Reader, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	u.destination = dir
+	dir = longPath(dir) + string(filepath.Separator)
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	// directories to flush, like the parents of extracted files
+	synced := parentDirs(dir)
+	f := u.f
+	if u.budget == nil {
+		u.budget = &Budget{Limits: f.limits, Received: u.received}
+	}
+	budget := u.budget
+	guard := newLinkGuard(dir, f.fsync)
+	names := newNameGuard(f.names, f.log)
+	pool := newWritePool(f.workers)
+	defer pool.close()
+	var deref []derefLink
+	var stripped, skipped int
+	for {
+		e, err := u.next(r)
+		if err == io.EOF {
+			if err := pool.close(); err != nil {
+				return err
+			}
+			if stripped > 0 {
+				f.log("stripped setuid, setgid and sticky bits (%d files)", stripped)
+			}
+			if skipped > 0 {
+				f.log("skipped %d symlinks", skipped)
+			}
+			if err := guard.deref(deref); err != nil {
+				return err
+			}
+			for _, l := range deref {
+				u.created.add(l.path)
+			}
+			return f.syncDirs(synced)
+		}
+		if err != nil {
+			return err
+		}
+		if err := f.checkPolicy(e); err != nil {
+			return err
+		}
+		if e.name, err = names.check(e.name); err != nil {
+			return err
+		} else if e.name == "" {
+			continue
+		}
+		if e.hardlink {
+			e.link = names.link(e.link)
+		}
+		if err := budget.AddFile(); err != nil {
+			return err
+		}
+
+		name, fi := e.name, e.FileInfo
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, dir) {
+			return fmt.Errorf("illegal file path %q", name)
+		}
+		if err := guard.checkParents(path); err != nil {
+			return err
+		}
+		// archives needn't list the parents of their entries
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		synced[filepath.Dir(path)] = struct{}{}
+		if err := pool.wait(path); err != nil {
+			return err
+		}
+
+		if bits := e.Mode() & suidBits; bits != 0 && e.hasMode && !e.hardlink {
+			if f.policyMode(e)&suidBits == bits {
+				f.log("keeping %s bits of %q", suidNames(bits), name)
+			} else {
+				stripped++
+			}
+		}
+
+		switch mode := f.policyMode(e); {
+		case e.hardlink:
+			old := filepath.Join(dir, filepath.FromSlash(e.link))
+			if e.link == "" || !strings.HasPrefix(old, dir) {
+				return fmt.Errorf("illegal hardlink %q to %q", name, e.link)
+			}
+			if err := guard.checkParents(old); err != nil {
+				return err
+			}
+			if err := pool.wait(old); err != nil {
+				return err
+			}
+			if sameFile(path, old) {
+				u.created.add(path)
+				continue
+			}
+			if skip, err := f.overwriteFile(path, name, fi.ModTime()); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+			if err := os.Link(old, path); err != nil {
+				return err
+			}
+
+		case mode.IsDir():
+			if err := os.MkdirAll(path, unarchivePerm(mode)); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			if f.unchangedFile(path, e, mode) {
+				u.created.add(path)
+				continue
+			}
+			if skip, err := f.overwriteFile(path, name, fi.ModTime()); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+			nested := u.nested < f.depth && nestedArchiveExt(name) != ""
+			w := &fileWrite{f: f, path: path, name: name, mode: mode, entry: e}
+
+			var n int64
+			if fi.Size() <= smallFile && !e.sparse && !e.unsized && !nested {
+				w.data, err = ioutil.ReadAll(budget.Reader(r))
+				n = int64(len(w.data))
+			} else {
+				w.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+				if err != nil {
+					return err
+				}
+				if e.sparse {
+					n, err = copySparse(w.file, budget.Reader(r))
+				} else {
+					n, err = io.Copy(w.file, budget.Reader(r))
+				}
+				if err != nil {
+					w.file.Close()
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("error writing to %q: %w", name, err)
+			}
+			if size := fi.Size(); n != size && !e.unsized {
+				if w.file != nil {
+					w.file.Close()
+				}
+				return fmt.Errorf("wrote %d bytes to %q; expected %d", n, name, size)
+			}
+
+			if nested {
+				if err := w.run(); err != nil {
+					return err
+				}
+				if err := u.unpackNested(path); err != nil {
+					return fmt.Errorf("error unpacking %q: %w", name, err)
+				}
+				continue
+			}
+			if err := pool.submit(w); err != nil {
+				return err
+			}
+			u.created.add(path)
+			continue
+
+		case mode&os.ModeSymlink != 0:
+			old, err := unarchiveLink(e, r)
+			if err != nil {
+				return err
+			}
+			switch f.symlinks {
+			case SymlinksSkip:
+				skipped++
+				continue
+			case SymlinksRewrite:
+				old = guard.rewrite(filepath.Dir(path), old)
+			}
+			if _, err := guard.resolve(filepath.Dir(path), old); err != nil {
+				return fmt.Errorf("symlink %q to %q: %w", name, old, err)
+			}
+			if f.symlinks == SymlinksDeref {
+				if skip, err := f.overwriteFile(path, name, fi.ModTime()); err != nil {
+					return err
+				} else if !skip {
+					deref = append(deref, derefLink{path, name, old})
+				}
+				continue
+			}
+			if f.unchangedLink(path, old) {
+				u.created.add(path)
+				continue
+			}
+			if skip, err := f.overwriteFile(path, name, fi.ModTime()); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+
+			err = os.Symlink(old, path)
+			if err != nil {
+				return err
+			}
+
+		case f.special && mode&(os.ModeNamedPipe|os.ModeDevice) != 0:
+			if mode&os.ModeDevice != 0 && os.Geteuid() != 0 {
+				f.log("skipping device %q: not running as root", name)
+				continue
+			}
+			if skip, err := f.overwriteFile(path, name, fi.ModTime()); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+			if err := mknod(path, e, mode); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("archive contained unsupported file %q of type %v", name, mode)
+		}
+		if err := f.chownEntry(path, e, f.policyMode(e)); err != nil {
+			return err
+		}
+		// after chown, which clears security.capability
+		if err := f.setXattrs(path, e, f.policyMode(e)); err != nil {
+			return err
+		}
+		u.created.add(path)
+	}
+}
+
+// nestedArchiveExt returns the extension of name,
+// if it's that of an archive that should be unpacked in turn.
+func nestedArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.zst", ".tgz", ".tbz2", ".tzst", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// unpackNested replaces an extracted archive with a directory,
+// named after it, holding its contents.
+func (u *unpacker) unpackNested(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	nested := &unpacker{
+		f:           u.f,
+		target:      strings.TrimSuffix(path, nestedArchiveExt(path)),
+		targetIsDir: true,
+		targetName:  filepath.Base(path),
+		received:    u.received,
+		budget:      u.budget,
+		nested:      u.nested + 1,
+		created:     u.created,
+	}
+	defer nested.discardPartial()
+	if err := nested.uncompress(bufio.NewReader(file)); err != nil {
+		return err
+	}
+	if err := nested.commitPartial(); err != nil {
+		return err
+	}
+	file.Close()
+	u.created.remove(path)
+	if fi, err := os.Stat(nested.destination); err == nil && fi.IsDir() {
+		u.created.add(nested.destination)
+	}
+	return os.Remove(path)
+}
+
+// overwriteFile applies the Overwrite policy to an entry that's about
+// to be extracted to path: it reports if the entry should be skipped,
+// or fails, if there's a file there; otherwise, the file is removed,
+// so it's replaced, rather than written through, if it's a link.
+func (f *Fetcher) overwriteFile(path, name string, mtime time.Time) (skip bool, err error) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.IsDir() {
+		return false, nil
+	}
+	switch f.overwrite {
+	case OverwriteNever:
+		return true, nil
+	case OverwriteNewer:
+		if !mtime.After(fi.ModTime()) {
+			return true, nil
+		}
+	case OverwriteError:
+		return false, fmt.Errorf("archive entry %q would overwrite %s", name, path)
+	}
+	return false, os.Remove(path)
+}
+
+// unchangedFile reports if the file at path is the same as an entry
+// previously extracted there, by size and modification time,
+// so that unpacking again doesn't touch it; permissions are updated.
+func (f *Fetcher) unchangedFile(path string, entry *archiveEntry, mode os.FileMode) bool {
+	if f.overwrite != OverwriteAlways && f.overwrite != OverwriteNewer || entry.ModTime().IsZero() {
+		return false
+	}
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() ||
+		fi.Size() != entry.Size() || !fi.ModTime().Equal(entry.ModTime()) {
+		return false
+	}
+	if entry.hasMode && fi.Mode().Perm() != mode.Perm() {
+		return os.Chmod(path, mode) == nil
+	}
+	return true
+}
+
+// sameFile reports if two paths are links to the same file.
+func sameFile(a, b string) bool {
+	fa, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Lstat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// unchangedLink reports if path is already a link to old.
+func (f *Fetcher) unchangedLink(path, old string) bool {
+	if f.overwrite != OverwriteAlways && f.overwrite != OverwriteNewer {
+		return false
+	}
+	link, err := os.Readlink(path)
+	return err == nil && link == old
+}
+
+func unarchivePerm(mode os.FileMode) os.FileMode {
+	if mode&0007 != 0 {
+		mode |= 0001
+	}
+	if mode&0070 != 0 {
+		mode |= 0010
+	}
+	return mode | 0300
+}
+
+// archiveEntry describes a file in an archive.
+type archiveEntry struct {
+	os.FileInfo
+	name     string
+	link     string
+	hardlink bool
+	hasMode  bool // zip local headers don't record permissions
+	uid, gid int
+	uname    string
+	gname    string
+	xattrs   map[string]string
+	sparse   bool
+	unsized  bool // streamed zip entries with a data descriptor
+
+	devmajor, devminor int64
+}
+
+// isSparse reports if a tar entry is a sparse file, in the old GNU
+// format, or the GNU PAX formats.
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range h.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// copySparse copies a sparse file from r to f, seeking over blocks
+// of zeros, which the tar reader returns for holes, to leave holes.
+func copySparse(f *os.File, r io.Reader) (int64, error) {
+	const block = 4096
+	buf := make([]byte, 32*block)
+	var n int64
+	for {
+		m, err := io.ReadFull(r, buf)
+		for i := 0; i < m; i += block {
+			b := buf[i:m]
+			if len(b) > block {
+				b = b[:block]
+			}
+			var werr error
+			if isZero(b) {
+				_, werr = f.Seek(int64(len(b)), io.SeekCurrent)
+			} else {
+				_, werr = f.Write(b)
+			}
+			if werr != nil {
+				return n, werr
+			}
+			n += int64(len(b))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a trailing hole needs the size set
+			return n, f.Truncate(n)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// paxXattrs finds the extended attributes in PAX records.
+func paxXattrs(records map[string]string) map[string]string {
+	const prefix = "SCHILY.xattr."
+	var xattrs map[string]string
+	for key, value := range records {
+		if strings.HasPrefix(key, prefix) {
+			if xattrs == nil {
+				xattrs = map[string]string{}
+			}
+			xattrs[key[len(prefix):]] = value
+		}
+	}
+	return xattrs
+}
+
+func (f *Fetcher) unarchiveNext(a io.Reader) (*archiveEntry, error) {
+	switch v := a.(type) {
+	case *tarArchive:
+		h, err := v.Next()
+		if err != nil {
+			return nil, v.tail.check(err)
+		}
+		return &archiveEntry{
+			FileInfo: h.FileInfo(),
+			name:     h.Name,
+			link:     h.Linkname,
+			hardlink: h.Typeflag == tar.TypeLink,
+			hasMode:  true,
+			uid:      h.Uid,
+			gid:      h.Gid,
+			uname:    h.Uname,
+			gname:    h.Gname,
+			xattrs:   paxXattrs(h.PAXRecords),
+			sparse:   isSparse(h),
+			devmajor: h.Devmajor,
+			devminor: h.Devminor,
+		}, nil
+
+	case *zipstream.Reader:
+		h, err := v.Next()
+		if err != nil {
+			return nil, err
+		}
+		if h.Flags&0x1 != 0 {
+			return nil, fmt.Errorf("zip entry %q is encrypted, and there's no password", h.Name)
+		}
+		e := zipEntry(h, f.zipCharset)
+		// sizes follow the data, so they're unknown until it's read
+		e.unsized = h.Flags&0x8 != 0
+		return e, nil
+
+	case *zipArchive:
+		h, err := v.Next()
+		if err != nil {
+			return nil, err
+		}
+		return zipEntry(h, f.zipCharset), nil
+
+	default:
+		panic(fmt.Sprintf("unarchive: unknown type %T", v))
+	}
+}
+
+func zipEntry(h *zip.FileHeader, charset encoding.Encoding) *archiveEntry {
+	// zips made on Windows may use backslashes
+	return &archiveEntry{
+		FileInfo: h.FileInfo(),
+		name:     strings.Replace(zipName(h, charset), `\`, "/", -1),
+	}
+}
+
+// unarchiveLink returns the target of a symlink entry:
+// tar stores it in the header, zip as the file's contents.
+func unarchiveLink(e *archiveEntry, r io.Reader) (string, error) {
+	if e.link != "" {
+		return e.link, nil
+	}
+	old, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	e.link = string(old)
+	return e.link, nil
+}
+
+// tarArchive is a tar reader that tolerates
+// trailing garbage after the end-of-archive marker.
+type tarArchive struct {
+	*tar.Reader
+	tail *tarTail
+}
+
+func newTarArchive(r io.Reader) *tarArchive {
+	t := &tarTail{r: r, zero: true}
+	return &tarArchive{tar.NewReader(t), t}
+}
+
+// tarTail tracks which of the last few blocks of a tar stream were all zeros.
+type tarTail struct {
+	r      io.Reader
+	off    int64
+	zero   bool // current block is all zeros, so far
+	z1, z2 bool // last two complete blocks were all zeros
+}
+
+func (t *tarTail) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	for b := p[:n]; len(b) > 0; {
+		k := 512 - int(t.off%512)
+		if k > len(b) {
+			k = len(b)
+		}
+		if t.zero {
+			t.zero = bytes.Count(b[:k], []byte{0}) == k
+		}
+		t.off += int64(k)
+		b = b[k:]
+		if t.off%512 == 0 {
+			t.z2, t.z1, t.zero = t.z1, t.zero, true
+		}
+	}
+	return n, err
+}
+
+// check treats errors reading past a zero block as the end of the archive.
+// The marker is two zero blocks, but some archivers write only one,
+// or follow it with padding, signatures, or other garbage.
+func (t *tarTail) check(err error) error {
+	switch {
+	case err == tar.ErrHeader && t.z2: // zero block, and then garbage
+		return io.EOF
+	case err == io.ErrUnexpectedEOF && t.z1: // zero block, and then a partial block
+		return io.EOF
+	}
+	return err
+}
@@ -0,0 +1,610 @@
+package fetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testUnpacker makes an unpacker that extracts to dir, configured by opts.
+func testUnpacker(t *testing.T, dir string, opts ...Option) *unpacker {
+	u, err := New(opts...).newUnpacker(&Target{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestTarArchive_trailing(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 4})
+	tw.Write([]byte("data"))
+	tw.Flush()
+	entries := buf.Len()
+	tw.Close()
+
+	zero := make([]byte, 512)
+	garbage := bytes.Repeat([]byte("garbage!"), 128)
+	tests := map[string][]byte{
+		"standard":             buf.Bytes(),
+		"single zero block":    append(buf.Bytes()[:entries:entries], zero...),
+		"garbage after marker": append(buf.Bytes()[:buf.Len():buf.Len()], garbage...),
+		"partial after zero":   append(append(buf.Bytes()[:entries:entries], zero...), garbage[:100]...),
+		"one zero then junk":   append(append(buf.Bytes()[:entries:entries], zero...), garbage...),
+	}
+	for name, data := range tests {
+		a := newTarArchive(bytes.NewReader(data))
+		var n int
+		for {
+			_, err := New().unarchiveNext(a)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+			n++
+		}
+		if n != 1 {
+			t.Errorf("%s: got %d entries, want 1", name, n)
+		}
+	}
+
+	a := newTarArchive(bytes.NewReader(garbage))
+	if _, err := New().unarchiveNext(a); err == nil || err == io.EOF {
+		t.Errorf("garbage archive: got %v, want error", err)
+	}
+}
+
+func TestGzipMembers_trailer(t *testing.T) {
+	var buf bytes.Buffer
+	for _, s := range []string{"hello, ", "world"} {
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+	}
+	buf.WriteString("-----BEGIN PKCS7-----")
+
+	file := filepath.Join(t.TempDir(), "trailer")
+	u := testUnpacker(t, "", WithTrailer(file))
+
+	r := bufio.NewReader(&buf)
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(&gzipMembers{zr: zr, r: r, trailer: u.trailer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello, world" {
+		t.Errorf("got %q", got)
+	}
+
+	trailer, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(trailer) != "-----BEGIN PKCS7-----" {
+		t.Errorf("got trailer %q", trailer)
+	}
+}
+
+func TestBzip2Trailer(t *testing.T) {
+	data, _ := hex.DecodeString("425a6839314159265359c1c080e2000001410000100244a00030cd00c3462997177245385090c1c080e2")
+	data = append(data, "signature"...)
+
+	got, err := ioutil.ReadAll(&bzip2Trailer{r: bzip2.NewReader(bytes.NewReader(data)), logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestUnarchive_limits(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a", "b", "c"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 2 << 20})
+		tw.Write(make([]byte, 2<<20))
+	}
+	tw.Close()
+
+	received := func() int64 { return 1 << 20 }
+	tests := []struct {
+		size    int64
+		files   int
+		ratio   float64
+		wantErr bool
+	}{
+		{},
+		{files: 3},
+		{files: 2, wantErr: true},
+		{size: 6 << 20},
+		{size: 5 << 20, wantErr: true},
+		{ratio: 6},
+		{ratio: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		u := testUnpacker(t, dir, WithLimits(Limits{MaxSize: tt.size, MaxFiles: tt.files, MaxRatio: tt.ratio}))
+		u.received = received
+		err := u.unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("size %d, files %d, ratio %g: error = %v, wantErr %v",
+				tt.size, tt.files, tt.ratio, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUnarchive_nested(t *testing.T) {
+	var inner bytes.Buffer
+	zw := gzip.NewWriter(&inner)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.Close()
+	zw.Close()
+
+	var outer bytes.Buffer
+	tw = tar.NewWriter(&outer)
+	tw.WriteHeader(&tar.Header{Name: "inner.tar.gz", Mode: 0644, Size: int64(inner.Len())})
+	tw.Write(inner.Bytes())
+	tw.Close()
+
+	for _, depth := range []int{0, 1} {
+		dir := t.TempDir()
+		u := testUnpacker(t, dir, WithNested(depth))
+		if err := u.unarchive(newTarArchive(bytes.NewReader(outer.Bytes())), dir); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[int]string{0: "inner.tar.gz", 1: filepath.Join("inner", "file")}[depth]
+		if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+			t.Errorf("nested depth %d: %v", depth, err)
+		}
+	}
+
+	tests := map[string]string{
+		"a.tar.gz":  ".tar.gz",
+		"A.TGZ":     ".TGZ",
+		"a.zip":     ".zip",
+		"a.tar.bz2": ".tar.bz2",
+		".zip":      "",
+		"a.gz":      "",
+		"a.txt":     "",
+	}
+	for name, want := range tests {
+		if got := nestedArchiveExt(name); got != want {
+			t.Errorf("nestedArchiveExt(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnpacker_relName(t *testing.T) {
+	tests := []struct {
+		subdir string
+		strip  int
+		name   string
+		want   string
+		ok     bool
+	}{
+		{name: "./tool-1.0/bin/tool", want: "./tool-1.0/bin/tool", ok: true},
+		{strip: 1, name: "./tool-1.0/bin/tool", want: "bin/tool", ok: true},
+		{strip: 2, name: "tool-1.0/bin/tool", want: "tool", ok: true},
+		{strip: 1, name: "tool-1.0", ok: false},
+		{subdir: "tool-1.0", name: "tool-1.0/bin/tool", want: "bin/tool", ok: true},
+		{subdir: "tool-1.0", strip: 1, name: "tool-1.0/bin/tool", want: "tool", ok: true},
+		{subdir: "tool-1.0", name: "other/bin/tool", ok: false},
+	}
+	for _, tt := range tests {
+		u := testUnpacker(t, "", WithStripComponents(tt.strip))
+		u.subdir = tt.subdir
+		if got, ok := u.relName(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("relName(%q) with subdir %q, strip %d = %q, %v; want %q, %v",
+				tt.name, tt.subdir, tt.strip, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestUnpacker_stripTop(t *testing.T) {
+	tarball := func(names ...string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			if strings.HasSuffix(name, "/") {
+				tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755})
+				continue
+			}
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 2})
+			tw.Write([]byte("ok"))
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		archive []byte
+		want    []string
+	}{
+		{tarball("repo-1.2.3/", "repo-1.2.3/README", "repo-1.2.3/src/", "repo-1.2.3/src/main.go"), []string{"README", "src"}},
+		{tarball("repo/", "repo/README", "LICENSE"), []string{"LICENSE", "repo"}},
+		{tarball("README"), []string{"README"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		u := testUnpacker(t, dir, WithStripTop(true))
+		if err := u.extract(newTarArchive(bytes.NewReader(tt.archive))); err != nil {
+			t.Fatal(err)
+		}
+		files, _ := ioutil.ReadDir(dir)
+		var got []string
+		for _, fi := range files {
+			got = append(got, fi.Name())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("stripping the top directory extracted %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestUnarchive_overwrite(t *testing.T) {
+	entryTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 3, ModTime: entryTime})
+	tw.Write([]byte("new"))
+	tw.Close()
+
+	tests := []struct {
+		policy  Overwrite
+		mtime   time.Time // of the existing file
+		want    string
+		wantErr bool
+	}{
+		{policy: OverwriteAlways, mtime: entryTime.Add(time.Hour), want: "new"},
+		{policy: OverwriteNever, mtime: entryTime.Add(-time.Hour), want: "old"},
+		{policy: OverwriteNewer, mtime: entryTime.Add(-time.Hour), want: "new"},
+		{policy: OverwriteNewer, mtime: entryTime.Add(time.Hour), want: "old"},
+		{policy: OverwriteError, mtime: entryTime.Add(-time.Hour), want: "old", wantErr: true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file")
+		ioutil.WriteFile(path, []byte("old"), 0644)
+		os.Chtimes(path, tt.mtime, tt.mtime)
+
+		err := testUnpacker(t, dir, WithOverwrite(tt.policy)).unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("overwrite %s: error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+		if got, _ := ioutil.ReadFile(path); string(got) != tt.want {
+			t.Errorf("overwrite %s: got %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestCleanDir(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "target", "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "target", "stale"), nil, 0666)
+	ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0666)
+
+	if err := cleanDir(filepath.Join(dir, "target")); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "target")); len(files) != 0 {
+		t.Errorf("cleanDir left %d files", len(files))
+	}
+	if err := cleanDir(filepath.Join(dir, "missing")); err != nil {
+		t.Error(err)
+	}
+	if err := cleanDir(filepath.Join(dir, "file")); err == nil {
+		t.Error("cleanDir(file): want error")
+	}
+	if err := cleanDir(string(filepath.Separator)); err == nil {
+		t.Error("cleanDir(root): want error")
+	}
+
+	// a directory containing the working directory is refused
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	os.Mkdir(filepath.Join(dir, "target", "sub"), 0777)
+	if err := os.Chdir(filepath.Join(dir, "target", "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanDir(filepath.Join(dir, "target")); err == nil {
+		t.Error("cleanDir(parent of working directory): want error")
+	}
+}
+
+func TestUnarchive_unchanged(t *testing.T) {
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "same", Mode: 0644, Size: 4, ModTime: mtime})
+	tw.Write([]byte("same"))
+	tw.WriteHeader(&tar.Header{Name: "changed", Mode: 0644, Size: 3, ModTime: mtime})
+	tw.Write([]byte("new"))
+	tw.Close()
+
+	dir := t.TempDir()
+	if err := testUnpacker(t, dir).unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir); err != nil {
+		t.Fatal(err)
+	}
+	// links keep the old files, to tell if they were replaced
+	ioutil.WriteFile(filepath.Join(dir, "changed"), []byte("old"), 0644)
+	for _, name := range []string{"same", "changed"} {
+		if err := os.Link(filepath.Join(dir, name), filepath.Join(dir, name+".old")); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	if err := testUnpacker(t, dir).unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, wantSame := range map[string]bool{"same": true, "changed": false} {
+		old, _ := os.Stat(filepath.Join(dir, name+".old"))
+		cur, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if os.SameFile(old, cur) != wantSame {
+			t.Errorf("%s rewritten = %v, want %v", name, wantSame, !wantSame)
+		}
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(dir, "changed")); string(got) != "new" {
+		t.Errorf("changed file = %q", got)
+	}
+}
+
+func TestPaxXattrs(t *testing.T) {
+	got := paxXattrs(map[string]string{
+		"SCHILY.xattr.user.mime_type":      "text/plain",
+		"SCHILY.xattr.security.capability": "\x01",
+		"LIBARCHIVE.xattr.user.ignored":    "x",
+		"SCHILY.fflags":                    "",
+		"GNU.sparse.name":                  "file",
+	})
+	want := map[string]string{"user.mime_type": "text/plain", "security.capability": "\x01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paxXattrs() = %q, want %q", got, want)
+	}
+	if got := paxXattrs(nil); got != nil {
+		t.Errorf("paxXattrs(nil) = %q", got)
+	}
+}
+
+func TestUnarchive_hardlink(t *testing.T) {
+	tarball := func(link string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 2})
+		tw.Write([]byte("ok"))
+		tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: link})
+		tw.Close()
+		return buf.Bytes()
+	}
+
+	dir := t.TempDir()
+	if err := testUnpacker(t, dir).unarchive(newTarArchive(bytes.NewReader(tarball("file"))), dir); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFile(filepath.Join(dir, "file"), filepath.Join(dir, "link")) {
+		t.Error("hardlink entry isn't linked to its target")
+	}
+
+	for _, link := range []string{"../file", "/etc/passwd"} {
+		dir := t.TempDir()
+		err := testUnpacker(t, dir).unarchive(newTarArchive(bytes.NewReader(tarball(link))), dir)
+		if err == nil {
+			t.Errorf("hardlink to %q: want error", link)
+		}
+	}
+}
+
+func TestCopySparse(t *testing.T) {
+	data := append(append(bytes.Repeat([]byte("x"), 5000), make([]byte, 20000)...), 'y')
+	for _, size := range []int{len(data), len(data) - 1, 4096, 0} {
+		f, err := os.Create(filepath.Join(t.TempDir(), "sparse"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := copySparse(f, bytes.NewReader(data[:size]))
+		f.Close()
+		if err != nil || n != int64(size) {
+			t.Fatalf("copySparse() = %d, %v; want %d", n, err, size)
+		}
+		if got, _ := ioutil.ReadFile(f.Name()); !bytes.Equal(got, data[:size]) {
+			t.Errorf("copySparse() of %d bytes wrote %d different bytes", size, len(got))
+		}
+	}
+
+	tests := []struct {
+		h    tar.Header
+		want bool
+	}{
+		{tar.Header{Typeflag: tar.TypeReg}, false},
+		{tar.Header{Typeflag: tar.TypeGNUSparse}, true},
+		{tar.Header{Typeflag: tar.TypeReg, PAXRecords: map[string]string{"GNU.sparse.major": "1"}}, true},
+		{tar.Header{Typeflag: tar.TypeReg, PAXRecords: map[string]string{"path": "file"}}, false},
+	}
+	for _, tt := range tests {
+		if got := isSparse(&tt.h); got != tt.want {
+			t.Errorf("isSparse(%v) = %v", tt.h, got)
+		}
+	}
+}
+
+func TestUnarchive_zipBackslashes(t *testing.T) {
+	// a stored entry, with its sizes in the local header
+	name, data := `dir\sub\file`, []byte("ok")
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Sig                  uint32
+		Version, Flags, Meth uint16
+		Time, Date           uint16
+		CRC, CSize, Size     uint32
+		NameLen, ExtraLen    uint16
+	}{
+		Sig: 0x04034b50, Version: 20, Meth: zip.Store,
+		CRC: crc32.ChecksumIEEE(data), CSize: uint32(len(data)), Size: uint32(len(data)),
+		NameLen: uint16(len(name)),
+	})
+	buf.WriteString(name)
+	buf.Write(data)
+
+	dir := t.TempDir()
+	if err := New(WithUnpack(UnpackAuto)).Save(context.Background(), &buf, &Target{Path: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "dir", "sub", "file")); err != nil || string(got) != "ok" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]string{
+		"tar":     "tar",
+		"tgz":     "tar.gz",
+		"tbz2":    "tar.bz2",
+		"gzip":    "gz",
+		"zip.gz":  "zip.gz",
+		"gz.bz2":  "gz.bz2",
+		"raw":     "raw",
+		"gz.tar":  "",
+		"tar.tar": "",
+		"rar":     "",
+		"":        "",
+	}
+	for format, want := range tests {
+		got, err := ParseFormat(format)
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("ParseFormat(%q) = %q, %v", format, got, err)
+		}
+	}
+
+	var layers []string
+	for format := "tar.gz.bz2"; format != "raw"; {
+		var layer string
+		layer, format = nextFormat(format)
+		layers = append(layers, layer)
+	}
+	if want := []string{"bz2", "gz", "tar"}; !reflect.DeepEqual(layers, want) {
+		t.Errorf("nextFormat() layers = %q", layers)
+	}
+}
+
+func TestUncompress_format(t *testing.T) {
+	// a tar, that's sniffed as a zip
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "PK\x03\x04", Mode: 0644, Size: 4})
+	tw.Write([]byte("data"))
+	tw.Close()
+
+	if got := SniffFormat(buf.Bytes()); got != "zip" {
+		t.Fatalf("SniffFormat() = %q", got)
+	}
+	dir := t.TempDir()
+	f := New(WithUnpack(UnpackAuto), WithFormat("tar"))
+	if err := f.Save(context.Background(), &buf, &Target{Path: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "PK\x03\x04")); err != nil || string(got) != "data" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestHintFormat(t *testing.T) {
+	tests := []struct {
+		name, contentType string
+		want              string
+	}{
+		{name: "file.tar", want: "tar"},
+		{name: "FILE.TGZ", want: "tar.gz"},
+		{name: "file.tar.bz2", contentType: "application/octet-stream", want: "tar.bz2"},
+		{name: "file.tar.gz", contentType: "application/x-tar", want: "tar.gz"},
+		{name: "file.bin", contentType: "application/x-tar", want: "tar"},
+		{name: "file.tar", contentType: "application/x-gtar", want: "tar.gz"},
+		{name: "file.zip", want: ""},
+	}
+	for _, tt := range tests {
+		if got := HintFormat(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("HintFormat(%q, %q) = %q, want %q", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestHintedFormat(t *testing.T) {
+	tests := []struct {
+		sniffed, hint string
+		want, rest    string
+	}{
+		{sniffed: "raw", hint: "tar", want: "tar", rest: "raw"},
+		{sniffed: "raw", hint: "tar.gz", want: "tar", rest: "raw"},
+		{sniffed: "gz", hint: "tar.gz", want: "gz", rest: "tar"},
+		{sniffed: "gz", hint: "tar", want: "gz", rest: "tar"},
+		{sniffed: "zip", hint: "tar", want: "zip", rest: ""},
+		{sniffed: "raw", hint: "", want: "raw", rest: ""},
+	}
+	for _, tt := range tests {
+		got, rest := hintedFormat(tt.sniffed, tt.hint)
+		if got != tt.want || rest != tt.rest {
+			t.Errorf("hintedFormat(%q, %q) = %q, %q", tt.sniffed, tt.hint, got, rest)
+		}
+	}
+}
+
+func TestUncompress_hint(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 4, Format: tar.FormatUSTAR})
+	tw.Write([]byte("data"))
+	tw.Close()
+
+	// make it an old tar, without magic
+	v7 := buf.Bytes()
+	copy(v7[257:265], make([]byte, 8))
+	copy(v7[148:156], "        ")
+	var sum int
+	for _, b := range v7[:512] {
+		sum += int(b)
+	}
+	copy(v7[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	if got := SniffFormat(v7); got != "raw" {
+		t.Fatalf("SniffFormat() = %q", got)
+	}
+	dir := t.TempDir()
+	target := &Target{Path: dir, Name: "file.tar"}
+	if err := New(WithUnpack(UnpackAuto)).Save(context.Background(), bytes.NewReader(v7), target); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "file")); err != nil || string(got) != "data" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
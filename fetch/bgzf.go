@@ -0,0 +1,173 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// bgzfMaxBlock is the most a BGZF block decompresses to.
+const bgzfMaxBlock = 1 << 16
+
+// gzipBlocks decompresses a blocked gzip (BGZF) stream,
+// where each member records its compressed size in its header,
+// so members can be split off, and decompressed in parallel.
+// Any members after the blocks are decompressed in sequence.
+type gzipBlocks struct {
+	r     *bufio.Reader
+	queue chan chan gzipBlock // in order
+	done  chan struct{}
+	once  sync.Once
+	err   error // set before queue is closed
+	rest  io.Reader
+	cur   []byte
+
+	trailer func(io.Reader) error // handles data after the last member
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+// newGzipBlocks starts decompressing r, up to n blocks at a time.
+func newGzipBlocks(r *bufio.Reader, n int, trailer func(io.Reader) error) *gzipBlocks {
+	g := &gzipBlocks{
+		r:       r,
+		queue:   make(chan chan gzipBlock, n),
+		done:    make(chan struct{}),
+		trailer: trailer,
+	}
+	go g.split()
+	return g
+}
+
+func (g *gzipBlocks) split() {
+	defer close(g.queue)
+	for {
+		size := bgzfSize(g.r)
+		if size == 0 {
+			return
+		}
+
+		block := make([]byte, size)
+		if _, err := io.ReadFull(g.r, block); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			g.err = err
+			return
+		}
+
+		res := make(chan gzipBlock, 1)
+		select {
+		case g.queue <- res:
+		case <-g.done:
+			g.err = io.ErrClosedPipe
+			return
+		}
+		go func() { res <- inflateBlock(block) }()
+	}
+}
+
+func (g *gzipBlocks) Read(p []byte) (int, error) {
+	for len(g.cur) == 0 {
+		if g.rest != nil {
+			return g.rest.Read(p)
+		}
+		if g.err != nil {
+			return 0, g.err
+		}
+
+		res, ok := <-g.queue
+		if !ok {
+			g.err = g.tail()
+			continue
+		}
+		b := <-res
+		if b.err != nil {
+			g.err = b.err
+			return 0, b.err
+		}
+		g.cur = b.data
+	}
+	n := copy(p, g.cur)
+	g.cur = g.cur[n:]
+	return n, nil
+}
+
+// tail handles what follows the blocks:
+// other gzip members, or trailing data.
+func (g *gzipBlocks) tail() error {
+	if g.err != nil {
+		return g.err
+	}
+	if magic, _ := g.r.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+		return g.trailer(g.r)
+	}
+	zr, err := gzip.NewReader(g.r)
+	if err != nil {
+		return err
+	}
+	g.rest = &gzipMembers{zr: zr, r: g.r, trailer: g.trailer}
+	return nil
+}
+
+// Close stops splitting blocks, after the current one.
+func (g *gzipBlocks) Close() error {
+	g.once.Do(func() { close(g.done) })
+	return nil
+}
+
+// bgzfSize returns the size of the BGZF block at the start of r,
+// or 0 if r doesn't start with one.
+func bgzfSize(r *bufio.Reader) int {
+	// a gzip header with only FEXTRA set
+	h, _ := r.Peek(12)
+	if len(h) < 12 || !bytes.HasPrefix(h, gzipMagic) || h[2] != 8 || h[3] != 4 {
+		return 0
+	}
+	xlen := int(binary.LittleEndian.Uint16(h[10:]))
+	h, _ = r.Peek(12 + xlen)
+	if len(h) < 12+xlen {
+		return 0
+	}
+
+	// find the BC subfield, with the block size minus 1
+	for x := h[12:]; len(x) >= 4; {
+		slen := int(binary.LittleEndian.Uint16(x[2:]))
+		if len(x) < 4+slen {
+			break
+		}
+		if x[0] == 'B' && x[1] == 'C' && slen == 2 {
+			size := int(binary.LittleEndian.Uint16(x[4:])) + 1
+			// room for the header, and the CRC and size
+			if size < 12+xlen+8 {
+				return 0
+			}
+			return size
+		}
+		x = x[4+slen:]
+	}
+	return 0
+}
+
+// inflateBlock decompresses, and checks, a BGZF block.
+func inflateBlock(block []byte) gzipBlock {
+	zr, err := gzip.NewReader(bytes.NewReader(block))
+	if err != nil {
+		return gzipBlock{err: err}
+	}
+	zr.Multistream(false)
+
+	data, err := ioutil.ReadAll(io.LimitReader(zr, bgzfMaxBlock+1))
+	if err == nil && len(data) > bgzfMaxBlock {
+		err = errors.New("gzip: BGZF block too large")
+	}
+	return gzipBlock{data: data, err: err}
+}
@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// bgzf compresses data in BGZF blocks, ending with an empty one.
+func bgzf(data []byte) []byte {
+	var out bytes.Buffer
+	for {
+		n := len(data)
+		if n > 60000 {
+			n = 60000
+		}
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		zw.Write(data[:n])
+		zw.Close()
+		block := buf.Bytes()
+		binary.LittleEndian.PutUint16(block[16:], uint16(len(block)-1))
+		out.Write(block)
+
+		if n == 0 {
+			return out.Bytes()
+		}
+		data = data[n:]
+	}
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestGzipBlocks(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(0)).Read(data[:len(data)/2])
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"blocks", bgzf(data), data, false},
+		{"members after", append(bgzf(data[:1000]), gzipped(data[1000:])...), data, false},
+		{"trailing data", append(bgzf(data), "signature"...), data, false},
+		{"truncated", bgzf(data)[:100000], nil, true},
+		{"corrupt", func() []byte {
+			b := bgzf(data)
+			b[1000] ^= 0xff
+			return b
+		}(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.stream))
+			if bgzfSize(r) == 0 {
+				t.Fatal("not a BGZF stream")
+			}
+			zb := newGzipBlocks(r, 4, testUnpacker(t, "").trailer)
+			defer zb.Close()
+
+			got, err := ioutil.ReadAll(zb)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, tt.want) {
+				t.Errorf("ReadAll() = %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestBgzfSize(t *testing.T) {
+	block := bgzf(nil)
+	tests := []struct {
+		name   string
+		stream []byte
+		want   int
+	}{
+		{"block", block, len(block)},
+		{"gzip", gzipped(nil), 0},
+		{"short", block[:12], 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(bytes.NewReader(tt.stream))
+		if got := bgzfSize(r); got != tt.want {
+			t.Errorf("bgzfSize(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
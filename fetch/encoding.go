@@ -0,0 +1,62 @@
+package fetch
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ncruces/go-fetch/internal/zstd"
+)
+
+// AcceptEncoding lists the content codings that Decode decodes.
+const AcceptEncoding = "gzip, br, zstd"
+
+// Decode wraps body to decode a Content-Encoding.
+// The encoded stream is read only on the first read,
+// so that Decode doesn't block reading its header.
+func Decode(body io.ReadCloser, coding string) (io.ReadCloser, error) {
+	var open func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(coding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		open = func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}
+	case "br":
+		open = func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		}
+	case "zstd":
+		open = func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", coding)
+	}
+	return &decoder{r: body, open: open}, nil
+}
+
+// decoder decodes a body on the first read.
+type decoder struct {
+	r    io.ReadCloser
+	open func(io.Reader) (io.Reader, error)
+	dec  io.Reader
+	err  error
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	if d.dec == nil && d.err == nil {
+		d.dec, d.err = d.open(d.r)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.dec.Read(p)
+}
+
+func (d *decoder) Close() error {
+	return d.r.Close()
+}
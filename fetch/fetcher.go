@@ -0,0 +1,413 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding"
+)
+
+// UnpackPolicy is what Fetch does with archives, and compressed files.
+type UnpackPolicy int
+
+const (
+	// UnpackNever saves downloads as they are.
+	UnpackNever UnpackPolicy = iota
+	// UnpackAuto extracts archives, and decompresses compressed files,
+	// recognized by their contents; anything else is saved as is.
+	UnpackAuto
+	// UnpackDecompress decompresses compressed files,
+	// but doesn't extract archives.
+	UnpackDecompress
+)
+
+// Overwrite is what unpacking does with files that are in the way.
+type Overwrite string
+
+const (
+	OverwriteAlways Overwrite = "always" // replaces them
+	OverwriteNever  Overwrite = "never"  // keeps them
+	OverwriteNewer  Overwrite = "newer"  // replaces those older than the entry
+	OverwriteError  Overwrite = "error"  // fails
+)
+
+// Symlinks is what unpacking does with symlinks;
+// links that lead out of the target directory always fail.
+type Symlinks string
+
+const (
+	SymlinksKeep    Symlinks = "keep"    // creates them
+	SymlinksSkip    Symlinks = "skip"    // ignores them
+	SymlinksDeref   Symlinks = "deref"   // copies their targets
+	SymlinksRewrite Symlinks = "rewrite" // makes absolute targets relative to the target directory
+)
+
+// PortableNames is what unpacking does with names reserved on Windows,
+// or that differ only in case.
+type PortableNames string
+
+const (
+	NamesKeep   PortableNames = "keep"   // extracts them as is
+	NamesRename PortableNames = "rename" // numbers them, like a~1.txt
+	NamesSkip   PortableNames = "skip"   // ignores them
+	NamesError  PortableNames = "error"  // fails
+)
+
+// Permissions configure the special permission bits of extracted files.
+// The zero value strips setuid, setgid and sticky bits.
+type Permissions struct {
+	PreserveSuid       bool // keeps setuid, setgid and sticky bits
+	StripSetuid        bool // strips setuid and setgid, even if preserved
+	StripWorldWritable bool
+	DenySetuid         bool // refuses archives with setuid or setgid files
+	DenyWorldWritable  bool // refuses archives with world-writable files
+}
+
+// Fetcher downloads, verifies and unpacks files,
+// like the go-fetch command does.
+type Fetcher struct {
+	opts   Options
+	unpack UnpackPolicy
+	filter func(name string) bool
+	digest string // algorithm:hex
+	limits Limits
+
+	format    string // forced, like tar.gz
+	entry     string // the only file extracted
+	strip     int    // leading path elements of entries
+	stripTop  bool
+	clean     bool
+	depth     int // of nested archives to unpack
+	overwrite Overwrite
+	symlinks  Symlinks
+	names     PortableNames
+	perms     Permissions
+
+	uid, gid     int // of extracted files, or -1
+	sameOwner    bool
+	numericOwner bool
+	xattrs       bool
+	special      bool
+
+	zipPassword string
+	zipCharset  encoding.Encoding
+
+	workers        int // writing extracted files
+	decompressJobs int
+	fsync          bool
+	trailer        string // file to save data after compressed streams to
+	logf           func(format string, v ...interface{})
+}
+
+// Option configures a Fetcher.
+type Option func(*Fetcher)
+
+// New creates a Fetcher, which retries DefaultRetries times,
+// and doesn't unpack, unless configured otherwise.
+func New(opts ...Option) *Fetcher {
+	f := &Fetcher{
+		opts:      Options{Retries: DefaultRetries},
+		overwrite: OverwriteAlways,
+		symlinks:  SymlinksKeep,
+		names:     NamesKeep,
+		uid:       -1,
+		gid:       -1,
+		workers:   4,
+	}
+	return f.With(opts...)
+}
+
+// With returns a copy of f, further configured with opts.
+func (f *Fetcher) With(opts ...Option) *Fetcher {
+	c := *f
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// WithClient makes requests with client, rather than http.DefaultClient.
+func WithClient(client *http.Client) Option {
+	return func(f *Fetcher) { f.opts.Client = client }
+}
+
+// WithHeader adds header to every request.
+func WithHeader(header http.Header) Option {
+	return func(f *Fetcher) { f.opts.Header = header }
+}
+
+// WithCredentials authenticates with basic auth,
+// unless the url has its own user info.
+func WithCredentials(username, password string) Option {
+	return func(f *Fetcher) { f.opts.Username, f.opts.Password = username, password }
+}
+
+// WithToken authenticates as a bearer of token.
+func WithToken(token string) Option {
+	return func(f *Fetcher) { f.opts.Token = token }
+}
+
+// WithRetries sets how many times requests are retried (see Options).
+func WithRetries(n int) Option {
+	return func(f *Fetcher) { f.opts.Retries = n }
+}
+
+// WithUnpack sets what Fetch does with archives, and compressed files.
+func WithUnpack(policy UnpackPolicy) Option {
+	return func(f *Fetcher) { f.unpack = policy }
+}
+
+// WithFilter extracts only the archive entries for which keep
+// returns true, given their slash separated, cleaned, path.
+func WithFilter(keep func(name string) bool) Option {
+	return func(f *Fetcher) { f.filter = keep }
+}
+
+// WithDigest verifies downloads against digest,
+// in algorithm:hex form (sha256:… or sha512:…).
+func WithDigest(digest string) Option {
+	return func(f *Fetcher) { f.digest = digest }
+}
+
+// WithLimits fails to unpack archives that exceed limits.
+func WithLimits(limits Limits) Option {
+	return func(f *Fetcher) { f.limits = limits }
+}
+
+// WithName suggests file names for downloads with name,
+// rather than DefaultName; names with separators are rejected.
+func WithName(name func(source string, res *http.Response) string) Option {
+	return func(f *Fetcher) { f.opts.Name = name }
+}
+
+// WithFormat unpacks downloads as format (see ParseFormat),
+// rather than detecting it.
+func WithFormat(format string) Option {
+	return func(f *Fetcher) { f.format = format }
+}
+
+// WithEntry extracts only the file at path in archives,
+// saving it like a download that isn't unpacked.
+func WithEntry(path string) Option {
+	return func(f *Fetcher) { f.entry = path }
+}
+
+// WithStripComponents strips n leading path elements from archive entries.
+func WithStripComponents(n int) Option {
+	return func(f *Fetcher) { f.strip = n }
+}
+
+// WithStripTop strips the top directory of archives,
+// if all entries are in one.
+func WithStripTop(strip bool) Option {
+	return func(f *Fetcher) { f.stripTop = strip }
+}
+
+// WithClean removes the contents of the target directory before
+// extracting to it; roots, home directories, and any containing
+// the working directory, are refused.
+func WithClean(clean bool) Option {
+	return func(f *Fetcher) { f.clean = clean }
+}
+
+// WithNested also unpacks archives nested up to depth levels deep,
+// replacing each with a directory named after it.
+func WithNested(depth int) Option {
+	return func(f *Fetcher) { f.depth = depth }
+}
+
+// WithOverwrite sets what unpacking does with files that are in the way.
+func WithOverwrite(policy Overwrite) Option {
+	return func(f *Fetcher) { f.overwrite = policy }
+}
+
+// WithSymlinks sets what unpacking does with symlinks.
+func WithSymlinks(policy Symlinks) Option {
+	return func(f *Fetcher) { f.symlinks = policy }
+}
+
+// WithPortableNames sets what unpacking does with names
+// that can't be extracted everywhere.
+func WithPortableNames(policy PortableNames) Option {
+	return func(f *Fetcher) { f.names = policy }
+}
+
+// WithPermissions configures the special permission bits of extracted files.
+func WithPermissions(perms Permissions) Option {
+	return func(f *Fetcher) { f.perms = perms }
+}
+
+// WithOwner extracts files owned by uid and gid; -1 leaves either as is.
+func WithOwner(uid, gid int) Option {
+	return func(f *Fetcher) { f.uid, f.gid = uid, gid }
+}
+
+// WithSameOwner extracts files with the owner recorded in archives,
+// when running as root; by their ids if numeric, otherwise by their
+// user and group names, if known here.
+func WithSameOwner(numeric bool) Option {
+	return func(f *Fetcher) { f.sameOwner, f.numericOwner = true, numeric }
+}
+
+// WithXattrs extracts the extended attributes recorded in tar archives.
+func WithXattrs(xattrs bool) Option {
+	return func(f *Fetcher) { f.xattrs = xattrs }
+}
+
+// WithSpecialFiles extracts FIFOs, and devices when running as root;
+// otherwise, archives with them fail to unpack.
+func WithSpecialFiles(special bool) Option {
+	return func(f *Fetcher) { f.special = special }
+}
+
+// WithZipPassword decrypts encrypted zip entries with password.
+func WithZipPassword(password string) Option {
+	return func(f *Fetcher) { f.zipPassword = password }
+}
+
+// WithZipEncoding decodes the zip entry names not flagged as UTF-8
+// with enc, rather than CP437 (unless they're valid UTF-8).
+func WithZipEncoding(enc encoding.Encoding) Option {
+	return func(f *Fetcher) { f.zipCharset = enc }
+}
+
+// WithWorkers writes extracted files on n goroutines;
+// 1 writes them in order.
+func WithWorkers(n int) Option {
+	return func(f *Fetcher) { f.workers = n }
+}
+
+// WithDecompressJobs decompresses gzip with n goroutines: blocked gzip
+// (BGZF) members in parallel, other streams overlapped with reading
+// and unpacking them; 0 disables it.
+func WithDecompressJobs(n int) Option {
+	return func(f *Fetcher) { f.decompressJobs = n }
+}
+
+// WithFsync flushes saved files, and their directories, to disk.
+func WithFsync(fsync bool) Option {
+	return func(f *Fetcher) { f.fsync = fsync }
+}
+
+// WithTrailer saves data appended to compressed streams,
+// like a signature, to file, rather than ignoring it.
+func WithTrailer(file string) Option {
+	return func(f *Fetcher) { f.trailer = file }
+}
+
+// WithLogger reports what unpacking skips, strips or renames with logf.
+func WithLogger(logf func(format string, v ...interface{})) Option {
+	return func(f *Fetcher) { f.logf = logf }
+}
+
+func (f *Fetcher) log(format string, v ...interface{}) {
+	if f.logf != nil {
+		f.logf(format, v...)
+	}
+}
+
+// Name suggests a file name for the response to a request for source.
+func (f *Fetcher) Name(source string, res *http.Response) string {
+	if f.opts.Name == nil {
+		return DefaultName(source, res)
+	}
+	return f.opts.Name(source, res)
+}
+
+// Open opens source, as configured; see the Open function.
+func (f *Fetcher) Open(ctx context.Context, source string) (io.ReadCloser, Metadata, error) {
+	return Open(ctx, source, &f.opts)
+}
+
+// Fetch downloads source to dst.
+//
+// If dst is a directory, or ends in a separator, the download is saved
+// in it, with the name suggested by the server; archives are extracted to it.
+// Files are saved only once verified; extracted archives are verified
+// as they are extracted, so a mismatch leaves them behind.
+func (f *Fetcher) Fetch(ctx context.Context, source, dst string) error {
+	if f.digest != "" {
+		// fail before downloading anything
+		if _, err := NewVerifier(nil, f.digest); err != nil {
+			return err
+		}
+	}
+	body, meta, err := f.Open(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if f.digest != "" {
+		r, _ = NewVerifier(body, f.digest)
+	}
+
+	dir := strings.HasSuffix(dst, string(filepath.Separator)) || strings.HasSuffix(dst, "/")
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dir = true
+	}
+	return f.Save(ctx, r, &Target{
+		Path:        dst,
+		IsDir:       dir,
+		Name:        meta.Name,
+		ContentType: meta.ContentType,
+		Size:        meta.Size,
+	})
+}
+
+// Unpack extracts the archive read from r to the directory dst,
+// or, if r isn't an archive, decompresses it (if needed) to the file dst.
+// With UnpackDecompress, archives are only decompressed.
+func (f *Fetcher) Unpack(ctx context.Context, r io.Reader, dst string) error {
+	if f.unpack == UnpackNever {
+		return f.With(WithUnpack(UnpackAuto)).Unpack(ctx, r, dst)
+	}
+	return f.Save(ctx, r, &Target{Path: dst})
+}
+
+// Fetch downloads source to dst, with a default Fetcher
+// that doesn't unpack; see Fetcher.Fetch.
+func Fetch(ctx context.Context, source, dst string) error {
+	return New().Fetch(ctx, source, dst)
+}
+
+// Unpack extracts the archive read from r to dst,
+// with a default Fetcher; see Fetcher.Unpack.
+func Unpack(ctx context.Context, r io.Reader, dst string) error {
+	return New(WithUnpack(UnpackAuto)).Unpack(ctx, r, dst)
+}
+
+// ctxReader fails reads once its context is done,
+// and counts the bytes read.
+type ctxReader struct {
+	ctx  context.Context
+	r    io.Reader
+	read int64
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// ctxReaderAt fails reads once its context is done.
+type ctxReaderAt struct {
+	ctx context.Context
+	r   io.ReaderAt
+}
+
+func (c ctxReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.ReadAt(p, off)
+}
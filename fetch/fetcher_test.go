@@ -0,0 +1,94 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnpack_limits(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		data := bytes.Repeat([]byte(name), 100)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr bool
+	}{
+		{"none", Limits{}, false},
+		{"files", Limits{MaxFiles: 3}, false},
+		{"too many files", Limits{MaxFiles: 2}, true},
+		{"size", Limits{MaxSize: 1500}, false},
+		{"too large", Limits{MaxSize: 1000}, true},
+		{"ratio", Limits{MaxRatio: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			f := New(WithUnpack(UnpackAuto), WithLimits(tt.limits))
+			err := f.Unpack(context.Background(), bytes.NewReader(buf.Bytes()), dir)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ioutil.ReadFile(filepath.Join(dir, "c.txt")); err != nil || len(got) != 500 {
+				t.Errorf("got %d bytes, %v", len(got), err)
+			}
+		})
+	}
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dir/file", Mode: 0644, Size: 2})
+	tw.Write([]byte("ok"))
+	tw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := Fetch(ctx, srv.URL+"/file.tar", dir); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "file.tar")); err != nil || !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("saved %d bytes, %v", len(got), err)
+	}
+
+	f := New(WithUnpack(UnpackAuto), WithStripComponents(1))
+	if err := f.Fetch(ctx, srv.URL+"/file.tar", dir); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "file")); err != nil || string(got) != "ok" {
+		t.Errorf("extracted %q, %v", got, err)
+	}
+
+	f = New(WithDigest("sha256:" + string(bytes.Repeat([]byte("0"), 64))))
+	if err := f.Fetch(ctx, srv.URL+"/file.tar", filepath.Join(dir, "bad.tar")); err == nil {
+		t.Error("digest mismatch: want error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.tar")); !os.IsNotExist(err) {
+		t.Errorf("digest mismatch: saved the file, %v", err)
+	}
+}
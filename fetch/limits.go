@@ -0,0 +1,71 @@
+package fetch
+
+import (
+	"fmt"
+	"io"
+)
+
+// Limits guard against archive bombs, failing to unpack archives
+// that exceed them. Zero values don't limit.
+type Limits struct {
+	MaxSize  int64   // bytes extracted
+	MaxFiles int     // entries extracted
+	MaxRatio float64 // bytes extracted per byte of the download
+}
+
+// Budget counts what's extracted from an archive,
+// and those nested in it, against Limits.
+type Budget struct {
+	Limits
+	// Received counts the bytes of the download received so far;
+	// if nil, MaxRatio isn't enforced.
+	Received func() int64
+
+	files int
+	size  int64
+}
+
+// AddFile counts an extracted entry.
+func (b *Budget) AddFile() error {
+	b.files++
+	if b.MaxFiles > 0 && b.files > b.MaxFiles {
+		return fmt.Errorf("archive has more than %d entries", b.MaxFiles)
+	}
+	return nil
+}
+
+// Add counts n extracted bytes.
+func (b *Budget) Add(n int64) error {
+	b.size += n
+	if b.MaxSize > 0 && b.size > b.MaxSize {
+		return fmt.Errorf("archive is larger than %d bytes, once extracted", b.MaxSize)
+	}
+	// small archives can have large ratios
+	const minSize = 1 << 20
+	if b.MaxRatio > 0 && b.Received != nil && b.size > minSize &&
+		float64(b.size) > b.MaxRatio*float64(b.Received()) {
+		return fmt.Errorf("archive expands more than %g times its download", b.MaxRatio)
+	}
+	return nil
+}
+
+// Reader counts what's read from r against the budget.
+func (b *Budget) Reader(r io.Reader) io.Reader {
+	if b.MaxSize <= 0 && b.MaxRatio <= 0 {
+		return r
+	}
+	return &budgetReader{r, b}
+}
+
+type budgetReader struct {
+	r io.Reader
+	b *Budget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if berr := r.b.Add(int64(n)); berr != nil {
+		return n, berr
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package fetch
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Entry describes a file in an archive, as given to Target.List.
+type Entry struct {
+	Name    string
+	Type    string // file, dir, symlink, hardlink, fifo, char, block, or other
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	UID     int
+	GID     int
+	Uname   string
+	Gname   string
+	Link    string // the target of links
+	HasMode bool   // zips needn't record permissions
+}
+
+// listArchive lists the entries of an archive,
+// checking each against the extraction policy.
+// All entries are listed, even if some would be refused.
+func (u *unpacker) listArchive(r io.Reader) error {
+	var perr error
+	for {
+		e, err := u.next(r)
+		if err == io.EOF {
+			return perr
+		}
+		if err != nil {
+			return err
+		}
+		if e.Mode()&os.ModeSymlink != 0 {
+			if _, err := unarchiveLink(e, r); err != nil {
+				return err
+			}
+		}
+		if err := u.f.checkPolicy(e); err != nil && perr == nil {
+			perr = err
+		}
+		if err := u.list(Entry{
+			Name:    e.name,
+			Type:    entryType(e),
+			Size:    e.Size(),
+			Mode:    e.Mode(),
+			ModTime: e.ModTime(),
+			UID:     e.uid,
+			GID:     e.gid,
+			Uname:   e.uname,
+			Gname:   e.gname,
+			Link:    e.link,
+			HasMode: e.hasMode,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// listFile lists a single compressed file.
+func (u *unpacker) listFile(r io.Reader) error {
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return err
+	}
+	return u.list(Entry{Name: u.targetName, Type: "file", Size: n, Mode: 0666})
+}
+
+func entryType(e *archiveEntry) string {
+	switch mode := e.Mode(); {
+	case e.hardlink:
+		return "hardlink"
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeCharDevice != 0:
+		return "char"
+	case mode&os.ModeDevice != 0:
+		return "block"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package fetch
+
+// longPath makes an absolute path an extended-length path,
+// which is only needed on Windows.
+func longPath(path string) string {
+	return path
+}
+
+// shortPath undoes longPath.
+func shortPath(path string) string {
+	return path
+}
@@ -0,0 +1,28 @@
+package fetch
+
+import "strings"
+
+// longPath makes an absolute path an extended-length path,
+// so that archives with paths over MAX_PATH can be extracted.
+func longPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, `\\?\`), strings.HasPrefix(path, `\\.\`):
+		return path
+	case strings.HasPrefix(path, `\\`):
+		return `\\?\UNC\` + path[2:]
+	case len(path) >= 2 && path[1] == ':':
+		return `\\?\` + path
+	}
+	return path
+}
+
+// shortPath undoes longPath, for paths reported to callers.
+func shortPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, `\\?\UNC\`):
+		return `\\` + path[8:]
+	case strings.HasPrefix(path, `\\?\`):
+		return path[4:]
+	}
+	return path
+}
@@ -0,0 +1,18 @@
+package fetch
+
+import "testing"
+
+func TestLongPath(t *testing.T) {
+	tests := map[string]string{
+		`C:\dir\file`:         `\\?\C:\dir\file`,
+		`\\server\share\file`: `\\?\UNC\server\share\file`,
+		`\\?\C:\dir`:          `\\?\C:\dir`,
+		`\\.\pipe\name`:       `\\.\pipe\name`,
+		`dir\file`:            `dir\file`,
+	}
+	for path, want := range tests {
+		if got := longPath(path); got != want {
+			t.Errorf("longPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
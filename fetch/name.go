@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// DefaultName suggests a file name for a download, as the go-fetch command does:
+// from the Content-Disposition header,
+// or the base name of the final or source URL.
+func DefaultName(source string, res *http.Response) string {
+	// use content disposition, as is
+	if name := dispositionName(res.Header.Get("Content-Disposition")); name != "" {
+		return name
+	}
+
+	// use the base name of the final URL, if it has an extension
+	name := path.Base(res.Request.URL.Path)
+
+	// use the base name of the source url, since it's more predictable
+	if len(path.Ext(name)) <= 1 {
+		u, _ := url.Parse(source)
+		name = path.Base(u.Path)
+	}
+
+	// like wget, name directory urls
+	if name == "/" || name == "." {
+		name = "index.html"
+	}
+
+	return name
+}
+
+// dispositionName gets the file name from a Content-Disposition header,
+// preferring an RFC 5987 filename* to a plain filename.
+// It returns "" if there's no usable name.
+func dispositionName(disp string) string {
+	if name := extendedFilename(disp); name != "" {
+		return name
+	}
+	// this decodes filename* too, but fails on any malformed parameter
+	if _, params, err := mime.ParseMediaType(disp); err == nil {
+		return SanitizeName(params["filename"])
+	}
+	return ""
+}
+
+// extendedFilename decodes a filename*=charset'lang'value parameter,
+// in UTF-8 or ISO-8859-1, which mime doesn't decode.
+func extendedFilename(disp string) string {
+	for _, param := range strings.Split(disp, ";") {
+		i := strings.IndexByte(param, '=')
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(param[:i]), "filename*") {
+			continue
+		}
+		parts := strings.SplitN(strings.Trim(strings.TrimSpace(param[i+1:]), `"`), "'", 3)
+		if len(parts) != 3 {
+			return ""
+		}
+		value, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return ""
+		}
+		switch strings.ToLower(parts[0]) {
+		case "utf-8", "us-ascii":
+		case "iso-8859-1":
+			// each byte is a code point
+			r := make([]rune, len(value))
+			for i := 0; i < len(value); i++ {
+				r[i] = rune(value[i])
+			}
+			value = string(r)
+		default:
+			return ""
+		}
+		return SanitizeName(value)
+	}
+	return ""
+}
+
+// SanitizeName makes a name suggested by a server safe to use as a file name:
+// without directories, control characters, or surrounding spaces.
+// It returns "" if nothing usable is left.
+func SanitizeName(name string) string {
+	// servers aren't allowed to pick the directory
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,75 @@
+package fetch
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestDefaultName(t *testing.T) {
+	tests := []struct {
+		source      string
+		final       string // after redirects
+		disposition string
+		want        string
+	}{
+		{"https://host/dir/file.zip", "", "", "file.zip"},
+		{"https://host/dir/", "", "", "dir"},
+		{"https://host", "", "", "index.html"},
+		{"https://host/download?id=1", "https://cdn/x/file.tar.gz", "", "file.tar.gz"},
+		{"https://host/latest", "https://cdn/x/blob", "", "latest"},
+		{"https://host/file.zip", "", `attachment; filename="other.zip"`, "other.zip"},
+		{"https://host/file.zip", "", `attachment; filename="../../etc/passwd"`, "passwd"},
+		{"https://host/file.zip", "", `attachment; filename="C:\\dir\\evil.exe"`, "evil.exe"},
+		{"https://host/file.zip", "", `attachment; filename=".."`, "file.zip"},
+		{"https://host/file.zip", "", "attachment; filename=\"a\x7fb.zip\"", "ab.zip"},
+		{"https://host/file.zip", "", `attachment; filename="plain.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`, "€ rates.txt"},
+		{"https://host/file.zip", "", `attachment; filename*=iso-8859-1'en'%A3%20rates.txt`, "£ rates.txt"},
+		{"https://host/file.zip", "", `attachment; filename*=UTF-8''..%2F..%2Fescape.txt`, "escape.txt"},
+		{"https://host/file.zip", "", `attachment; filename*=koi8-r''x.txt`, "file.zip"},
+		{"https://host/file.zip", "", `attachment; filename=`, "file.zip"},
+	}
+	for _, tt := range tests {
+		final := tt.final
+		if final == "" {
+			final = tt.source
+		}
+		u, err := url.Parse(final)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := &http.Response{
+			Header:  http.Header{},
+			Request: &http.Request{URL: u},
+		}
+		if tt.disposition != "" {
+			res.Header.Set("Content-Disposition", tt.disposition)
+		}
+		if got := DefaultName(tt.source, res); got != tt.want {
+			t.Errorf("DefaultName(%q, %q) = %q; want %q", tt.source, tt.disposition, got, tt.want)
+		}
+	}
+}
+
+func TestDispositionName(t *testing.T) {
+	tests := map[string]string{
+		`attachment; filename="tool.tar.gz"`:                             "tool.tar.gz",
+		`attachment; filename=tool.zip`:                                  "tool.zip",
+		`attachment; filename*=UTF-8''%E2%82%AC%20rates.txt`:             "€ rates.txt",
+		`attachment; filename="fallback.txt"; filename*=UTF-8''pref.txt`: "pref.txt",
+		`attachment; filename*=iso-8859-1'en'caf%E9.txt`:                 "café.txt",
+		`attachment; filename*=koi8-r''x.txt; filename="plain.txt"`:      "plain.txt",
+		`attachment; filename="../../etc/passwd"`:                        "passwd",
+		`attachment; filename="C:\\Windows\\evil.exe"`:                   "evil.exe",
+		"attachment; filename=\"  spaced\x7f.txt  \"":                    "spaced.txt",
+		`attachment; filename=".."`:                                      "",
+		`attachment; filename="unterminated`:                             "",
+		`inline`:                                                         "",
+		``:                                                               "",
+	}
+	for disp, want := range tests {
+		if got := dispositionName(disp); got != want {
+			t.Errorf("dispositionName(%q) = %q, want %q", disp, got, want)
+		}
+	}
+}
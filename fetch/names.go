@@ -0,0 +1,130 @@
+package fetch
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Archives made on Linux may contain names that can't be extracted
+// on Windows (CON, aux.txt), or that collide on case-insensitive
+// file systems (a.txt and A.txt), as on Windows and macOS.
+
+// nameGuard applies the PortableNames policy to the entries
+// extracted by a single unarchive.
+type nameGuard struct {
+	policy  PortableNames
+	logf    func(format string, v ...interface{})
+	seen    map[string]string // case folded names, to the names used
+	renamed map[string]string // renamed names, to their replacements
+}
+
+func newNameGuard(policy PortableNames, logf func(format string, v ...interface{})) *nameGuard {
+	return &nameGuard{policy: policy, logf: logf, seen: map[string]string{}, renamed: map[string]string{}}
+}
+
+// check returns the name an entry should be extracted with,
+// or "", if it should be skipped.
+func (g *nameGuard) check(name string) (string, error) {
+	clean, ok := localName(name)
+	if !ok || g.policy == NamesKeep {
+		return name, nil
+	}
+
+	var orig, used string
+	for _, elem := range strings.Split(clean, "/") {
+		orig = path.Join(orig, elem)
+		if r, ok := g.renamed[orig]; ok {
+			used = r
+			continue
+		}
+
+		next := path.Join(used, elem)
+		var problem string
+		if reservedName(elem) {
+			problem = "name is reserved on Windows"
+		} else if prev, ok := g.seen[foldName(next)]; ok && prev != next {
+			problem = fmt.Sprintf("name differs only in case from %q", prev)
+		}
+		if problem != "" {
+			switch g.policy {
+			case NamesError:
+				return "", fmt.Errorf("archive entry %q: %s", name, problem)
+			case NamesSkip:
+				g.logf("skipping %q: %s", name, problem)
+				return "", nil
+			}
+			next = g.unique(used, elem)
+			g.renamed[orig] = next
+			g.logf("renaming %q to %q: %s", orig, next, problem)
+		}
+		g.seen[foldName(next)] = next
+		used = next
+	}
+
+	if used == clean {
+		return name, nil
+	}
+	return used, nil
+}
+
+// link returns the name a hardlink target was extracted with.
+func (g *nameGuard) link(name string) string {
+	clean, ok := localName(name)
+	if !ok || len(g.renamed) == 0 {
+		return name
+	}
+	for dir := clean; dir != "."; dir = path.Dir(dir) {
+		if r, ok := g.renamed[dir]; ok {
+			return r + strings.TrimPrefix(clean, dir)
+		}
+	}
+	return name
+}
+
+// unique finds a portable replacement for elem, in dir,
+// numbering it like a~1.txt.
+func (g *nameGuard) unique(dir, elem string) string {
+	base, ext := elem, ""
+	if i := strings.IndexByte(elem, '.'); i > 0 {
+		base, ext = elem[:i], elem[i:]
+	}
+	for n := 1; ; n++ {
+		next := path.Join(dir, base+"~"+strconv.Itoa(n)+ext)
+		if _, ok := g.seen[foldName(next)]; !ok {
+			return next
+		}
+	}
+}
+
+// localName cleans an entry name, reporting if it's a path
+// below the target directory.
+func localName(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean(name), "/")
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return name, false
+	}
+	return name, true
+}
+
+func foldName(name string) string {
+	return strings.ToLower(name)
+}
+
+// reservedName reports if Windows reserves a file name for a device,
+// with or without an extension.
+func reservedName(elem string) bool {
+	if i := strings.IndexByte(elem, '.'); i >= 0 {
+		elem = elem[:i]
+	}
+	elem = strings.ToUpper(strings.TrimRight(elem, " "))
+	switch elem {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(elem) == 4 && (strings.HasPrefix(elem, "COM") || strings.HasPrefix(elem, "LPT")) {
+		return '1' <= elem[3] && elem[3] <= '9'
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package fetch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReservedName(t *testing.T) {
+	tests := map[string]bool{
+		"CON":      true,
+		"con":      true,
+		"aux.txt":  true,
+		"NUL .tar": true,
+		"COM1":     true,
+		"lpt9.log": true,
+		"COM0":     false,
+		"COM10":    false,
+		"CONSOLE":  false,
+		"icon":     false,
+		"file.con": false,
+	}
+	for name, want := range tests {
+		if got := reservedName(name); got != want {
+			t.Errorf("reservedName(%q) = %v", name, got)
+		}
+	}
+}
+
+func TestNameGuard(t *testing.T) {
+	names := []string{"dir/a.txt", "DIR/b.txt", "dir/A.txt", "aux.c", "dir/aux"}
+	tests := []struct {
+		policy PortableNames
+		want   []string
+		err    bool
+	}{
+		{policy: NamesKeep, want: names},
+		{policy: NamesRename, want: []string{"dir/a.txt", "DIR~1/b.txt", "dir/A~1.txt", "aux~1.c", "dir/aux~1"}},
+		{policy: NamesSkip, want: []string{"dir/a.txt", "", "", "", ""}},
+		{policy: NamesError, want: []string{"dir/a.txt"}, err: true},
+	}
+	for _, tt := range tests {
+		g := newNameGuard(tt.policy, t.Logf)
+		var got []string
+		var err error
+		for _, name := range names {
+			var n string
+			if n, err = g.check(name); err != nil {
+				break
+			}
+			got = append(got, n)
+		}
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, %v", tt.policy, got, err)
+		}
+	}
+
+	g := newNameGuard(NamesRename, t.Logf)
+	g.check("Dir/file")
+	g.check("dir/file")
+	if got := g.link("dir/file"); got != "dir~1/file" {
+		t.Errorf("link() = %q", got)
+	}
+}
@@ -0,0 +1,243 @@
+// Package fetch downloads files with the transport of go-fetch:
+// authentication, retries, resumption of interrupted transfers,
+// and decoding of compressed ones.
+//
+// A Fetcher saves, verifies and unpacks downloads;
+// Open hands off the stream, so that programs can consume it as they see fit.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configure how a url is opened.
+// The zero value is usable: it uses http.DefaultClient, and never retries.
+type Options struct {
+	Client *http.Client // nil for http.DefaultClient
+	Header http.Header  // added to every request
+
+	// Username and Password authenticate with basic auth,
+	// unless the url has its own user info;
+	// Token authenticates as a bearer.
+	Username string
+	Password string
+	Token    string
+
+	// Retries is how many times a request is retried,
+	// when the server asks to be retried later (429, 503),
+	// or the connection drops, and the transfer can be resumed.
+	Retries int
+
+	// KeepEncoding doesn't ask for a compressed transfer,
+	// nor decodes one, so the bytes read are exactly those sent.
+	KeepEncoding bool
+
+	// Name suggests the file name of Metadata;
+	// nil for DefaultName.
+	Name func(source string, res *http.Response) string
+}
+
+// Metadata describes an opened url.
+type Metadata struct {
+	URL          string      // final, after redirects
+	Name         string      // suggested file name
+	ContentType  string      // media type, without parameters
+	Size         int64       // of the stream, or -1 if unknown
+	ETag         string      // of the representation
+	LastModified time.Time   // zero, if unknown
+	Header       http.Header // of the response
+}
+
+// DefaultRetries is how many times the go-fetch command retries.
+const DefaultRetries = 3
+
+// Open requests source, an http(s) url, and returns its body for reading.
+// If the connection drops, the rest of the body is requested from
+// where it was interrupted, if the server supports range requests.
+// The caller must close the returned body.
+func Open(ctx context.Context, source string, opts *Options) (io.ReadCloser, Metadata, error) {
+	if opts == nil {
+		opts = &Options{Retries: DefaultRetries}
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, Metadata{}, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	for k, v := range opts.Header {
+		req.Header[k] = v
+	}
+	switch {
+	case u.User != nil:
+		// the url's user info is used as is
+	case opts.Token != "":
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	case opts.Username != "" || opts.Password != "":
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	if !opts.KeepEncoding {
+		// setting this stops the transport from decoding gzip itself,
+		// so that ranges are of the encoded bytes
+		req.Header.Set("Accept-Encoding", AcceptEncoding)
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	o := &opener{opts: opts}
+	res, err := o.send(req)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, Metadata{}, errors.New("http error: " + res.Status)
+	}
+
+	r := &resumer{opener: o, res: res}
+	// fail, rather than mix ranges of different versions of the file
+	if res.Header.Get("Accept-Ranges") == "bytes" {
+		r.validator = res.Header.Get("ETag")
+		if r.validator == "" || strings.HasPrefix(r.validator, "W/") {
+			r.validator = res.Header.Get("Last-Modified")
+		}
+	}
+
+	meta := responseMetadata(source, res, opts.Name)
+	body, err := Decode(r, res.Header.Get("Content-Encoding"))
+	if err != nil {
+		r.Close()
+		return nil, Metadata{}, err
+	}
+	if body != io.ReadCloser(r) {
+		meta.Size = -1
+	}
+	return body, meta, nil
+}
+
+// opener sends requests, retrying them as configured.
+type opener struct {
+	opts    *Options
+	retried int
+}
+
+func (o *opener) send(req *http.Request) (*http.Response, error) {
+	client := o.opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if o.opts.Retries > 0 {
+		retrying := *client
+		retrying.Transport = &RetryTransport{Base: client.Transport, Retries: o.opts.Retries}
+		client = &retrying
+	}
+	return client.Do(req)
+}
+
+// wait counts a retry, and waits before it.
+func (o *opener) wait(ctx context.Context, d time.Duration) error {
+	o.retried++
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resumer reads a response body, requesting the rest of it
+// with a range request, if the connection drops.
+type resumer struct {
+	*opener
+	res       *http.Response
+	validator string // ETag or Last-Modified; empty if ranges aren't supported
+	read      int64
+	err       error // of a read that returned data, to resume on the next one
+}
+
+func (r *resumer) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		err, r.err = r.err, nil
+	} else {
+		n, err = r.res.Body.Read(p)
+		r.read += int64(n)
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	// resume only what can be, and while there are retries left
+	ctx := r.res.Request.Context()
+	if r.validator == "" || r.retried >= r.opts.Retries || ctx.Err() != nil {
+		return n, err
+	}
+	// hand off what was read, before resuming
+	if n > 0 {
+		r.err = err
+		return n, nil
+	}
+	if rerr := r.resume(); rerr != nil {
+		return 0, err
+	}
+	return r.Read(p)
+}
+
+// resume requests the rest of the body, from where it was interrupted.
+func (r *resumer) resume() error {
+	if err := r.wait(r.res.Request.Context(), time.Second<<r.retried); err != nil {
+		return err
+	}
+	req := r.res.Request.Clone(r.res.Request.Context())
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(r.read, 10)+"-")
+	req.Header.Set("If-Range", r.validator)
+
+	res, err := r.send(req)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(res.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(r.read, 10)+"-") {
+		res.Body.Close()
+		return errors.New("http error: can't resume: " + res.Status)
+	}
+	r.res.Body.Close()
+	r.res = res
+	return nil
+}
+
+func (r *resumer) Close() error {
+	return r.res.Body.Close()
+}
+
+func responseMetadata(source string, res *http.Response, name func(string, *http.Response) string) Metadata {
+	if name == nil {
+		name = DefaultName
+	}
+	typ, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	modified, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return Metadata{
+		URL:          res.Request.URL.String(),
+		Name:         name(source, res),
+		ContentType:  typ,
+		Size:         res.ContentLength,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: modified,
+		Header:       res.Header,
+	}
+}
@@ -0,0 +1,162 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen_resume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+
+	tests := []struct {
+		name    string
+		ranges  bool   // Accept-Ranges: bytes
+		etag    string // of the resumed response
+		retries int
+		wantErr bool
+	}{
+		{name: "resumed", ranges: true, etag: `"v1"`, retries: 1},
+		{name: "no ranges", ranges: false, etag: `"v1"`, retries: 1, wantErr: true},
+		{name: "no retries", ranges: true, etag: `"v1"`, retries: 0, wantErr: true},
+		{name: "changed", ranges: true, etag: `"v2"`, retries: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					w.Header().Set("ETag", tt.etag)
+					http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+					return
+				}
+
+				// send half the body, and drop the connection
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nETag: \"v1\"\r\n", len(content))
+				if tt.ranges {
+					buf.WriteString("Accept-Ranges: bytes\r\n")
+				}
+				buf.WriteString("\r\n")
+				buf.Write(content[:len(content)/2])
+				buf.Flush()
+			}))
+			defer srv.Close()
+
+			body, meta, err := Open(context.Background(), srv.URL+"/file.bin", &Options{Retries: tt.retries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			if meta.Name != "file.bin" || meta.Size != int64(len(content)) {
+				t.Errorf("got %q (%d bytes)", meta.Name, meta.Size)
+			}
+
+			got, err := ioutil.ReadAll(body)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("got %d bytes, want %d", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestOpen_scheme(t *testing.T) {
+	_, _, err := Open(context.Background(), "ftp://example.com/file", nil)
+	if err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestOpen_retry(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	for _, retries := range []int{0, 1} {
+		requests = 0
+		body, _, err := Open(context.Background(), srv.URL, &Options{Retries: retries})
+		if retries == 0 {
+			if err == nil {
+				body.Close()
+				t.Error("without retries: want error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(body)
+		body.Close()
+		if string(got) != "hello" || requests != 2 {
+			t.Errorf("got %q after %d requests", got, requests)
+		}
+	}
+}
+
+func TestOpen_options(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Custom") != "1" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gz.Bytes())
+			return
+		}
+		w.Write([]byte("identity"))
+	}))
+	defer srv.Close()
+
+	for _, keep := range []bool{false, true} {
+		opts := &Options{Token: "token", Header: http.Header{"X-Custom": {"1"}}, KeepEncoding: keep}
+		body, meta, err := Open(context.Background(), srv.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]string{false: "hello", true: "identity"}[keep]; string(got) != want {
+			t.Errorf("KeepEncoding %v: got %q, want %q", keep, got, want)
+		}
+		if !keep && meta.Size != -1 {
+			t.Errorf("decoded size = %d, want -1", meta.Size)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package fetch
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+var warnOwner sync.Once
+
+// chownEntry gives an extracted entry the owner set WithOwner,
+// or else the owner recorded in the archive, WithSameOwner,
+// if running as root.
+func (f *Fetcher) chownEntry(path string, e *archiveEntry, mode os.FileMode) error {
+	uid, gid := f.uid, f.gid
+	if f.sameOwner && e.hasMode {
+		if os.Geteuid() == 0 {
+			if uid < 0 {
+				uid = e.uid
+				if !f.numericOwner {
+					uid = lookupUser(e.uname, uid)
+				}
+			}
+			if gid < 0 {
+				gid = e.gid
+				if !f.numericOwner {
+					gid = lookupGroup(e.gname, gid)
+				}
+			}
+		} else {
+			warnOwner.Do(func() {
+				f.log("not running as root; keeping the current owner")
+			})
+		}
+	}
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return err
+	}
+	// changing the owner clears the setuid and setgid bits
+	if mode&(os.ModeSetuid|os.ModeSetgid) != 0 && mode&os.ModeSymlink == 0 {
+		return os.Chmod(path, mode)
+	}
+	return nil
+}
+
+// lookupUser finds the uid of a user name, or returns def.
+func lookupUser(name string, def int) int {
+	if name != "" {
+		if u, err := user.Lookup(name); err == nil {
+			if id, err := strconv.Atoi(u.Uid); err == nil {
+				return id
+			}
+		}
+	}
+	return def
+}
+
+// lookupGroup finds the gid of a group name, or returns def.
+func lookupGroup(name string, def int) int {
+	if name != "" {
+		if g, err := user.LookupGroup(name); err == nil {
+			if id, err := strconv.Atoi(g.Gid); err == nil {
+				return id
+			}
+		}
+	}
+	return def
+}
@@ -0,0 +1,26 @@
+package fetch
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestLookupOwner(t *testing.T) {
+	if got := lookupUser("", 42); got != 42 {
+		t.Errorf("lookupUser(\"\") = %d", got)
+	}
+	if got := lookupUser("go-fetch-no-such-user", 42); got != 42 {
+		t.Errorf("lookupUser(missing) = %d", got)
+	}
+	if got := lookupGroup("go-fetch-no-such-group", 42); got != 42 {
+		t.Errorf("lookupGroup(missing) = %d", got)
+	}
+	if runtime.GOOS == "linux" {
+		if got := lookupUser("root", 42); got != 0 {
+			t.Errorf("lookupUser(root) = %d", got)
+		}
+		if got := lookupGroup("root", 42); got != 0 {
+			t.Errorf("lookupGroup(root) = %d", got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package fetch
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// checkPolicy refuses archive entries that Permissions deny.
+func (f *Fetcher) checkPolicy(e *archiveEntry) error {
+	mode := e.Mode()
+	if f.perms.DenySetuid && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		return fmt.Errorf("archive contains setuid/setgid file %q", e.name)
+	}
+	if f.perms.DenyWorldWritable && worldWritable(e) {
+		return fmt.Errorf("archive contains world-writable file %q", e.name)
+	}
+	return nil
+}
+
+const suidBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// policyMode returns the mode an entry should be extracted with.
+func (f *Fetcher) policyMode(e *archiveEntry) os.FileMode {
+	mode := e.Mode()
+	if !f.perms.PreserveSuid {
+		mode &^= suidBits
+	}
+	if f.perms.StripSetuid {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	if f.perms.StripWorldWritable && mode&os.ModeSymlink == 0 {
+		mode &^= 0002
+	}
+	return mode
+}
+
+func suidNames(bits os.FileMode) string {
+	var names []string
+	if bits&os.ModeSetuid != 0 {
+		names = append(names, "setuid")
+	}
+	if bits&os.ModeSetgid != 0 {
+		names = append(names, "setgid")
+	}
+	if bits&os.ModeSticky != 0 {
+		names = append(names, "sticky")
+	}
+	return strings.Join(names, "/")
+}
+
+func worldWritable(e *archiveEntry) bool {
+	return e.hasMode && e.Mode()&os.ModeSymlink == 0 && e.Mode()&0002 != 0
+}
@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"archive/tar"
+	"os"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	entry := func(mode int64, typ byte) *archiveEntry {
+		h := &tar.Header{Name: "file", Mode: mode, Typeflag: typ}
+		return &archiveEntry{FileInfo: h.FileInfo(), name: h.Name, hasMode: true}
+	}
+	setuid := entry(04755, tar.TypeReg)
+	setgid := entry(02755, tar.TypeReg)
+	writable := entry(0666, tar.TypeReg)
+	symlink := entry(0777, tar.TypeSymlink)
+	plain := entry(0644, tar.TypeReg)
+
+	tests := []struct {
+		name     string
+		perms    Permissions
+		entry    *archiveEntry
+		wantErr  bool
+		wantMode os.FileMode
+	}{
+		{"no policy", Permissions{}, setuid, false, 0755 | os.ModeSetuid},
+		{"deny setuid", Permissions{DenySetuid: true}, setuid, true, 0755 | os.ModeSetuid},
+		{"deny setgid", Permissions{DenySetuid: true}, setgid, true, 0755 | os.ModeSetgid},
+		{"deny setuid, plain", Permissions{DenySetuid: true}, plain, false, 0644},
+		{"deny world-writable", Permissions{DenyWorldWritable: true}, writable, true, 0666},
+		{"deny world-writable, symlink", Permissions{DenyWorldWritable: true}, symlink, false, 0777 | os.ModeSymlink},
+		{"strip setuid", Permissions{StripSetuid: true}, setuid, false, 0755},
+		{"strip setgid", Permissions{StripSetuid: true}, setgid, false, 0755},
+		{"strip world-writable", Permissions{StripWorldWritable: true}, writable, false, 0664},
+		{"strip world-writable, symlink", Permissions{StripWorldWritable: true}, symlink, false, 0777 | os.ModeSymlink},
+	}
+	for _, tt := range tests {
+		tt.perms.PreserveSuid = true
+		f := New(WithPermissions(tt.perms))
+		err := f.checkPolicy(tt.entry)
+		mode := f.policyMode(tt.entry)
+		if (err != nil) != tt.wantErr || mode != tt.wantMode {
+			t.Errorf("%s: got %v, %v; want error %v, %v", tt.name, err, mode, tt.wantErr, tt.wantMode)
+		}
+	}
+
+	// unless preserved, the bits are stripped
+	sticky := entry(01777, tar.TypeDir)
+	for _, e := range []*archiveEntry{setuid, setgid, sticky} {
+		if mode := New().policyMode(e); mode&suidBits != 0 {
+			t.Errorf("without PreserveSuid: got %v", mode)
+		}
+	}
+}
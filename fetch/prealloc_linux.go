@@ -0,0 +1,13 @@
+package fetch
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk for f, without changing
+// its size, so a short download doesn't look complete.
+func preallocate(f *os.File, size int64) {
+	const keepSize = 1 // FALLOC_FL_KEEP_SIZE
+	_ = syscall.Fallocate(int(f.Fd()), keepSize, 0, size)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package fetch
+
+import "os"
+
+// preallocate extends f to size bytes, so the file system can allocate
+// them together; the file is renamed into place only once complete.
+func preallocate(f *os.File, size int64) {
+	_ = f.Truncate(size)
+}
@@ -0,0 +1,122 @@
+package fetch
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRetryAfter is the longest Retry-After that is waited for.
+const MaxRetryAfter = 10 * time.Minute
+
+// RetryAfter parses a Retry-After header, in seconds or as a date.
+func RetryAfter(h string) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if s, err := strconv.ParseUint(h, 10, 32); err == nil {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// RetryTransport retries requests that the server
+// asks to be retried later, up to Retries times.
+type RetryTransport struct {
+	Base    http.RoundTripper // nil for http.DefaultTransport
+	Retries int
+
+	// Statuses that mean a request can be retried later,
+	// codes like 503, or ranges like 5xx; nil for 429 and 503.
+	Statuses map[string]bool
+
+	// Logf, if not nil, logs each retry.
+	Logf func(format string, v ...interface{})
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := base.RoundTrip(req)
+		if err != nil || attempt >= t.Retries || !t.retry(res.StatusCode) {
+			return res, err
+		}
+
+		wait, ok := RetryAfter(res.Header.Get("Retry-After"))
+		if !ok {
+			wait = time.Second << attempt
+		}
+		if wait > MaxRetryAfter || req.Body != nil && req.GetBody == nil {
+			return res, nil
+		}
+
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		if t.Logf != nil {
+			t.Logf("%s: %s; retrying in %v", req.URL.Redacted(), res.Status, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func (t *RetryTransport) retry(code int) bool {
+	statuses := t.Statuses
+	if statuses == nil {
+		statuses = defaultStatuses
+	}
+	s := strconv.Itoa(code)
+	return statuses[s] || statuses[s[:1]+"xx"]
+}
+
+var defaultStatuses = map[string]bool{"429": true, "503": true}
+
+// ParseStatuses parses a comma separated list of codes, or ranges like 5xx,
+// for RetryTransport.Statuses.
+func ParseStatuses(s string) (map[string]bool, error) {
+	statuses := map[string]bool{}
+	for _, code := range strings.Split(s, ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		switch {
+		case code == "":
+			continue
+		case len(code) == 3 && code[0] >= '1' && code[0] <= '5' && code[1:] == "xx":
+		default:
+			if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 {
+				return nil, fmt.Errorf("invalid HTTP status %q", code)
+			}
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
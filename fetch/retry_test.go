@@ -0,0 +1,118 @@
+package fetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{header: ""},
+		{header: "soon"},
+		{header: "-1"},
+		{header: "0", ok: true},
+		{header: "120", want: 2 * time.Minute, ok: true},
+		{header: "Sun, 06 Nov 1994 08:49:37 GMT", ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := RetryAfter(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("RetryAfter(%q) = %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/busy":
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case "/later":
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "later", http.StatusTooManyRequests)
+		default:
+			if requests < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: &RetryTransport{Retries: 2}}
+
+	tests := []struct {
+		path     string
+		status   int
+		requests int
+	}{
+		{path: "/file", status: http.StatusOK, requests: 3},
+		{path: "/busy", status: http.StatusServiceUnavailable, requests: 3},
+		{path: "/later", status: http.StatusTooManyRequests, requests: 1},
+	}
+	for _, tt := range tests {
+		requests = 0
+		res, err := c.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status || requests != tt.requests {
+			t.Errorf("GET %s = %d after %d requests; want %d after %d",
+				tt.path, res.StatusCode, requests, tt.status, tt.requests)
+		}
+	}
+}
+
+func TestParseStatuses(t *testing.T) {
+	tests := []struct {
+		list    string
+		retry   []int
+		fatal   []int
+		wantErr bool
+	}{
+		{list: "429,503", retry: []int{429, 503}, fatal: []int{403, 500}},
+		{list: " 403 , 5XX ", retry: []int{403, 500, 599}, fatal: []int{429, 404}},
+		{list: "", fatal: []int{429, 503}},
+		{list: "600", wantErr: true},
+		{list: "5xy", wantErr: true},
+		{list: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		statuses, err := ParseStatuses(tt.list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseStatuses(%q): want error", tt.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseStatuses(%q) error: %v", tt.list, err)
+			continue
+		}
+		rt := &RetryTransport{Statuses: statuses}
+		for _, code := range tt.retry {
+			if !rt.retry(code) {
+				t.Errorf("ParseStatuses(%q): %d not retried", tt.list, code)
+			}
+		}
+		for _, code := range tt.fatal {
+			if rt.retry(code) {
+				t.Errorf("ParseStatuses(%q): %d retried", tt.list, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package fetch
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Partial files are written next to their destination,
+// and renamed into place once complete; the clean subcommand
+// of the go-fetch command removes those left behind.
+const (
+	PartialPrefix = ".go-fetch-"
+	PartialSuffix = ".part"
+)
+
+// PartialName is the name of the partial file written before path.
+func PartialName(path string) string {
+	return filepath.Join(filepath.Dir(path), PartialPrefix+filepath.Base(path)+PartialSuffix)
+}
+
+// Target describes where Save writes a stream,
+// and, once it's done, what it wrote.
+type Target struct {
+	// Path is the file written, or the directory archives are
+	// extracted to. With IsDir, files are saved in it, as Name.
+	Path   string
+	IsDir  bool
+	Stdout bool // writes files to os.Stdout, instead
+
+	// Name and ContentType describe the stream, hinting at its format;
+	// compression extensions are stripped from Name as it's decompressed.
+	Name        string
+	ContentType string
+
+	Size   int64  // of the stream, to preallocate files, if known
+	Subdir string // of archives, the only one extracted
+
+	// Received counts the bytes of the download received so far,
+	// for Limits.MaxRatio; nil counts those Save reads.
+	Received func() int64
+
+	// List, if not nil, is given the entries of archives,
+	// rather than extracting them.
+	List func(Entry) error
+
+	// Track records the files created, in Created.
+	Track bool
+
+	Destination string   // the file saved, or the directory extracted to
+	Created     []string // with Track
+}
+
+// Save writes r to t, unpacking it as configured: files are written
+// to a partial file, renamed into place once r is read to the end;
+// archives are extracted as they're read.
+func (f *Fetcher) Save(ctx context.Context, r io.Reader, t *Target) error {
+	u, err := f.newUnpacker(t)
+	if err != nil {
+		return err
+	}
+	defer u.discardPartial()
+
+	cr := &ctxReader{ctx: ctx, r: r}
+	if u.received == nil {
+		u.received = func() int64 { return cr.read }
+	}
+	if f.unpack == UnpackNever && t.List == nil {
+		var out *os.File
+		if out, err = u.targetFile(); err == nil {
+			if t.Size > 0 && !t.Stdout {
+				preallocate(out, t.Size)
+			}
+			err = f.write(cr, out)
+		}
+	} else {
+		err = u.uncompress(bufio.NewReader(cr))
+	}
+	if err == nil {
+		// archives may end before the stream does
+		_, err = io.Copy(ioutil.Discard, cr)
+	}
+	if err == nil {
+		// only complete, and verified, files are renamed into place
+		err = u.commitPartial()
+	}
+	u.done(t)
+	return err
+}
+
+// SaveZip extracts the zip archive read from ra, of size bytes, to t,
+// reading only the entries that are extracted, or listed.
+func (f *Fetcher) SaveZip(ctx context.Context, ra io.ReaderAt, size int64, t *Target) error {
+	u, err := f.newUnpacker(t)
+	if err != nil {
+		return err
+	}
+	defer u.discardPartial()
+
+	ra = ctxReaderAt{ctx: ctx, r: ra}
+	zr, err := zip.NewReader(ra, size)
+	if err == nil {
+		err = u.extract(&zipArchive{ra: ra, zip: zr, password: f.zipPassword})
+	}
+	if err == nil {
+		err = u.commitPartial()
+	}
+	u.done(t)
+	return err
+}
+
+// unpacker holds the state of a Save: the target,
+// and the archive, or nested archive, being unpacked.
+type unpacker struct {
+	f           *Fetcher
+	target      string
+	targetIsDir bool
+	targetName  string
+	stdout      bool
+	subdir      string
+	format      string // forced layers, left to unwrap
+	hint        string // format suggested by the name, or Content-Type
+	list        func(Entry) error
+
+	destination string
+	partial     string // written, then renamed to destination
+	received    func() int64
+	budget      *Budget  // shared with nested archives
+	nested      int      // nesting level of the archive
+	created     *created // with Target.Track
+}
+
+func (f *Fetcher) newUnpacker(t *Target) (*unpacker, error) {
+	u := &unpacker{
+		f:           f,
+		target:      t.Path,
+		targetIsDir: t.IsDir,
+		targetName:  t.Name,
+		stdout:      t.Stdout,
+		subdir:      t.Subdir,
+		list:        t.List,
+		received:    t.Received,
+	}
+	if f.format != "" {
+		format, err := ParseFormat(f.format)
+		if err != nil {
+			return nil, err
+		}
+		u.format = format
+	} else {
+		u.hint = HintFormat(t.Name, t.ContentType)
+	}
+	if t.Track {
+		u.created = &created{}
+	}
+	return u, nil
+}
+
+// done reports what was written to t.
+func (u *unpacker) done(t *Target) {
+	t.Destination = u.destination
+	if u.created != nil {
+		t.Created = t.Created[:0]
+		for _, p := range u.created.paths {
+			t.Created = append(t.Created, shortPath(p))
+		}
+	}
+}
+
+func (u *unpacker) targetFile() (*os.File, error) {
+	if u.stdout {
+		u.destination = "-"
+		return os.Stdout, nil
+	}
+
+	path := u.target
+	if u.targetIsDir {
+		name := filepath.FromSlash(u.targetName)
+		if name == "" || strings.ContainsRune(name, filepath.Separator) {
+			return nil, fmt.Errorf("illegal file path: %q", u.targetName)
+		}
+		path = filepath.Join(path, name)
+	}
+	if path == "" {
+		return nil, errors.New("no target to save to")
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	// write to a partial file, so an interrupted download never
+	// leaves a truncated target, or writes through a link
+	partial := PartialName(path)
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	u.destination, u.partial = path, partial
+	return f, nil
+}
+
+// commitPartial renames the partial file, if any, into place.
+func (u *unpacker) commitPartial() error {
+	if u.partial == "" {
+		return nil
+	}
+	if err := os.Rename(u.partial, u.destination); err != nil {
+		return err
+	}
+	u.partial = ""
+	u.created.add(u.destination)
+	return u.f.syncDirs(parentDirs(u.destination))
+}
+
+// discardPartial removes the partial file of a failed download.
+func (u *unpacker) discardPartial() {
+	if u.partial != "" {
+		os.Remove(u.partial)
+		u.partial = ""
+	}
+}
+
+// write copies r to w, flushing it, if configured, and closes it.
+func (f *Fetcher) write(r io.Reader, w io.WriteCloser) error {
+	_, err := io.Copy(w, r)
+	if file, ok := w.(*os.File); ok && err == nil {
+		err = f.syncFile(file)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// created records the files created by a Save.
+type created struct {
+	paths []string
+}
+
+func (c *created) add(path string) {
+	if c != nil {
+		c.paths = append(c.paths, path)
+	}
+}
+
+// remove forgets path, e.g. an archive that was replaced by its contents.
+func (c *created) remove(path string) {
+	if c == nil {
+		return
+	}
+	paths := c.paths[:0]
+	for _, p := range c.paths {
+		if p != path {
+			paths = append(paths, p)
+		}
+	}
+	c.paths = paths
+}
+
+// move renames the paths under from to be under to,
+// forgetting from itself.
+func (c *created) move(from, to string) {
+	if c == nil {
+		return
+	}
+	c.remove(from)
+	prefix := from + string(filepath.Separator)
+	for i, p := range c.paths {
+		if strings.HasPrefix(p, prefix) {
+			c.paths[i] = filepath.Join(to, p[len(prefix):])
+		}
+	}
+}
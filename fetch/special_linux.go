@@ -0,0 +1,23 @@
+package fetch
+
+import (
+	"os"
+	"syscall"
+)
+
+// mknod creates a device or FIFO for an archive entry.
+func mknod(path string, e *archiveEntry, mode os.FileMode) error {
+	kind := uint32(syscall.S_IFIFO)
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		kind = syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		kind = syscall.S_IFBLK
+	}
+	major, minor := uint64(e.devmajor), uint64(e.devminor)
+	dev := minor&0xff | major&0xfff<<8 | minor&^0xff<<12 | major&^0xfff<<32
+	if err := syscall.Mknod(path, kind|uint32(mode.Perm()), int(dev)); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
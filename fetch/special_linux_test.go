@@ -0,0 +1,34 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnarchive_special(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644})
+	tw.Close()
+
+	for _, special := range []bool{false, true} {
+		dir := t.TempDir()
+		err := testUnpacker(t, dir, WithSpecialFiles(special)).unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir)
+		if !special {
+			if err == nil {
+				t.Error("FIFO without special files: want error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(filepath.Join(dir, "fifo"))
+		if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+			t.Errorf("special files: got %v, %v", fi, err)
+		}
+	}
+}
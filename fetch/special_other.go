@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package fetch
+
+import (
+	"errors"
+	"os"
+)
+
+// mknod creates a device or FIFO for an archive entry,
+// which isn't supported on this platform.
+func mknod(path string, e *archiveEntry, mode os.FileMode) error {
+	return errors.New("special files aren't supported on this platform")
+}
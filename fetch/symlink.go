@@ -0,0 +1,188 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extracted symlinks could otherwise point outside the target,
+// and later entries be written through them.
+
+var (
+	errEscape   = errors.New("leaves the target directory")
+	errUnstable = errors.New("has .. after a symlink or missing directory")
+)
+
+// linkGuard validates the paths and links extracted under root.
+type linkGuard struct {
+	root  string
+	dirs  map[string]bool // checked not to be symlinks
+	fsync bool            // flush dereferenced copies
+}
+
+func newLinkGuard(root string, fsync bool) *linkGuard {
+	return &linkGuard{root: filepath.Clean(root), dirs: map[string]bool{}, fsync: fsync}
+}
+
+// checkParents fails if a parent directory of path is a symlink.
+func (g *linkGuard) checkParents(path string) error {
+	var check []string
+	for dir := filepath.Dir(path); dir != g.root && !g.dirs[dir]; dir = filepath.Dir(dir) {
+		if len(dir) <= len(g.root) {
+			return fmt.Errorf("illegal file path %q", path)
+		}
+		check = append(check, dir)
+	}
+	for i := len(check) - 1; i >= 0; i-- {
+		fi, err := os.Lstat(check[i])
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("illegal file path %q, through symlink %q", path, check[i])
+		}
+		if err == nil {
+			g.dirs[check[i]] = true
+		}
+	}
+	return nil
+}
+
+// resolve resolves a link target, relative to dir, following
+// the symlinks already extracted, failing if it leaves root.
+func (g *linkGuard) resolve(dir, target string) (string, error) {
+	return g.resolveDepth(dir, target, 0)
+}
+
+func (g *linkGuard) resolveDepth(dir, target string, depth int) (string, error) {
+	if depth > 40 {
+		return "", errors.New("too many levels of symlinks")
+	}
+	target = filepath.ToSlash(target)
+	if strings.HasPrefix(target, "/") || filepath.IsAbs(filepath.FromSlash(target)) {
+		return "", errEscape
+	}
+
+	// Later entries may create a missing directory as a symlink,
+	// or replace a symlink, so where a .. after them leads can change;
+	// only a path through real directories is sure to stay put.
+	cur, stable := dir, isRealDir(dir)
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if !stable {
+				return "", errUnstable
+			}
+			if cur == g.root {
+				return "", errEscape
+			}
+			cur = filepath.Dir(cur)
+			continue
+		}
+		next := filepath.Join(cur, elem)
+		fi, err := os.Lstat(next)
+		switch {
+		case err == nil && fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(next)
+			if err != nil {
+				return "", err
+			}
+			if next, err = g.resolveDepth(cur, link, depth+1); err != nil {
+				return "", err
+			}
+			stable = false
+		case err != nil || !fi.IsDir():
+			stable = false
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func isRealDir(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.IsDir()
+}
+
+// rewrite makes an absolute link target, from a link in dir,
+// relative to root, as if root was the file system root.
+func (g *linkGuard) rewrite(dir, target string) string {
+	slash := filepath.ToSlash(target)
+	if !strings.HasPrefix(slash, "/") && !filepath.IsAbs(target) {
+		return target
+	}
+	if vol := filepath.VolumeName(target); vol != "" {
+		slash = filepath.ToSlash(target[len(vol):])
+	}
+	abs := filepath.Join(g.root, filepath.FromSlash(slash))
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// derefLink is a symlink to extract as a copy of its target,
+// once the target is extracted.
+type derefLink struct {
+	path, name, target string
+}
+
+// deref copies the targets of links, in as many passes
+// as needed for links to other links.
+func (g *linkGuard) deref(links []derefLink) error {
+	for len(links) > 0 {
+		var pending []derefLink
+		var first error
+		for _, l := range links {
+			if err := g.copyTarget(l); os.IsNotExist(err) {
+				if first == nil {
+					first = fmt.Errorf("symlink %q to %q: %w", l.name, l.target, err)
+				}
+				pending = append(pending, l)
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(pending) == len(links) {
+			return first
+		}
+		links = pending
+	}
+	return nil
+}
+
+func (g *linkGuard) copyTarget(l derefLink) error {
+	src, err := g.resolve(filepath.Dir(l.path), l.target)
+	if err != nil {
+		return fmt.Errorf("symlink %q to %q: %w", l.name, l.target, err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("symlink %q to %q: can't dereference a link to a directory, or special file", l.name, l.target)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil && g.fsync {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
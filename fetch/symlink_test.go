@@ -0,0 +1,128 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link string // a directory if name ends in /
+}
+
+func symlinkTar(entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeDir, Mode: 0755})
+		case e.link != "":
+			tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.link, Mode: 0777})
+		default:
+			tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: 2})
+			tw.Write([]byte("ok"))
+		}
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestUnarchive_symlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr bool
+	}{
+		{"relative", []tarEntry{{name: "usr/"}, {name: "usr/lib/"}, {name: "usr/lib/file"},
+			{name: "lib", link: "usr/lib"}, {name: "usr/bin/"}, {name: "usr/bin/lib", link: "../lib/file"}}, false},
+		{"through a link", []tarEntry{{name: "usr/"}, {name: "usr/lib/"}, {name: "lib", link: "usr/lib"},
+			{name: "file", link: "lib/file"}}, false},
+		{"absolute", []tarEntry{{name: "etc", link: "/etc"}}, true},
+		{"parent", []tarEntry{{name: "up", link: ".."}}, true},
+		{"parent of a subdirectory", []tarEntry{{name: "d/"}, {name: "d/up", link: "../.."}}, true},
+		{"chained", []tarEntry{{name: "d/"}, {name: "d/up", link: ".."}, {name: "out", link: "d/up/.."}}, true},
+		{"write through", []tarEntry{{name: "d/"}, {name: "d/e/"}, {name: "link", link: "d"}, {name: "link/e/file"}}, true},
+
+		// d/sub doesn't exist when d/A is checked, and becomes d itself
+		{"missing, then linked", []tarEntry{{name: "d/"},
+			{name: "d/A", link: "sub/../../secret"}, {name: "d/sub", link: "."}}, true},
+		// s is replaced, so that s/sub is one level up
+		{"linked, then replaced", []tarEntry{{name: "p/"}, {name: "p/sub/"}, {name: "sub/"},
+			{name: "s", link: "p"}, {name: "A", link: "s/sub/../../secret"}, {name: "s", link: "."}}, true},
+	}
+
+	for _, tt := range tests {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "target")
+		ioutil.WriteFile(filepath.Join(parent, "secret"), nil, 0666)
+		err := testUnpacker(t, dir).unarchive(newTarArchive(bytes.NewReader(symlinkTar(tt.entries...))), dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+
+		// no link leads out of the target
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				real = path // dangling, so checked lexically
+			}
+			if rel, err := filepath.Rel(dir, real); err != nil || strings.HasPrefix(rel, "..") {
+				t.Errorf("%s: %s leads to %s", tt.name, path, real)
+			}
+			return nil
+		})
+	}
+}
+
+func TestUnarchive_symlinkPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	archive := symlinkTar(tarEntry{name: "bin/"}, tarEntry{name: "bin/tool"},
+		tarEntry{name: "tool", link: "bin/tool"})
+
+	tests := map[Symlinks]string{SymlinksSkip: "", SymlinksDeref: "file", SymlinksKeep: "symlink"}
+	for policy, want := range tests {
+		dir := t.TempDir()
+		if err := testUnpacker(t, dir, WithSymlinks(policy)).unarchive(newTarArchive(bytes.NewReader(archive)), dir); err != nil {
+			t.Errorf("symlinks %s: %v", policy, err)
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(dir, "tool"))
+		var got string
+		switch {
+		case err != nil:
+		case fi.Mode()&os.ModeSymlink != 0:
+			got = "symlink"
+		case fi.Mode().IsRegular():
+			got = "file"
+		}
+		if got != want {
+			t.Errorf("symlinks %s: tool is %q, want %q", policy, got, want)
+		}
+	}
+
+	// absolute targets are made relative to the target directory
+	dir := t.TempDir()
+	archive = symlinkTar(tarEntry{name: "bin/"}, tarEntry{name: "bin/tool"},
+		tarEntry{name: "bin/abs", link: "/bin/tool"})
+	if err := testUnpacker(t, dir, WithSymlinks(SymlinksRewrite)).unarchive(newTarArchive(bytes.NewReader(archive)), dir); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "bin", "abs")); err != nil || link != "tool" {
+		t.Errorf("symlinks rewrite: got %q, %v", link, err)
+	}
+}
@@ -0,0 +1,47 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// syncFile flushes a file being written, with WithFsync.
+func (f *Fetcher) syncFile(file *os.File) error {
+	if !f.fsync || file == os.Stdout {
+		return nil
+	}
+	return file.Sync()
+}
+
+// syncDirs flushes directories, with WithFsync,
+// so that the files created in them persist.
+func (f *Fetcher) syncDirs(dirs map[string]struct{}) error {
+	// Windows can't flush directories, nor does it need to
+	if !f.fsync || runtime.GOOS == "windows" {
+		return nil
+	}
+	for dir := range dirs {
+		file, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentDirs returns the set of parent directories of paths.
+func parentDirs(paths ...string) map[string]struct{} {
+	dirs := map[string]struct{}{}
+	for _, p := range paths {
+		dirs[filepath.Dir(p)] = struct{}{}
+	}
+	return dirs
+}
@@ -0,0 +1,45 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParentDirs(t *testing.T) {
+	got := parentDirs(filepath.FromSlash("a/b/file"), filepath.FromSlash("a/b/other"), "file")
+	want := map[string]struct{}{filepath.FromSlash("a/b"): {}, ".": {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parentDirs() = %v, want %v", got, want)
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, sync := range []bool{false, true} {
+		fetcher := New(WithFsync(sync))
+		if err := fetcher.syncFile(f); err != nil {
+			t.Error(err)
+		}
+		if err := fetcher.syncDirs(parentDirs(f.Name())); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// missing directories fail only when flushing
+	missing := parentDirs(filepath.Join(dir, "missing", "file"))
+	if err := New(WithFsync(true)).syncDirs(missing); err == nil && runtime.GOOS != "windows" {
+		t.Error("syncDirs(missing): want error")
+	}
+	if err := New().syncDirs(missing); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,72 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// verifier checks the digest of everything read from it,
+// failing at EOF if it doesn't match.
+type verifier struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+	name string
+}
+
+// NewVerifier wraps r to check it against digest,
+// which is in algorithm:hex form (e.g. sha256:…).
+// Reading it to the end fails if the digest doesn't match.
+func NewVerifier(r io.ReadCloser, digest string) (io.ReadCloser, error) {
+	i := strings.IndexByte(digest, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed digest %q", digest)
+	}
+	algo, sum := digest[:i], digest[i+1:]
+
+	h := NewHash(algo)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported digest algorithm %q", algo)
+	}
+
+	want, err := hex.DecodeString(sum)
+	if err != nil || len(want) != h.Size() {
+		return nil, fmt.Errorf("malformed digest %q", digest)
+	}
+	return NewHashVerifier(r, h, want, algo), nil
+}
+
+// NewHashVerifier is like NewVerifier, for any hash h:
+// it wraps r to check it against sum, naming algo on a mismatch.
+func NewHashVerifier(r io.ReadCloser, h hash.Hash, sum []byte, algo string) io.ReadCloser {
+	return &verifier{r, h, sum, algo}
+}
+
+// NewHash returns a hash for a digest algorithm
+// (sha256 or sha512), or nil if unsupported.
+func NewHash(algo string) hash.Hash {
+	switch algo {
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := v.hash.Sum(nil); !bytes.Equal(got, v.want) {
+			return n, fmt.Errorf("%s mismatch: got %x, expected %x", v.name, got, v.want)
+		}
+	}
+	return n, err
+}
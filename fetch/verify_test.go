@@ -0,0 +1,49 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	data := "hello world"
+	sha256sum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+	sha512sum := fmt.Sprintf("sha512:%x", sha512.Sum512([]byte(data)))
+
+	tests := []struct {
+		digest     string
+		data       string
+		wantErr    bool // creating the verifier
+		wantErrEOF bool // reading to the end
+	}{
+		{digest: sha256sum, data: data},
+		{digest: sha512sum, data: data},
+		{digest: sha256sum, data: "tampered", wantErrEOF: true},
+		{digest: sha256sum, data: "", wantErrEOF: true},
+		{digest: "md5:5eb63bbbe01eeed093cb22bb8f5acdc3", wantErr: true},
+		{digest: "sha256:xyz", wantErr: true},
+		{digest: sha256sum[:20], wantErr: true},
+		{digest: "hello", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := NewVerifier(ioutil.NopCloser(strings.NewReader(tt.data)), tt.digest)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewVerifier(%q) error = %v, wantErr %v", tt.digest, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if (err != nil) != tt.wantErrEOF {
+			t.Errorf("NewVerifier(%q).Read(%q) error = %v, wantErr %v", tt.digest, tt.data, err, tt.wantErrEOF)
+		}
+		if string(got) != tt.data {
+			t.Errorf("NewVerifier(%q).Read() = %q, want %q", tt.digest, got, tt.data)
+		}
+	}
+}
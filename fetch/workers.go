@@ -0,0 +1,148 @@
+package fetch
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// smallFile is the largest entry that's read in full, and then
+// created and written by a worker; larger files are written as
+// they're read, and only finished by a worker.
+const smallFile = 1 << 20
+
+// fileWrite creates or finishes a file extracted from an archive.
+type fileWrite struct {
+	f     *Fetcher
+	path  string
+	name  string // in the archive
+	mode  os.FileMode
+	entry *archiveEntry
+	file  *os.File // already written, or else
+	data  []byte   // to write
+	done  chan struct{}
+}
+
+func (w *fileWrite) run() error {
+	f, err := w.file, error(nil)
+	if f == nil {
+		f, err = os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, w.mode)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(w.data)
+	}
+	if err == nil {
+		err = w.f.syncFile(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing to %q: %w", w.name, err)
+	}
+
+	if mtime := w.entry.ModTime(); !mtime.IsZero() {
+		_ = os.Chtimes(w.path, mtime, mtime)
+	}
+	if err := w.f.chownEntry(w.path, w.entry, w.mode); err != nil {
+		return err
+	}
+	// after chown, which clears security.capability
+	return w.f.setXattrs(w.path, w.entry, w.mode)
+}
+
+// writePool runs file writes on a few goroutines, so that unpacking
+// archives with many files isn't serialized on syscalls like fsync.
+// With no pool, writes run as they're submitted.
+type writePool struct {
+	work    chan *fileWrite
+	wg      sync.WaitGroup
+	once    sync.Once
+	mtx     sync.Mutex
+	err     error
+	pending map[string]chan struct{} // paths being written
+}
+
+func newWritePool(workers int) *writePool {
+	if workers <= 1 {
+		return nil
+	}
+	p := &writePool{
+		work:    make(chan *fileWrite, workers),
+		pending: map[string]chan struct{}{},
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *writePool) worker() {
+	defer p.wg.Done()
+	for w := range p.work {
+		err := w.run()
+		p.mtx.Lock()
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		if p.pending[w.path] == w.done {
+			delete(p.pending, w.path)
+		}
+		p.mtx.Unlock()
+		close(w.done)
+	}
+}
+
+// submit queues a write, failing if an earlier one failed.
+func (p *writePool) submit(w *fileWrite) error {
+	if p == nil {
+		return w.run()
+	}
+	p.mtx.Lock()
+	err := p.err
+	if err == nil {
+		w.done = make(chan struct{})
+		p.pending[w.path] = w.done
+	}
+	p.mtx.Unlock()
+	if err != nil {
+		if w.file != nil {
+			w.file.Close()
+		}
+		return err
+	}
+	p.work <- w
+	return nil
+}
+
+// wait waits for a write to path to finish, so that later entries
+// for the same path, or linking to it, see the file.
+// It fails if any write failed.
+func (p *writePool) wait(path string) error {
+	if p == nil {
+		return nil
+	}
+	p.mtx.Lock()
+	done := p.pending[path]
+	p.mtx.Unlock()
+	if done != nil {
+		<-done
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.err
+}
+
+// close waits for all writes to finish, returning the first error.
+func (p *writePool) close() error {
+	if p == nil {
+		return nil
+	}
+	p.once.Do(func() {
+		close(p.work)
+		p.wg.Wait()
+	})
+	return p.err
+}
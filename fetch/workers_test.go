@@ -0,0 +1,66 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnarchive_workers(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name, data string) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+	}
+	for i := 0; i < 50; i++ {
+		add(fmt.Sprintf("file%d", i), fmt.Sprint("data", i))
+	}
+	add("twice", "first")
+	add("twice", "second")
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "twice"})
+	tw.Close()
+
+	for _, workers := range []int{1, 8} {
+		dir := t.TempDir()
+		f := New(WithUnpack(UnpackAuto), WithWorkers(workers))
+		if err := f.Save(context.Background(), bytes.NewReader(buf.Bytes()), &Target{Path: dir}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			if got, _ := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("file%d", i))); string(got) != fmt.Sprint("data", i) {
+				t.Errorf("%d workers: file%d = %q", workers, i, got)
+			}
+		}
+		for _, name := range []string{"twice", "link"} {
+			if got, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(got) != "second" {
+				t.Errorf("%d workers: %s = %q", workers, name, got)
+			}
+		}
+	}
+}
+
+func TestWritePool_error(t *testing.T) {
+	dir := t.TempDir()
+	p := newWritePool(2)
+	entry := &archiveEntry{FileInfo: (&tar.Header{Name: "file"}).FileInfo()}
+
+	bad := filepath.Join(dir, "missing", "file")
+	if err := p.submit(&fileWrite{f: New(), path: bad, name: "bad", mode: 0644, entry: entry}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.wait(bad); err == nil {
+		t.Error("wait() want error")
+	}
+	good := filepath.Join(dir, "file")
+	if err := p.submit(&fileWrite{f: New(), path: good, name: "good", mode: 0644, entry: entry}); err == nil {
+		t.Error("submit() after an error: want error")
+	}
+	if err := p.close(); err == nil {
+		t.Error("close() want error")
+	}
+}
@@ -0,0 +1,21 @@
+package fetch
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// setXattrs applies the extended attributes of an archive entry,
+// with WithXattrs; symlinks are skipped, as they'd be followed.
+func (f *Fetcher) setXattrs(path string, e *archiveEntry, mode os.FileMode) error {
+	if !f.xattrs || mode&os.ModeSymlink != 0 {
+		return nil
+	}
+	for name, value := range e.xattrs {
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("setting xattr %s on %q: %w", name, e.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package fetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestUnarchive_xattrs(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{
+		Name: "file", Mode: 0644, Size: 2, Format: tar.FormatPAX,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.go-fetch": "value"},
+	})
+	tw.Write([]byte("ok"))
+	tw.Close()
+
+	dir := t.TempDir()
+	err := testUnpacker(t, dir, WithXattrs(true)).unarchive(newTarArchive(bytes.NewReader(buf.Bytes())), dir)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := make([]byte, 16)
+	n, err := syscall.Getxattr(filepath.Join(dir, "file"), "user.go-fetch", value)
+	if err != nil || string(value[:n]) != "value" {
+		t.Errorf("user.go-fetch = %q, %v", value[:n], err)
+	}
+}
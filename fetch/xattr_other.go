@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package fetch
+
+import (
+	"errors"
+	"os"
+)
+
+// setXattrs applies the extended attributes of an archive entry,
+// with WithXattrs, which isn't supported on this platform.
+func (f *Fetcher) setXattrs(path string, e *archiveEntry, mode os.FileMode) error {
+	if !f.xattrs || mode&os.ModeSymlink != 0 || len(e.xattrs) == 0 {
+		return nil
+	}
+	return errors.New("extended attributes aren't supported on this platform")
+}
@@ -0,0 +1,298 @@
+package fetch
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted zip entries can't be read as a stream, as zipstream doesn't
+// decrypt them, so with a zip password, zips are saved to a temporary
+// file, and read from their central directory.
+
+var errZipPassword = errors.New("wrong zip password")
+
+// zipArchive reads the entries of a zip archive from its central
+// directory, rather than as a stream: saved to a temporary file,
+// or with range requests.
+type zipArchive struct {
+	ra       io.ReaderAt
+	file     *os.File // temporary, removed by Close
+	zip      *zip.Reader
+	password string
+	next     int
+	entry    io.Reader
+	open     *zip.File // the current entry, until read
+}
+
+// spoolZip saves a zip archive to a temporary file.
+func spoolZip(r io.Reader, password string) (*zipArchive, error) {
+	f, err := ioutil.TempFile("", PartialPrefix+"*"+PartialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	z := &zipArchive{ra: f, file: f, password: password}
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		z.zip, err = zip.NewReader(f, n)
+	}
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+	return z, nil
+}
+
+// Close removes the temporary file, if any.
+func (z *zipArchive) Close() error {
+	if z.file == nil {
+		return nil
+	}
+	z.file.Close()
+	return os.Remove(z.file.Name())
+}
+
+// Next moves to the next entry of the archive.
+// Its contents are only read, and decrypted, if needed.
+func (z *zipArchive) Next() (*zip.FileHeader, error) {
+	if z.next >= len(z.zip.File) {
+		return nil, io.EOF
+	}
+	f := z.zip.File[z.next]
+	z.next++
+	z.entry, z.open = nil, f
+	return &f.FileHeader, nil
+}
+
+// Read reads the contents of the current entry.
+func (z *zipArchive) Read(p []byte) (int, error) {
+	if f := z.open; f != nil {
+		z.open = nil
+		var err error
+		if f.Flags&0x1 != 0 {
+			z.entry, err = openEncrypted(z.ra, f, z.password)
+		} else {
+			z.entry, err = f.Open()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if z.entry == nil {
+		return 0, io.EOF
+	}
+	return z.entry.Read(p)
+}
+
+// openEncrypted decrypts and decompresses an entry, with the
+// traditional PKWARE encryption, or WinZip AES.
+func openEncrypted(ra io.ReaderAt, f *zip.File, password string) (io.Reader, error) {
+	off, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	raw := io.NewSectionReader(ra, off, int64(f.CompressedSize64))
+
+	var data io.Reader
+	method, checkCRC := f.Method, true
+	if f.Method == 99 {
+		var version uint16
+		var strength byte
+		version, strength, method, err = aesExtra(f.Extra)
+		if err == nil {
+			data, err = newAESReader(raw, []byte(password), strength)
+		}
+		// AE-2 omits the CRC, as the authentication code covers the data
+		checkCRC = version == 1
+	} else {
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&0x8 != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		data, err = newZipCryptoReader(raw, []byte(password), check)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := data
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		r = flate.NewReader(data)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	return &zipEntryReader{r: r, data: data, crc: crc32.NewIEEE(), want: f.CRC32, checkCRC: checkCRC}, nil
+}
+
+// zipEntryReader checks the contents of a decrypted entry, when done.
+type zipEntryReader struct {
+	r        io.Reader // decompressed
+	data     io.Reader // decrypted
+	crc      hash.Hash32
+	want     uint32
+	checkCRC bool
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.crc.Write(p[:n])
+	if err == io.EOF {
+		// read any remaining data, to authenticate it
+		if _, err := io.Copy(ioutil.Discard, z.data); err != nil {
+			return n, err
+		}
+		if z.checkCRC && z.crc.Sum32() != z.want {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+// zipCrypto implements the traditional PKWARE encryption.
+type zipCrypto struct {
+	r          io.Reader
+	k0, k1, k2 uint32
+}
+
+func newZipCryptoReader(r io.Reader, password []byte, check byte) (*zipCrypto, error) {
+	z := &zipCrypto{r: r, k0: 0x12345678, k1: 0x23456789, k2: 0x34567890}
+	for _, b := range password {
+		z.update(b)
+	}
+
+	var header [12]byte
+	if _, err := io.ReadFull(z, header[:]); err != nil {
+		return nil, err
+	}
+	if header[11] != check {
+		return nil, errZipPassword
+	}
+	return z, nil
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.k0 = crc32.IEEETable[byte(z.k0)^b] ^ z.k0>>8
+	z.k1 = (z.k1+z.k0&0xff)*134775813 + 1
+	z.k2 = crc32.IEEETable[byte(z.k2)^byte(z.k1>>24)] ^ z.k2>>8
+}
+
+func (z *zipCrypto) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i, c := range p[:n] {
+		t := z.k2&0xffff | 2
+		p[i] = c ^ byte(t*(t^1)>>8)
+		z.update(p[i])
+	}
+	return n, err
+}
+
+// aesExtra parses the WinZip AES extra field.
+func aesExtra(extra []byte) (version uint16, strength byte, method uint16, err error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+
+		if id == 0x9901 && len(field) >= 7 {
+			version = binary.LittleEndian.Uint16(field)
+			strength = field[4]
+			method = binary.LittleEndian.Uint16(field[5:])
+			return version, strength, method, nil
+		}
+	}
+	return 0, 0, 0, errors.New("missing AES encryption field")
+}
+
+// aesReader implements WinZip AES encryption: AES in CTR mode,
+// with a little-endian counter, authenticated with HMAC-SHA1.
+type aesReader struct {
+	r       io.Reader // the encrypted data
+	code    io.Reader // the authentication code
+	block   cipher.Block
+	mac     hash.Hash
+	counter uint64
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newAESReader(r *io.SectionReader, password []byte, strength byte) (*aesReader, error) {
+	if strength < 1 || strength > 3 {
+		return nil, fmt.Errorf("unsupported AES strength %d", strength)
+	}
+	keyLen := 8 + 8*int(strength)
+	saltLen := keyLen / 2
+
+	// salt, password verifier, data, and authentication code
+	dataLen := r.Size() - int64(saltLen+2+10)
+	if dataLen < 0 {
+		return nil, zip.ErrFormat
+	}
+	header := make([]byte, saltLen+2)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key(password, header[:saltLen], 1000, 2*keyLen+2, sha1.New)
+	if !hmac.Equal(key[2*keyLen:], header[saltLen:]) {
+		return nil, errZipPassword
+	}
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesReader{
+		r:     io.NewSectionReader(r, int64(len(header)), dataLen),
+		code:  io.NewSectionReader(r, int64(len(header))+dataLen, 10),
+		block: block,
+		mac:   hmac.New(sha1.New, key[keyLen:2*keyLen]),
+		used:  aes.BlockSize,
+	}, nil
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	a.mac.Write(p[:n])
+	for i := range p[:n] {
+		if a.used == len(a.stream) {
+			a.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], a.counter)
+			a.block.Encrypt(a.stream[:], ctr[:])
+			a.used = 0
+		}
+		p[i] ^= a.stream[a.used]
+		a.used++
+	}
+	if err == io.EOF {
+		var code [10]byte
+		if _, err := io.ReadFull(a.code, code[:]); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(code[:], a.mac.Sum(nil)[:10]) {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+package fetch
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// rawZip writes a zip with a single stored entry, as is.
+func rawZip(name string, flags, method uint16, crc uint32, size int, extra, data []byte) []byte {
+	var buf bytes.Buffer
+	w := func(v ...interface{}) {
+		for _, v := range v {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+
+	w(uint32(0x04034b50), uint16(20), flags, method, uint16(0), uint16(0),
+		crc, uint32(len(data)), uint32(size), uint16(len(name)), uint16(len(extra)))
+	buf.WriteString(name)
+	buf.Write(extra)
+	buf.Write(data)
+
+	dir := buf.Len()
+	w(uint32(0x02014b50), uint16(20), uint16(20), flags, method, uint16(0), uint16(0),
+		crc, uint32(len(data)), uint32(size), uint16(len(name)), uint16(len(extra)),
+		uint16(0), uint16(0), uint16(0), uint32(0), uint32(0))
+	buf.WriteString(name)
+	buf.Write(extra)
+
+	w(uint32(0x06054b50), uint16(0), uint16(0), uint16(1), uint16(1),
+		uint32(buf.Len()-dir), uint32(dir), uint16(0))
+	return buf.Bytes()
+}
+
+func zipCryptoEncrypt(password, header, data []byte) []byte {
+	z := &zipCrypto{k0: 0x12345678, k1: 0x23456789, k2: 0x34567890}
+	for _, b := range password {
+		z.update(b)
+	}
+	var out []byte
+	for _, b := range append(header, data...) {
+		t := z.k2&0xffff | 2
+		out = append(out, b^byte(t*(t^1)>>8))
+		z.update(b)
+	}
+	return out
+}
+
+func aesEncrypt(password, salt, data []byte) []byte {
+	const keyLen = 32
+	key := pbkdf2.Key(password, salt, 1000, 2*keyLen+2, sha1.New)
+	block, _ := aes.NewCipher(key[:keyLen])
+
+	out := append(append([]byte{}, salt...), key[2*keyLen:]...)
+	enc := make([]byte, len(data))
+	var ctr, stream [aes.BlockSize]byte
+	for i := range data {
+		if i%aes.BlockSize == 0 {
+			binary.LittleEndian.PutUint64(ctr[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(stream[:], ctr[:])
+		}
+		enc[i] = data[i] ^ stream[i%aes.BlockSize]
+	}
+	mac := hmac.New(sha1.New, key[keyLen:2*keyLen])
+	mac.Write(enc)
+	return append(append(out, enc...), mac.Sum(nil)[:10]...)
+}
+
+func TestUnarchive_zipPassword(t *testing.T) {
+	data := []byte("a secret, longer than one AES block")
+	crc := crc32.ChecksumIEEE(data)
+
+	zipCrypto := rawZip("file", 0x1, zip.Store, crc, len(data), nil,
+		zipCryptoEncrypt([]byte("right"), []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, byte(crc >> 24)}, data))
+
+	aesExtra := []byte{0x01, 0x99, 7, 0, 2, 0, 'A', 'E', 3, 0, 0}
+	aesData := aesEncrypt([]byte("right"), bytes.Repeat([]byte{7}, 16), data)
+	aesZip := rawZip("file", 0x1, 99, 0, len(data), aesExtra, aesData)
+
+	tampered := append([]byte{}, aesData...)
+	tampered[20] ^= 1
+
+	tests := []struct {
+		name     string
+		zip      []byte
+		password string
+		ok       bool
+	}{
+		{name: "zipcrypto", zip: zipCrypto, password: "right", ok: true},
+		{name: "zipcrypto wrong", zip: zipCrypto, password: "wrong"},
+		{name: "zipcrypto none", zip: zipCrypto},
+		{name: "aes", zip: aesZip, password: "right", ok: true},
+		{name: "aes wrong", zip: aesZip, password: "wrong"},
+		{name: "aes tampered", zip: rawZip("file", 0x1, 99, 0, len(data), aesExtra, tampered), password: "right"},
+		{name: "plain", zip: rawZip("file", 0, zip.Store, crc, len(data), nil, data), password: "unused", ok: true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		f := New(WithUnpack(UnpackAuto), WithZipPassword(tt.password))
+		err := f.Save(context.Background(), bytes.NewReader(tt.zip), &Target{Path: dir})
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if tt.ok {
+			got, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s: got %q, %v", tt.name, got, err)
+			}
+		}
+	}
+}
+
+func TestUnarchive_zipSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	// local headers don't record symlinks, the central directory does
+	symlinkZip := func(entries ...tarEntry) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			h := &zip.FileHeader{Name: e.name}
+			data := "ok"
+			if e.link != "" {
+				h.SetMode(os.ModeSymlink | 0777)
+				data = e.link
+			}
+			w, _ := zw.CreateHeader(h)
+			w.Write([]byte(data))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		link    string // extracted as a symlink
+		wantErr bool
+	}{
+		{"relative", []tarEntry{{name: "usr/lib/file"}, {name: "lib", link: "usr/lib"}}, "lib", false},
+		{"parent", []tarEntry{{name: "up", link: "../secret"}}, "", true},
+		{"chained", []tarEntry{{name: "d/up", link: ".."}, {name: "out", link: "d/up/../secret"}}, "", true},
+		{"missing, then linked", []tarEntry{{name: "d/file"},
+			{name: "d/A", link: "sub/../../secret"}, {name: "d/sub", link: "."}}, "", true},
+		{"write through", []tarEntry{{name: "d/e/file"}, {name: "link", link: "d"}, {name: "link/e/file"}}, "", true},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		data := symlinkZip(tt.entries...)
+		// spooled, with a password, or read at random
+		for _, ranged := range []bool{false, true} {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "target")
+			ioutil.WriteFile(filepath.Join(parent, "secret"), nil, 0666)
+
+			var err error
+			if ranged {
+				err = New().SaveZip(ctx, bytes.NewReader(data), int64(len(data)), &Target{Path: dir})
+			} else {
+				f := New(WithUnpack(UnpackAuto), WithZipPassword("unused"))
+				err = f.Save(ctx, bytes.NewReader(data), &Target{Path: dir})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if tt.link != "" {
+				if fi, err := os.Lstat(filepath.Join(dir, tt.link)); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+					t.Errorf("%s: %s isn't a symlink, %v", tt.name, tt.link, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package fetch
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// zipName decodes the name of a zip entry. Names are UTF-8 if flagged
+// so, or if given in an Info-ZIP Unicode Path field. Otherwise, they're
+// in charset, if not nil, or else in CP437, unless valid UTF-8.
+func zipName(h *zip.FileHeader, charset encoding.Encoding) string {
+	if h.Flags&0x800 != 0 {
+		return h.Name
+	}
+	if name, ok := unicodePath(h); ok {
+		return name
+	}
+
+	enc := charset
+	if enc == nil {
+		if utf8.ValidString(h.Name) {
+			return h.Name
+		}
+		enc = charmap.CodePage437
+	}
+	name, err := enc.NewDecoder().String(h.Name)
+	if err != nil {
+		return h.Name
+	}
+	return name
+}
+
+// unicodePath finds the UTF-8 name in an Info-ZIP Unicode Path
+// extra field, if it's up to date with the header's name.
+func unicodePath(h *zip.FileHeader) (string, bool) {
+	extra := h.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+
+		if id != 0x7075 || len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(field[1:]) != crc32.ChecksumIEEE([]byte(h.Name)) {
+			continue
+		}
+		if name := string(field[5:]); utf8.ValidString(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,43 @@
+package fetch
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestZipName(t *testing.T) {
+	unicodePath := func(name, utf string) []byte {
+		field := make([]byte, 9, 9+len(utf))
+		binary.LittleEndian.PutUint16(field, 0x7075)
+		binary.LittleEndian.PutUint16(field[2:], uint16(5+len(utf)))
+		field[4] = 1
+		binary.LittleEndian.PutUint32(field[5:], crc32.ChecksumIEEE([]byte(name)))
+		return append(field, utf...)
+	}
+
+	tests := []struct {
+		charset encoding.Encoding
+		h       zip.FileHeader
+		want    string
+	}{
+		{h: zip.FileHeader{Name: "plain.txt"}, want: "plain.txt"},
+		{h: zip.FileHeader{Name: "caf\x82.txt"}, want: "café.txt"},
+		{h: zip.FileHeader{Name: "café.txt"}, want: "café.txt"},
+		{h: zip.FileHeader{Name: "caf\x82.txt", Flags: 0x800}, want: "caf\x82.txt"},
+		{h: zip.FileHeader{Name: "\x82", Extra: unicodePath("\x82", "é")}, want: "é"},
+		{h: zip.FileHeader{Name: "\x82", Extra: unicodePath("stale", "x")}, want: "é"},
+		{charset: simplifiedchinese.GBK, h: zip.FileHeader{Name: "\xd6\xd0\xce\xc4.txt"}, want: "中文.txt"},
+		{charset: charmap.Windows1252, h: zip.FileHeader{Name: "caf\xe9"}, want: "café"},
+	}
+	for _, tt := range tests {
+		if got := zipName(&tt.h, tt.charset); got != tt.want {
+			t.Errorf("zipName(%v, %q) = %q, want %q", tt.charset, tt.h.Name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// fetchGit fetches a repository ref, with go-getter style syntax:
+// git::https://host/repo.git//subdir?ref=v1.0
+//
+// The ref is shallow fetched, and returned as a tar archive.
+func fetchGit(source string) (io.ReadCloser, *meta, error) {
+	if *offline {
+		return nil, nil, errOffline
+	}
+	repo, subdir, ref := parseGit(strings.TrimPrefix(source, "git::"))
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// neither may be taken for an option
+	if strings.HasPrefix(repo, "-") || strings.HasPrefix(ref, "-") {
+		return nil, nil, fmt.Errorf("invalid git source: %q", source)
+	}
+
+	dir, err := ioutil.TempDir("", "go-fetch-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	git := func(args ...string) *exec.Cmd {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Stderr = os.Stderr
+		return cmd
+	}
+	if err := git("init", "-q", "--bare").Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("git init: %w", err)
+	}
+	if err := git("fetch", "-q", "--depth", "1", "--", repo, ref).Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("git fetch: %w", err)
+	}
+
+	tree := "FETCH_HEAD"
+	if subdir != "" {
+		tree += ":" + subdir
+	}
+	cmd := git("archive", "--format=tar", tree)
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("git archive: %w", err)
+	}
+
+	name := strings.TrimSuffix(path.Base(repo), ".git")
+	return &gitArchive{out, cmd, dir}, &meta{name: name, contentType: "application/x-tar"}, nil
+}
+
+// parseGit splits a source into repository, subdirectory and ref.
+func parseGit(source string) (repo, subdir, ref string) {
+	if i := strings.LastIndexByte(source, '?'); i >= 0 {
+		for _, p := range strings.Split(source[i+1:], "&") {
+			if strings.HasPrefix(p, "ref=") {
+				ref = p[len("ref="):]
+			}
+		}
+		source = source[:i]
+	}
+
+	start := 0
+	if i := strings.Index(source, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	if i := strings.Index(source[start:], "//"); i >= 0 {
+		source, subdir = source[:start+i], source[start+i+2:]
+	}
+	return source, strings.Trim(subdir, "/"), ref
+}
+
+// gitArchive is the output of git archive,
+// which cleans up after itself when closed.
+type gitArchive struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+	dir string
+}
+
+func (g *gitArchive) Close() error {
+	io.Copy(ioutil.Discard, g.ReadCloser)
+	err := g.cmd.Wait()
+	os.RemoveAll(g.dir)
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGit(t *testing.T) {
+	tests := []struct {
+		source, repo, subdir, ref string
+	}{
+		{"https://host/repo.git", "https://host/repo.git", "", ""},
+		{"https://host/repo.git?ref=v1.0", "https://host/repo.git", "", "v1.0"},
+		{"https://host/repo.git//sub/dir/?ref=v1.0", "https://host/repo.git", "sub/dir", "v1.0"},
+		{"https://host/repo.git//sub?depth=1&ref=main", "https://host/repo.git", "sub", "main"},
+		{"git@host:org/repo.git//sub", "git@host:org/repo.git", "sub", ""},
+		{"/local/repo//sub", "/local/repo", "sub", ""},
+	}
+	for _, tt := range tests {
+		repo, subdir, ref := parseGit(tt.source)
+		if repo != tt.repo || subdir != tt.subdir || ref != tt.ref {
+			t.Errorf("parseGit(%q) = %q, %q, %q; want %q, %q, %q",
+				tt.source, repo, subdir, ref, tt.repo, tt.subdir, tt.ref)
+		}
+	}
+}
+
+func TestFetchGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+
+	repo := t.TempDir()
+	os.MkdirAll(filepath.Join(repo, "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(repo, "sub", "file.txt"), []byte("v1"), 0666)
+	ioutil.WriteFile(filepath.Join(repo, "top.txt"), []byte("v1"), 0666)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "v1"},
+		{"tag", "v1.0"},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	body, m, err := fetchGit("git::" + repo + "//sub?ref=v1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(body)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			names = append(names, h.Name)
+		}
+	}
+	if err := body.Close(); err != nil {
+		t.Error(err)
+	}
+	if len(names) != 1 || names[0] != "file.txt" || m.name != filepath.Base(repo) {
+		t.Errorf("fetchGit() = %q, %v", m.name, names)
+	}
+
+	if _, _, err := fetchGit("git::--upload-pack=evil"); err == nil {
+		t.Error("fetchGit(option): want error")
+	}
+}
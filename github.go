@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type githubRelease struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+// fetchGitHub downloads a release asset,
+// referenced as github://owner/repo[@tag][/pattern].
+//
+// The pattern is a glob, which can use {os} and {arch} placeholders.
+// If the release has a checksums asset, the download is verified against it.
+func fetchGitHub(source string) (io.ReadCloser, *meta, error) {
+	ref := strings.TrimPrefix(source, "github://")
+	parts := strings.SplitN(ref, "/", 3)
+	if len(parts) < 2 {
+		return nil, nil, fmt.Errorf("malformed GitHub reference %q", source)
+	}
+	owner, repo, pattern := parts[0], parts[1], ""
+	if len(parts) > 2 {
+		pattern = parts[2]
+	}
+	tag := "latest"
+	if i := strings.IndexByte(repo, '@'); i >= 0 {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+
+	api := os.Getenv("GITHUB_API_URL")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	endpoint := api + "/repos/" + owner + "/" + repo + "/releases/"
+	if tag == "latest" {
+		endpoint += "latest"
+	} else {
+		endpoint += "tags/" + tag
+	}
+
+	var rel githubRelease
+	if err := githubGet(endpoint, "application/vnd.github+json", &rel); err != nil {
+		return nil, nil, err
+	}
+
+	asset, err := selectAsset(rel.Assets, pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+	return fetchAsset(rel.Assets, asset, func(url string, payload bool) (*http.Response, error) {
+		return githubDo(url, "application/octet-stream", payload)
+	})
+}
+
+// fetchAsset downloads a release asset,
+// verifying it if the release has a checksums asset.
+func fetchAsset(assets []releaseAsset, asset *releaseAsset, get func(url string, payload bool) (*http.Response, error)) (io.ReadCloser, *meta, error) {
+	digest, err := releaseChecksum(assets, asset.Name, get)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := get(asset.URL, true)
+	var p *plannedRequest
+	if errors.As(err, &p) {
+		p.name = asset.Name
+		if digest != "" {
+			p.digest = "sha256:" + digest
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var body io.ReadCloser = res.Body
+	if digest != "" {
+		body, err = gofetch.NewVerifier(body, "sha256:"+digest)
+		if err != nil {
+			res.Body.Close()
+			return nil, nil, err
+		}
+	}
+	return body, responseMeta(res, asset.Name), nil
+}
+
+// selectAsset finds the one asset that matches pattern.
+func selectAsset(assets []releaseAsset, pattern string) (*releaseAsset, error) {
+	var found []*releaseAsset
+	for i, a := range assets {
+		if pattern == "" {
+			found = append(found, &assets[i])
+		} else if ok, err := path.Match(pattern, a.Name); err != nil {
+			return nil, err
+		} else if ok {
+			found = append(found, &assets[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no asset matches %q", pattern)
+	case 1:
+		return found[0], nil
+	default:
+		var names []string
+		for _, a := range found {
+			names = append(names, a.Name)
+		}
+		return nil, fmt.Errorf("%d assets match %q: %s", len(found), pattern, strings.Join(names, ", "))
+	}
+}
+
+// releaseChecksum looks for the SHA-256 of an asset
+// in the checksums assets of the release.
+func releaseChecksum(assets []releaseAsset, name string, get func(url string, payload bool) (*http.Response, error)) (string, error) {
+	for _, a := range assets {
+		lower := strings.ToLower(a.Name)
+		if lower != strings.ToLower(name)+".sha256" &&
+			!strings.Contains(lower, "checksums") &&
+			!strings.Contains(lower, "sha256sums") {
+			continue
+		}
+
+		res, err := get(a.URL, false)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		scan := bufio.NewScanner(res.Body)
+		for scan.Scan() {
+			fields := strings.Fields(scan.Text())
+			switch {
+			case len(fields) == 1 && !strings.Contains(lower, "sums"):
+				return fields[0], nil
+			case len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name:
+				return fields[0], nil
+			}
+		}
+		if err := scan.Err(); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func githubGet(url, accept string, v interface{}) error {
+	res, err := githubDo(url, accept, false)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func githubDo(url, accept string, payload bool) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := send(req, payload)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.New("github error: " + res.Status)
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestFetchGitHub(t *testing.T) {
+	asset := "tool_" + runtime.GOOS + "_" + runtime.GOARCH + ".tar.gz"
+	content := []byte("release asset")
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release := func(tag string, sums string) {
+			json.NewEncoder(w).Encode(githubRelease{TagName: tag, Assets: []releaseAsset{
+				{Name: asset, URL: srv.URL + "/assets/" + asset},
+				{Name: "tool_other_arch.tar.gz", URL: srv.URL + "/assets/other"},
+				{Name: "checksums.txt", URL: srv.URL + "/assets/" + sums},
+			}})
+		}
+		switch r.URL.Path {
+		case "/repos/org/tool/releases/latest":
+			release("v2.0", "checksums.txt")
+		case "/repos/org/tool/releases/tags/v1.0":
+			release("v1.0", "bad-checksums.txt")
+		case "/assets/" + asset:
+			if r.Header.Get("Accept") != "application/octet-stream" {
+				http.Error(w, "bad accept", http.StatusBadRequest)
+				return
+			}
+			w.Write(content)
+		case "/assets/checksums.txt":
+			fmt.Fprintf(w, "%x  %s\n", sha256.Sum256(content), asset)
+		case "/assets/bad-checksums.txt":
+			fmt.Fprintf(w, "%x *%s\n", sha256.Sum256(nil), asset)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer os.Setenv("GITHUB_API_URL", os.Getenv("GITHUB_API_URL"))
+	os.Setenv("GITHUB_API_URL", srv.URL)
+
+	tests := []struct {
+		source  string
+		wantErr bool
+	}{
+		{source: "github://org/tool/tool_{os}_{arch}.tar.gz"},
+		{source: "github://org/tool@v1.0/tool_{os}_{arch}.tar.gz", wantErr: true},
+		{source: "github://org/tool/*.tar.gz", wantErr: true},
+		{source: "github://org/tool/*.zip", wantErr: true},
+		{source: "github://org/missing/*", wantErr: true},
+		{source: "github://org", wantErr: true},
+	}
+	for _, tt := range tests {
+		body, m, err := fetchGitHub(expand(tt.source))
+		if err == nil {
+			var got []byte
+			got, err = ioutil.ReadAll(body)
+			body.Close()
+			if err == nil && (string(got) != string(content) || m.name != asset) {
+				t.Errorf("fetchGitHub(%q) = %q, %q", tt.source, got, m.name)
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("fetchGitHub(%q) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+		}
+	}
+}
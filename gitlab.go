@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type gitlabRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// gitlabURL is the GitLab instance to use:
+// GITLAB_URL, the instance running a CI job, or gitlab.com.
+func gitlabURL() string {
+	if u := os.Getenv("GITLAB_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	if u := os.Getenv("CI_SERVER_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "https://gitlab.com"
+}
+
+// fetchGitLab downloads a release asset,
+// referenced as gitlab://group/project@tag[/pattern].
+//
+// The pattern is a glob, which can use {os} and {arch} placeholders.
+// If the release has a checksums asset, the download is verified against it.
+func fetchGitLab(source string) (io.ReadCloser, *meta, error) {
+	ref := strings.TrimPrefix(source, "gitlab://")
+	i := strings.IndexByte(ref, '@')
+	if i <= 0 {
+		return nil, nil, fmt.Errorf("malformed GitLab reference %q", source)
+	}
+	project, tag, pattern := ref[:i], ref[i+1:], ""
+	if i := strings.IndexByte(tag, '/'); i >= 0 {
+		tag, pattern = tag[:i], tag[i+1:]
+	}
+
+	endpoint := gitlabURL() + "/api/v4/projects/" + url.PathEscape(project) + "/releases/"
+	if tag == "latest" {
+		endpoint += "permalink/latest"
+	} else {
+		endpoint += url.PathEscape(tag)
+	}
+
+	res, err := gitlabDo(endpoint, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rel gitlabRelease
+	err = json.NewDecoder(res.Body).Decode(&rel)
+	res.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var assets []releaseAsset
+	for _, l := range rel.Assets.Links {
+		u := l.DirectAssetURL
+		if u == "" {
+			u = l.URL
+		}
+		assets = append(assets, releaseAsset{Name: l.Name, URL: u})
+	}
+
+	asset, err := selectAsset(assets, pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+	return fetchAsset(assets, asset, gitlabDo)
+}
+
+// fetchGitLabPackage downloads a file from the generic package registry,
+// referenced as gitlab+package://group/project@package/version/file.
+func fetchGitLabPackage(source string) (io.ReadCloser, *meta, error) {
+	ref := strings.TrimPrefix(source, "gitlab+package://")
+	i := strings.IndexByte(ref, '@')
+	parts := strings.Split(ref[i+1:], "/")
+	if i <= 0 || len(parts) != 3 {
+		return nil, nil, fmt.Errorf("malformed GitLab package reference %q", source)
+	}
+
+	endpoint := gitlabURL() + "/api/v4/projects/" + url.PathEscape(ref[:i]) + "/packages/generic/" +
+		url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1]) + "/" + url.PathEscape(parts[2])
+
+	res, err := gitlabDo(endpoint, true)
+	var p *plannedRequest
+	if errors.As(err, &p) {
+		p.name = parts[2]
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, responseMeta(res, parts[2]), nil
+}
+
+// gitlabDo gets a URL, authenticating with GITLAB_TOKEN or CI_JOB_TOKEN,
+// but only to the GitLab instance, as release links may point elsewhere.
+func gitlabDo(u string, payload bool) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u, gitlabURL()+"/") {
+		if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		} else if token := os.Getenv("CI_JOB_TOKEN"); token != "" {
+			req.Header.Set("JOB-TOKEN", token)
+		}
+	}
+
+	res, err := send(req, payload)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.New("gitlab error: " + res.Status)
+	}
+	return res, nil
+}
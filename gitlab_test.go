@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFetchGitLab(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/group%2Fproject/releases/v1.0",
+			"/api/v4/projects/group%2Fproject/releases/permalink/latest":
+			var rel gitlabRelease
+			rel.TagName = "v1.0"
+			rel.Assets.Links = append(rel.Assets.Links, struct {
+				Name           string `json:"name"`
+				URL            string `json:"url"`
+				DirectAssetURL string `json:"direct_asset_url"`
+			}{Name: "tool.tar.gz", URL: srv.URL + "/link", DirectAssetURL: srv.URL + "/direct"})
+			json.NewEncoder(w).Encode(rel)
+		case "/direct":
+			// links to the instance are authenticated
+			if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+				http.Error(w, "no token", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("asset"))
+		case "/api/v4/projects/group%2Fproject/packages/generic/tool/1.0/tool.zip":
+			w.Write([]byte("package"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer os.Setenv("GITLAB_URL", os.Getenv("GITLAB_URL"))
+	defer os.Setenv("GITLAB_TOKEN", os.Getenv("GITLAB_TOKEN"))
+	os.Setenv("GITLAB_URL", srv.URL+"/")
+	os.Setenv("GITLAB_TOKEN", "secret")
+
+	tests := []struct {
+		source  string
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{source: "gitlab://group/project@v1.0/*.tar.gz", name: "tool.tar.gz", want: "asset"},
+		{source: "gitlab://group/project@latest", name: "tool.tar.gz", want: "asset"},
+		{source: "gitlab://group/project@v1.0/*.zip", wantErr: true},
+		{source: "gitlab://group/project@v2.0", wantErr: true},
+		{source: "gitlab://group/project", wantErr: true},
+		{source: "gitlab+package://group/project@tool/1.0/tool.zip", name: "tool.zip", want: "package"},
+		{source: "gitlab+package://group/project@tool/1.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		var fetch = fetchGitLab
+		if strings.HasPrefix(tt.source, "gitlab+package:") {
+			fetch = fetchGitLabPackage
+		}
+		body, m, err := fetch(tt.source)
+		if err == nil {
+			var got []byte
+			got, err = ioutil.ReadAll(body)
+			body.Close()
+			if err == nil && (string(got) != tt.want || m.name != tt.name) {
+				t.Errorf("fetch(%q) = %q, %q", tt.source, got, m.name)
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("fetch(%q) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+		}
+	}
+}
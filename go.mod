@@ -2,4 +2,10 @@ module github.com/ncruces/go-fetch
 
 go 1.15
 
-require github.com/krolaw/zipstream v0.0.0-20180621105154-0a2661891f94
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/krolaw/zipstream v0.0.0-20180621105154-0a2661891f94
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/text v0.3.3
+)
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// headEntry describes a source, printed by -head.
+type headEntry struct {
+	Source        string `json:"source"`
+	Status        int    `json:"status"`
+	URL           string `json:"url"` // after redirects
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type,omitempty"`
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"last_modified,omitempty"`
+}
+
+// headResponse is returned by send instead of downloading,
+// with -head.
+type headResponse struct {
+	res *http.Response
+}
+
+func (h *headResponse) Error() string {
+	return "head response: " + h.res.Status
+}
+
+// sendHead makes a HEAD request, rather than fetching the payload.
+func sendHead(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Method = http.MethodHead
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return nil, &headResponse{res: res}
+}
+
+// head resolves the job, and prints the response headers of the
+// request that would download it, without writing anything.
+func (j *job) head() error {
+	// repositories are cloned, not requested
+	if strings.HasPrefix(j.source, "git::") {
+		return fmt.Errorf("-head: %s isn't fetched over http", j.source)
+	}
+	body, _, err := fetch(j.source, nil)
+	if err == nil {
+		body.Close()
+		return fmt.Errorf("-head: %s isn't fetched over http", j.source)
+	}
+	var h *headResponse
+	if !errors.As(err, &h) {
+		return err
+	}
+
+	res := h.res
+	e := headEntry{
+		Source:        j.source,
+		Status:        res.StatusCode,
+		URL:           res.Request.URL.Redacted(),
+		ContentLength: res.ContentLength,
+		ContentType:   res.Header.Get("Content-Type"),
+		ETag:          res.Header.Get("ETag"),
+		LastModified:  res.Header.Get("Last-Modified"),
+	}
+
+	var buf []byte
+	if *asJSON {
+		if buf, err = json.Marshal(e); err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+	} else {
+		// like curl -I, but only what's useful to scripts
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s\n", res.Status)
+		fmt.Fprintf(&b, "URL: %s\n", e.URL)
+		if e.ContentLength >= 0 {
+			fmt.Fprintf(&b, "Content-Length: %d\n", e.ContentLength)
+		}
+		for _, k := range []string{"Content-Type", "ETag", "Last-Modified"} {
+			if v := res.Header.Get(k); v != "" {
+				fmt.Fprintf(&b, "%s: %s\n", k, v)
+			}
+		}
+		buf = []byte(b.String())
+	}
+
+	planMutex.Lock()
+	_, err = os.Stdout.Write(buf)
+	planMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		return errors.New("http error: " + res.Status)
+	}
+	return nil
+}
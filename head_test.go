@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestJob_head(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		switch r.URL.Path {
+		case "/latest":
+			http.Redirect(w, r, "/tool.tar.gz", http.StatusFound)
+		case "/tool.tar.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", "1234")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(old, j bool) { *headOnly, *asJSON = old, j }(*headOnly, *asJSON)
+	*headOnly = true
+
+	var err error
+	out := captureStdout(t, func() { err = newJob(srv.URL+"/latest", "").run() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "200 OK\n" +
+		"URL: " + srv.URL + "/tool.tar.gz\n" +
+		"Content-Length: 1234\n" +
+		"Content-Type: application/gzip\n" +
+		"ETag: \"v1\"\n"
+	if out != want {
+		t.Errorf("-head printed %q, want %q", out, want)
+	}
+	for _, m := range methods {
+		if m != http.MethodHead {
+			t.Errorf("-head sent a %s request", m)
+		}
+	}
+
+	*asJSON = true
+	out = captureStdout(t, func() { err = newJob(srv.URL+"/tool.tar.gz", "").run() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got headEntry
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	wantEntry := headEntry{
+		Source:        srv.URL + "/tool.tar.gz",
+		Status:        200,
+		URL:           srv.URL + "/tool.tar.gz",
+		ContentLength: 1234,
+		ContentType:   "application/gzip",
+		ETag:          `"v1"`,
+	}
+	if !reflect.DeepEqual(got, wantEntry) {
+		t.Errorf("-head -json printed %+v, want %+v", got, wantEntry)
+	}
+
+	captureStdout(t, func() { err = newJob(srv.URL+"/missing", "").run() })
+	if err == nil {
+		t.Error("-head of a missing file: want error")
+	}
+	if err := newJob("data:,hello", "").run(); err == nil {
+		t.Error("-head of data: want error")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// preconnects dials origins hinted by 103 Early Hints (RFC 8297),
+// so that a redirect to them (typically, to a CDN) finds a connection ready.
+// It's nil with -proxy.
+var preconnects *preconnector
+
+type preconnector struct {
+	dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+	proxy func(*http.Request) (*url.URL, error)
+	mu    sync.Mutex
+	conns map[string]*time.Timer // pending dials have no timer
+	ready map[string]net.Conn
+}
+
+// preconnectIdle is how long an unused preconnection is kept open.
+const preconnectIdle = 10 * time.Second
+
+func newPreconnector(t *http.Transport) *preconnector {
+	return &preconnector{
+		dial:  t.DialContext,
+		proxy: t.Proxy,
+		conns: make(map[string]*time.Timer),
+		ready: make(map[string]net.Conn),
+	}
+}
+
+// DialContext uses a preconnection to addr, if there is one.
+func (p *preconnector) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network == "tcp" {
+		p.mu.Lock()
+		c := p.ready[addr]
+		if c != nil {
+			p.conns[addr].Stop()
+			delete(p.conns, addr)
+			delete(p.ready, addr)
+		}
+		p.mu.Unlock()
+		if c != nil {
+			return c, nil
+		}
+	}
+	return p.dial(ctx, network, addr)
+}
+
+// preconnect dials the origin of u in the background, once,
+// unless connections to it go through a proxy.
+func (p *preconnector) preconnect(u *url.URL) {
+	if p.proxy != nil {
+		if proxy, err := p.proxy(&http.Request{URL: u}); proxy != nil || err != nil {
+			return
+		}
+	}
+
+	port := u.Port()
+	switch {
+	case port != "":
+	case u.Scheme == "https":
+		port = "443"
+	case u.Scheme == "http":
+		port = "80"
+	default:
+		return
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.conns[addr]; ok || len(p.conns) >= 4 {
+		return
+	}
+	p.conns[addr] = nil
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), preconnectIdle)
+		c, err := p.dial(ctx, "tcp", addr)
+		cancel()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err != nil {
+			delete(p.conns, addr)
+			return
+		}
+		p.ready[addr] = c
+		p.conns[addr] = time.AfterFunc(preconnectIdle, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.ready[addr] == c {
+				delete(p.conns, addr)
+				delete(p.ready, addr)
+				c.Close()
+			}
+		})
+	}()
+}
+
+// withEarlyHints acts on the Early Hints the server sends for req:
+// it preconnects to the origins of rel=preconnect links.
+func withEarlyHints(req *http.Request) *http.Request {
+	if preconnects == nil {
+		return req
+	}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				for _, u := range preconnectLinks(req.URL, header["Link"]) {
+					preconnects.preconnect(u)
+				}
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// preconnectLinks finds the targets of rel=preconnect links,
+// e.g. Link: <https://cdn.example.com>; rel=preconnect.
+func preconnectLinks(base *url.URL, fields []string) []*url.URL {
+	var urls []*url.URL
+	for _, field := range fields {
+		for _, link := range strings.Split(field, ",") {
+			params := strings.Split(link, ";")
+			target := strings.TrimSpace(params[0])
+			if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+				continue
+			}
+
+			preconnect := false
+			for _, p := range params[1:] {
+				i := strings.IndexByte(p, '=')
+				if i < 0 || !strings.EqualFold(strings.TrimSpace(p[:i]), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(p[i+1:]), `"`)) {
+					preconnect = preconnect || strings.EqualFold(rel, "preconnect")
+				}
+			}
+			if !preconnect {
+				continue
+			}
+
+			u, err := base.Parse(target[1 : len(target)-1])
+			if err == nil && u.Host != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
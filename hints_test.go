@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPreconnectLinks(t *testing.T) {
+	base, _ := url.Parse("https://host/dir/file")
+	tests := []struct {
+		fields []string
+		want   []string
+	}{
+		{[]string{"<https://cdn.example.com>; rel=preconnect"}, []string{"https://cdn.example.com"}},
+		{[]string{`<//cdn.example.com:8443>; rel="dns-prefetch Preconnect"`}, []string{"https://cdn.example.com:8443"}},
+		{[]string{"</style.css>; rel=preload; as=style, <http://other>; rel=preconnect"}, []string{"http://other"}},
+		{[]string{"<https://a>; rel=preconnect", "<https://b>; rel=preconnect"}, []string{"https://a", "https://b"}},
+		{[]string{"</relative>; rel=preconnect"}, []string{"https://host/relative"}},
+		{[]string{"https://cdn.example.com; rel=preconnect"}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, u := range preconnectLinks(base, tt.fields) {
+			got = append(got, u.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("preconnectLinks(%q) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestFetch_earlyHints(t *testing.T) {
+	var conns int32
+	dialed := make(chan struct{}, 1)
+	cdn := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	cdn.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+			dialed <- struct{}{}
+		}
+	}
+	cdn.Start()
+	defer cdn.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+cdn.URL+">; rel=preconnect")
+		w.WriteHeader(http.StatusEarlyHints)
+		select {
+		case <-dialed:
+		case <-time.After(5 * time.Second):
+			http.Error(w, "no preconnection", http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, cdn.URL+"/file", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	defer func(old *http.Client) { client = old }(client)
+	configureClient()
+
+	body, _, err := fetch(srv.URL+"/file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("got %d connections to the hinted origin, want 1", n)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type historyEntry struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	Target string    `json:"target"`
+
+	// validators, for conditional requests
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func historyFile(name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-fetch", "history"), nil
+}
+
+// recordHistory appends an entry to the history file,
+// one JSON object per line.
+func recordHistory(e historyEntry) error {
+	if *history == "off" {
+		return nil
+	}
+	name, err := historyFile(*history)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lastDownload finds the latest download of url to target,
+// or into target, if it's a directory, using the history file.
+func lastDownload(url, target string, intoDir bool) *historyEntry {
+	if *history == "off" {
+		return nil
+	}
+	name, err := historyFile(*history)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var last *historyEntry
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		var e historyEntry
+		if json.Unmarshal(scan.Bytes(), &e) != nil || e.URL != url {
+			continue
+		}
+		if e.Target == target || intoDir && filepath.Dir(e.Target) == target {
+			last = &e
+		}
+	}
+	return last
+}
+
+func historyMain(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	file := flags.String("file", "", "history `file`")
+	url := flags.String("url", "", "only entries whose URL contains `text`")
+	dest := flags.String("target", "", "only entries whose target contains `text`")
+	digest := flags.String("sha256", "", "only entries whose digest starts with `hex`")
+	since := flags.String("since", "", "only entries newer than a `duration` or RFC 3339 time")
+	asJSON := flags.Bool("json", false, "print entries as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "go-fetch history [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	var after time.Time
+	if *since != "" {
+		if d, err := time.ParseDuration(*since); err == nil {
+			after = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, *since); err == nil {
+			after = t
+		} else {
+			log.Fatalf("invalid -since value: %q", *since)
+		}
+	}
+
+	name, err := historyFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scan.Bytes(), &e); err != nil {
+			continue // skip damaged lines
+		}
+
+		if !strings.Contains(e.URL, *url) ||
+			!strings.Contains(e.Target, *dest) ||
+			!strings.HasPrefix(e.SHA256, strings.ToLower(*digest)) ||
+			e.Time.Before(after) {
+			continue
+		}
+
+		if *asJSON {
+			out.Write(scan.Bytes())
+			out.WriteByte('\n')
+		} else {
+			fmt.Fprintf(out, "%s  %s  %d  %s -> %s\n",
+				e.Time.Local().Format(time.RFC3339), e.SHA256, e.Size, e.URL, e.Target)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
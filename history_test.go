@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dir", "history")
+	defer func(old string) { *history = old }(*history)
+	*history = name
+
+	entries := []historyEntry{
+		{Time: time.Now().Add(-48 * time.Hour), URL: "https://host/old.tar.gz", SHA256: "aa01", Size: 1, Target: "old"},
+		{Time: time.Now(), URL: "https://host/new.tar.gz", SHA256: "bb02", Size: 2, Target: "new"},
+	}
+	for _, e := range entries {
+		if err := recordHistory(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"old.tar.gz", "new.tar.gz"}},
+		{[]string{"-url", "new"}, []string{"new.tar.gz"}},
+		{[]string{"-target", "old"}, []string{"old.tar.gz"}},
+		{[]string{"-sha256", "BB"}, []string{"new.tar.gz"}},
+		{[]string{"-since", "24h"}, []string{"new.tar.gz"}},
+		{[]string{"-url", "none"}, nil},
+	}
+	for _, tt := range tests {
+		out := captureStdout(t, func() {
+			historyMain(append([]string{"-file", name}, tt.args...))
+		})
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if out == "" {
+			lines = nil
+		}
+		if len(lines) != len(tt.want) {
+			t.Errorf("history %v = %q, want %v", tt.args, out, tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(lines[i], want) {
+				t.Errorf("history %v = %q, want %v", tt.args, out, tt.want)
+			}
+		}
+	}
+}
+
+func TestHistory_off(t *testing.T) {
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+	if err := recordHistory(historyEntry{URL: "https://host/file"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
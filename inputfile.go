@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// readInputFile reads a list of downloads, a line each:
+// a url, then optionally a target and a digest (algorithm:hex,
+// or a SHA-256 in hex), separated by spaces.
+// Blank lines, and lines starting with #, are ignored.
+// Downloads without a target are saved to the default target.
+func readInputFile(name, target string) ([]*job, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var doc artifactsDoc
+	scan := bufio.NewScanner(r)
+	for n := 1; scan.Scan(); n++ {
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		a := artifact{URL: fields[0]}
+		for _, f := range fields[1:] {
+			switch {
+			case a.Digest == "" && isDigest(f):
+				a.Digest = f
+			case a.Target == "":
+				a.Target = f
+			default:
+				return nil, fmt.Errorf("%s:%d: unexpected %q", name, n, f)
+			}
+		}
+		if a.Target == "" {
+			a.Target = target
+		}
+		if a.Target == "" && !*list {
+			return nil, fmt.Errorf("%s:%d: no target, and no default target", name, n)
+		}
+		doc.Artifacts = append(doc.Artifacts, a)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return artifactJobs(&doc)
+}
+
+// isDigest reports whether s looks like a digest, rather than a target.
+func isDigest(s string) bool {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return gofetch.NewHash(strings.ToLower(s[:i])) != nil
+	}
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInputFile(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		list    string
+		target  string
+		want    []job
+		wantErr bool
+	}{
+		{
+			name: "targets",
+			list: "# comment\n\nhttps://host/a a\nhttps://host/b  b  sha512:cd\n",
+			want: []job{
+				{source: "https://host/a", target: "a"},
+				{source: "https://host/b", target: "b", digest: "sha512:cd"},
+			},
+		},
+		{
+			name:   "default target",
+			list:   "https://host/c " + sum + "\nhttps://host/d d/\n",
+			target: "out/",
+			want: []job{
+				{source: "https://host/c", target: "out/", digest: "sha256:" + sum},
+				{source: "https://host/d", target: "d/"},
+			},
+		},
+		{name: "no target", list: "https://host/a\n", wantErr: true},
+		{name: "extra field", list: "https://host/a a b\n", wantErr: true},
+		{name: "unsupported digest", list: "https://host/a a md5:ab\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "list.txt")
+		ioutil.WriteFile(name, []byte(tt.list), 0666)
+
+		jobs, err := readInputFile(name, tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readInputFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if len(jobs) != len(tt.want) {
+			t.Errorf("%s: readInputFile() = %d jobs, want %d", tt.name, len(jobs), len(tt.want))
+			continue
+		}
+		for i, j := range jobs {
+			w := tt.want[i]
+			if j.source != w.source || j.target != w.target || j.digest != w.digest {
+				t.Errorf("%s: job %d = %+v, want %+v", tt.name, i, *j, w)
+			}
+		}
+	}
+}
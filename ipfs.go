@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// IPFS content is requested from a trustless gateway as a CAR,
+// and verified block by block, from the CID in the url:
+// the gateway doesn't need to be trusted, so it can be a public one,
+// or the gateway of a local node.
+
+// defaultIPFSGateway only serves verifiable responses.
+const defaultIPFSGateway = "https://trustless-gateway.link"
+
+// maxIPFSBlock is the largest block that is accepted;
+// IPFS limits blocks to 2 MiB.
+const maxIPFSBlock = 4 << 20
+
+// maxIPFSStash is the most block data that is kept,
+// for blocks sent before they're needed, or needed again.
+const maxIPFSStash = 64 << 20
+
+// Multicodecs of the supported CIDs, and multihashes.
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	hashIdent   = 0x00
+	hashSHA256  = 0x12
+	hashSHA512  = 0x13
+	unixfsRaw   = 0
+	unixfsDir   = 1
+	unixfsFile  = 2
+	unixfsShard = 5
+)
+
+// cid is a content identifier: the codec of a block, and its multihash.
+type cid struct {
+	codec uint64
+	hash  []byte // multihash
+}
+
+// fetchIPFS downloads the file at ipfs://CID[/path],
+// verifying every block from the CID down.
+func fetchIPFS(source string) (io.ReadCloser, *meta, error) {
+	loc := strings.TrimPrefix(source, "ipfs://")
+	if i := strings.IndexAny(loc, "?#"); i >= 0 {
+		loc = loc[:i]
+	}
+	var names []string
+	for _, s := range strings.Split(loc, "/")[1:] {
+		if s == "" {
+			continue
+		}
+		s, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ipfs: %w", err)
+		}
+		names = append(names, s)
+	}
+	root := strings.SplitN(loc, "/", 2)[0]
+	c, err := parseCID(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ipfs: invalid CID %q: %w", root, err)
+	}
+
+	name := root
+	if len(names) > 0 {
+		name = gofetch.SanitizeName(names[len(names)-1])
+	}
+
+	u := strings.TrimSuffix(ipfsGatewayURL(), "/") + "/ipfs/" + root
+	for _, n := range names {
+		u += "/" + url.PathEscape(n)
+	}
+	req, err := http.NewRequest(http.MethodGet, u+"?format=car&dag-scope=entity", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car; version=1; order=dfs; dups=y")
+
+	res, err := send(req, true)
+	var p *plannedRequest
+	if errors.As(err, &p) {
+		p.name = name
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, nil, errors.New("http error: " + res.Status)
+	}
+
+	r := &ipfsReader{car: bufio.NewReader(res.Body), body: res.Body, stash: map[string][]byte{}, refs: map[string]int{}}
+	// without duplicates, blocks needed again must be kept
+	r.keep = !strings.Contains(res.Header.Get("Content-Type"), "dups=y")
+	r.link(c)
+	if err := r.readHeader(); err != nil {
+		res.Body.Close()
+		return nil, nil, fmt.Errorf("ipfs %s: %w", root, err)
+	}
+	if c, err = r.resolve(c, names); err != nil {
+		res.Body.Close()
+		return nil, nil, fmt.Errorf("ipfs %s: %w", loc, err)
+	}
+	r.stack = []cid{c}
+	// the CAR doesn't describe the file, so its type is sniffed
+	return r, &meta{name: name}, nil
+}
+
+// ipfsGatewayURL is the configured gateway,
+// looked up like curl does, after -ipfs-gateway and the config file.
+func ipfsGatewayURL() string {
+	if *ipfsGateway != "" {
+		return *ipfsGateway
+	}
+	if conf.IPFSGateway != "" {
+		return conf.IPFSGateway
+	}
+	if gw := os.Getenv("IPFS_GATEWAY"); gw != "" {
+		return gw
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		buf, _ := ioutil.ReadFile(filepath.Join(home, ".ipfs", "gateway"))
+		if gw := strings.TrimSpace(string(buf)); gw != "" {
+			return strings.SplitN(gw, "\n", 2)[0]
+		}
+	}
+	return defaultIPFSGateway
+}
+
+// ipfsReader reads a UnixFS file from a CAR, depth first,
+// verifying each block it reads against the CID that links to it.
+type ipfsReader struct {
+	car     *bufio.Reader
+	body    io.Closer
+	stash   map[string][]byte // blocks read before they were needed
+	size    int               // of the blocks in the stash
+	refs    map[string]int    // links to each block, not yet followed
+	keep    bool              // keep blocks in the stash once used
+	dropped bool              // some used blocks were evicted
+	stack   []cid             // blocks to read, the next one last
+	buf     []byte            // data to return
+}
+
+func (r *ipfsReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.stack) == 0 {
+			return 0, io.EOF
+		}
+		c := r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+
+		blk, err := r.block(c)
+		if err != nil {
+			return 0, err
+		}
+		if c.codec == codecRaw {
+			r.buf = blk
+			continue
+		}
+		node, err := parseDagPB(blk)
+		if err != nil {
+			return 0, err
+		}
+		switch node.typ {
+		case unixfsRaw, unixfsFile:
+		case unixfsDir, unixfsShard:
+			return 0, errors.New("ipfs: path is a directory")
+		default:
+			return 0, fmt.Errorf("ipfs: unsupported UnixFS node type %d", node.typ)
+		}
+		r.buf = node.data
+		for i := len(node.links) - 1; i >= 0; i-- {
+			r.stack = append(r.stack, node.links[i].cid)
+			r.link(node.links[i].cid)
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *ipfsReader) Close() error {
+	return r.body.Close()
+}
+
+// resolve follows the path names from c, through UnixFS directories.
+func (r *ipfsReader) resolve(c cid, names []string) (cid, error) {
+	for _, name := range names {
+		if c.codec != codecDagPB {
+			return c, fmt.Errorf("%s: not a directory", name)
+		}
+		blk, err := r.block(c)
+		if err != nil {
+			return c, err
+		}
+		node, err := parseDagPB(blk)
+		if err != nil {
+			return c, err
+		}
+		switch node.typ {
+		case unixfsDir:
+		case unixfsShard:
+			return c, fmt.Errorf("%s: sharded directories aren't supported", name)
+		default:
+			return c, fmt.Errorf("%s: not a directory", name)
+		}
+		found := false
+		for _, l := range node.links {
+			if l.name == name {
+				c, found = l.cid, true
+				r.link(c)
+				break
+			}
+		}
+		if !found {
+			return c, fmt.Errorf("%s: no such file", name)
+		}
+	}
+	return c, nil
+}
+
+// readHeader skips the CAR header, which lists the roots.
+func (r *ipfsReader) readHeader() error {
+	n, err := binary.ReadUvarint(r.car)
+	if err != nil || n == 0 || n > maxIPFSBlock {
+		return errors.New("malformed CAR header")
+	}
+	_, err = io.CopyN(ioutil.Discard, r.car, int64(n))
+	return err
+}
+
+// link counts a link to c, that will be followed with block:
+// a kept block can't be evicted while it has links to follow.
+func (r *ipfsReader) link(c cid) {
+	if c.hash[0] != hashIdent {
+		r.refs[string(c.hash)]++
+	}
+}
+
+// evict drops the kept blocks that were used,
+// and aren't linked again yet, to make room in the stash.
+// Without duplicates, those that are linked again can't be read.
+func (r *ipfsReader) evict() {
+	for key, n := range r.refs {
+		if _, ok := r.stash[key]; ok && n <= 0 {
+			r.drop(key)
+			r.dropped = true
+		}
+	}
+}
+
+func (r *ipfsReader) drop(key string) {
+	r.size -= len(r.stash[key])
+	delete(r.stash, key)
+	delete(r.refs, key)
+}
+
+// block returns the verified block of c,
+// reading the CAR until it's found.
+func (r *ipfsReader) block(c cid) ([]byte, error) {
+	if c.hash[0] == hashIdent {
+		_, digest, err := splitMultihash(c.hash)
+		return digest, err
+	}
+	key := string(c.hash)
+	for {
+		if blk, ok := r.stash[key]; ok {
+			r.refs[key]--
+			if !r.keep {
+				r.drop(key)
+			}
+			return blk, nil
+		}
+
+		n, err := binary.ReadUvarint(r.car)
+		if err == io.EOF {
+			if r.dropped {
+				return nil, fmt.Errorf("ipfs: missing block %x: the gateway doesn't send duplicates, and it wasn't kept", c.hash)
+			}
+			return nil, fmt.Errorf("ipfs: missing block %x", c.hash)
+		}
+		if err != nil || n == 0 || n > maxIPFSBlock {
+			return nil, errors.New("ipfs: malformed CAR block")
+		}
+		sec := make([]byte, n)
+		if _, err := io.ReadFull(r.car, sec); err != nil {
+			return nil, err
+		}
+		bc, rest, err := readCID(sec)
+		if err != nil {
+			return nil, fmt.Errorf("ipfs: %w", err)
+		}
+		if err := verifyMultihash(bc.hash, rest); err != nil {
+			return nil, err
+		}
+		if _, ok := r.stash[string(bc.hash)]; !ok {
+			r.stash[string(bc.hash)] = rest
+			if r.size += len(rest); r.size > maxIPFSStash {
+				r.evict()
+			}
+			if r.size > maxIPFSStash {
+				return nil, errors.New("ipfs: too many blocks sent before they were needed")
+			}
+		}
+	}
+}
+
+// verifyMultihash checks data against a multihash.
+func verifyMultihash(mh, data []byte) error {
+	code, digest, err := splitMultihash(mh)
+	if err != nil {
+		return err
+	}
+	var sum []byte
+	switch code {
+	case hashIdent:
+		sum = data
+	case hashSHA256:
+		s := sha256.Sum256(data)
+		sum = s[:]
+	case hashSHA512:
+		s := sha512.Sum512(data)
+		sum = s[:]
+	default:
+		return fmt.Errorf("ipfs: unsupported multihash 0x%x", code)
+	}
+	if !bytes.Equal(sum, digest) {
+		return fmt.Errorf("ipfs: block mismatch: got %x, expected %x", sum, digest)
+	}
+	return nil
+}
+
+func splitMultihash(mh []byte) (uint64, []byte, error) {
+	r := bytes.NewReader(mh)
+	code, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, errors.New("ipfs: malformed multihash")
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size != uint64(r.Len()) {
+		return 0, nil, errors.New("ipfs: malformed multihash")
+	}
+	return code, mh[len(mh)-r.Len():], nil
+}
+
+// readCID reads a binary CID from the start of b, returning the rest.
+func readCID(b []byte) (cid, []byte, error) {
+	// a CIDv0 is a bare sha2-256 multihash of a dag-pb block
+	if len(b) >= 34 && b[0] == hashSHA256 && b[1] == 32 {
+		return cid{codecDagPB, b[:34]}, b[34:], nil
+	}
+
+	r := bytes.NewReader(b)
+	version, err := binary.ReadUvarint(r)
+	if err != nil || version != 1 {
+		return cid{}, nil, errors.New("malformed CID")
+	}
+	codec, err := binary.ReadUvarint(r)
+	if err != nil {
+		return cid{}, nil, errors.New("malformed CID")
+	}
+	start := len(b) - r.Len()
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return cid{}, nil, errors.New("malformed CID")
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return cid{}, nil, errors.New("malformed CID")
+	}
+	end := len(b) - r.Len() + int(size)
+	return cid{codec, b[start:end]}, b[end:], nil
+}
+
+// parseCID parses a CID in text form: a base58 CIDv0 (Qm…),
+// or a CIDv1 in base32 (b…), base58 (z…) or hex (f…).
+func parseCID(s string) (cid, error) {
+	var b []byte
+	var err error
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		b, err = decodeBase58(s)
+	case s == "":
+		err = errors.New("empty")
+	default:
+		switch s[0] {
+		case 'b', 'B':
+			b, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(s[1:]))
+		case 'z':
+			b, err = decodeBase58(s[1:])
+		case 'f', 'F':
+			b, err = hex.DecodeString(s[1:])
+		default:
+			err = fmt.Errorf("unsupported multibase %q", s[0])
+		}
+	}
+	if err != nil {
+		return cid{}, err
+	}
+	c, rest, err := readCID(b)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("malformed CID")
+	}
+	if err == nil && c.codec != codecRaw && c.codec != codecDagPB {
+		err = fmt.Errorf("unsupported codec 0x%x", c.codec)
+	}
+	return c, err
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	zeros := 0
+	for i, c := range s {
+		d := strings.IndexRune(base58Alphabet, c)
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		if d == 0 && i == zeros {
+			zeros++
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// dagPBNode is a dag-pb block, with its UnixFS data.
+type dagPBNode struct {
+	links []dagPBLink
+	typ   uint64 // UnixFS type
+	data  []byte // UnixFS data
+}
+
+type dagPBLink struct {
+	cid  cid
+	name string
+}
+
+// parseDagPB decodes the protobuf of a dag-pb block,
+// and the UnixFS protobuf in its data.
+func parseDagPB(b []byte) (*dagPBNode, error) {
+	var node dagPBNode
+	var unixfs []byte
+	err := parseProtobuf(b, func(field uint64, v []byte) error {
+		switch field {
+		case 1:
+			unixfs = v
+		case 2:
+			var l dagPBLink
+			err := parseProtobuf(v, func(field uint64, v []byte) error {
+				var err error
+				switch field {
+				case 1:
+					var rest []byte
+					if l.cid, rest, err = readCID(v); err == nil && len(rest) > 0 {
+						err = errors.New("malformed CID")
+					}
+				case 2:
+					l.name = string(v)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			node.links = append(node.links, l)
+		}
+		return nil
+	})
+	if err == nil {
+		err = parseProtobuf(unixfs, func(field uint64, v []byte) error {
+			switch field {
+			case 1:
+				node.typ, _ = binary.Uvarint(v)
+			case 2:
+				node.data = v
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: malformed dag-pb block: %w", err)
+	}
+	return &node, nil
+}
+
+// parseProtobuf calls fn with each field of a protobuf message:
+// varints are passed encoded, and fixed size fields are skipped.
+func parseProtobuf(b []byte, fn func(field uint64, v []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad field key")
+		}
+		b = b[n:]
+
+		var v []byte
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("bad varint")
+			}
+			v, b = b[:n], b[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return io.ErrUnexpectedEOF
+			}
+			b = b[size:]
+			continue
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errors.New("bad length")
+			}
+			v, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		if err := fn(key>>3, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
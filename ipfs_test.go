@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCID(t *testing.T) {
+	// of the raw block "hello world"
+	const hello = "1220b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	tests := []struct {
+		cid     string
+		codec   uint64
+		hash    string // hex multihash
+		wantErr bool
+	}{
+		{cid: "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", codec: codecDagPB,
+			hash: "122059948439065f29619ef41280cbb932be52c56d99c5966b65e0111239f098bbef"},
+		{cid: "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", codec: codecRaw, hash: hello},
+		{cid: "BAFKREIFZJUT3TE2NHYEKKLSS27NH3K72YSCO7Y32KOAO5EEI66WOF36N5E", codec: codecRaw, hash: hello},
+		{cid: "zb2rhj7crUKTQYRGCRATFaQ6YFLTde2YzdqbbhAASkL9uRDXn", codec: codecRaw, hash: hello},
+		{cid: "f0155" + hello, codec: codecRaw, hash: hello},
+		{cid: "", wantErr: true},
+		{cid: "mAVUSILlNJ7mTTT4IpS5S19p9q/rEhO/jelOA7pCI96zi783p", wantErr: true},           // base64
+		{cid: "bafyreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", wantErr: true}, // dag-cbor
+		{cid: "f0155" + hello + "00", wantErr: true},
+		{cid: "f0155" + hello[:len(hello)-2], wantErr: true},
+		{cid: "f0255" + hello, wantErr: true},
+		{cid: "zb2rhj7crUKTQYRGCRATFaQ6YFLTde2YzdqbbhAASkL9uRDX0", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := parseCID(tt.cid)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCID(%q) = %x, want error", tt.cid, c.hash)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCID(%q) error: %v", tt.cid, err)
+			continue
+		}
+		if got := hex.EncodeToString(c.hash); c.codec != tt.codec || got != tt.hash {
+			t.Errorf("parseCID(%q) = 0x%x, %s; want 0x%x, %s", tt.cid, c.codec, got, tt.codec, tt.hash)
+		}
+	}
+}
+
+func TestVerifyMultihash(t *testing.T) {
+	data := []byte("hello world")
+	sum256 := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+
+	tests := []struct {
+		name    string
+		mh      []byte
+		wantErr bool
+	}{
+		{"sha2-256", append([]byte{hashSHA256, 32}, sum256[:]...), false},
+		{"sha2-512", append([]byte{hashSHA512, 64}, sum512[:]...), false},
+		{"identity", append([]byte{hashIdent, byte(len(data))}, data...), false},
+		{"mismatch", append([]byte{hashSHA256, 32}, sum512[:32]...), true},
+		{"truncated", append([]byte{hashSHA256, 32}, sum256[:31]...), true},
+		{"sha1", append([]byte{0x11, 20}, sum256[:20]...), true},
+		{"empty", nil, true},
+	}
+	for _, tt := range tests {
+		if err := verifyMultihash(tt.mh, data); (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifyMultihash() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// carBlock is a block of a CAR, with its CIDv1.
+type carBlock struct {
+	cid  []byte
+	data []byte
+}
+
+func rawBlock(data []byte) carBlock {
+	sum := sha256.Sum256(data)
+	return carBlock{append([]byte{1, codecRaw, hashSHA256, 32}, sum[:]...), data}
+}
+
+// dagPBBlock encodes a UnixFS node, with its links.
+func dagPBBlock(typ byte, names []string, links ...carBlock) carBlock {
+	var b []byte
+	field := func(b []byte, key byte, v []byte) []byte {
+		b = append(b, key)
+		b = appendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+	for i, l := range links {
+		var pl []byte
+		pl = field(pl, 1<<3|2, l.cid)
+		if names != nil {
+			pl = field(pl, 2<<3|2, []byte(names[i]))
+		}
+		b = field(b, 2<<3|2, pl)
+	}
+	b = field(b, 1<<3|2, []byte{1<<3 | 0, typ})
+
+	sum := sha256.Sum256(b)
+	return carBlock{append([]byte{1, codecDagPB, hashSHA256, 32}, sum[:]...), b}
+}
+
+func car(blocks ...carBlock) []byte {
+	b := []byte{1, 0}
+	for _, blk := range blocks {
+		b = appendUvarint(b, uint64(len(blk.cid)+len(blk.data)))
+		b = append(b, blk.cid...)
+		b = append(b, blk.data...)
+	}
+	return b
+}
+
+func TestFetchIPFS(t *testing.T) {
+	abc := rawBlock([]byte("abc"))
+	def := rawBlock([]byte("def"))
+	file := dagPBBlock(unixfsFile, nil, abc, abc, def)
+	dir := dagPBBlock(unixfsDir, []string{"f.txt"}, file)
+	mid := dagPBBlock(unixfsFile, nil, abc, def)
+	nested := dagPBBlock(unixfsFile, nil, mid, abc)
+	corrupt := rawBlock([]byte("abc"))
+	corrupt.data = []byte("abd")
+
+	// more blocks than can be kept: used ones are evicted,
+	// but those sent before they're needed can't be
+	var large []carBlock
+	var want []byte
+	const size = maxIPFSBlock - 64
+	for i := 0; i*size <= maxIPFSStash; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, size)
+		large = append(large, rawBlock(data))
+		want = append(want, data...)
+	}
+	big := dagPBBlock(unixfsFile, nil, large...)
+
+	cars := map[string]struct {
+		dups bool
+		car  []byte
+	}{
+		"file":      {false, car(file, abc, def)},
+		"file-dups": {true, car(file, abc, abc, def)},
+		"dir":       {false, car(dir, file, abc, def)},
+		"nested":    {false, car(nested, mid, abc, def)},
+		"missing":   {false, car(file, abc)},
+		"corrupt":   {false, car(file, corrupt, def)},
+		"large":     {false, car(append([]carBlock{big}, large...)...)},
+		"ahead":     {false, car(append(append([]carBlock{file}, large...), abc, def)...)},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := cars[strings.SplitN(r.URL.Path, "/", 3)[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if c.dups {
+			w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1; order=dfs; dups=y")
+		} else {
+			w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1; order=dfs; dups=n")
+		}
+		w.Write(c.car)
+	}))
+	defer srv.Close()
+
+	defer func(old string) { *ipfsGateway = old }(*ipfsGateway)
+	cidOf := func(b carBlock) string { return "f" + hex.EncodeToString(b.cid) }
+
+	tests := []struct {
+		test    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{test: "file", source: cidOf(file), want: "abcabcdef"},
+		{test: "file-dups", source: cidOf(file), want: "abcabcdef"},
+		{test: "dir", source: cidOf(dir) + "/f.txt", want: "abcabcdef"},
+		{test: "nested", source: cidOf(nested), want: "abcdefabc"},
+		{test: "large", source: cidOf(big), want: string(want)},
+		{test: "dir", source: cidOf(dir), wantErr: true},
+		{test: "dir", source: cidOf(dir) + "/g.txt", wantErr: true},
+		{test: "missing", source: cidOf(file), wantErr: true},
+		{test: "corrupt", source: cidOf(file), wantErr: true},
+		{test: "ahead", source: cidOf(file), wantErr: true},
+	}
+	for _, tt := range tests {
+		*ipfsGateway = srv.URL + "/" + tt.test
+		body, _, err := fetch("ipfs://"+tt.source, nil)
+		var got []byte
+		if err == nil {
+			got, err = ioutil.ReadAll(body)
+			body.Close()
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s %s: want error", tt.test, tt.source)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.test, tt.source, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s %s: got %.20q, want %.20q", tt.test, tt.source, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// job is a single download:
+// a source, and the target it's written or extracted to.
+type job struct {
+	source string
+	target string
+	digest string // algorithm:hex
+	unpack bool
+	subdir string // of the archive, to extract
+
+	unpackSet  bool   // unpack was chosen explicitly, overriding the config
+	decompress bool   // decompress, but don't extract archives
+	format     string // forced, rather than sniffed, like tar.gz
+
+	priority int    // higher runs first
+	deps     []*job // must succeed before this one runs
+
+	stdout      bool
+	targetIsDir bool
+	destination string   // the file saved, or the directory extracted to
+	received    *counter // bytes of the download
+	created     []string // for the -manifest
+	meta        *meta
+}
+
+// newJob creates a job, configured by the command line flags.
+func newJob(source, target string) *job {
+	j := &job{
+		source: expand(source),
+		target: expand(target),
+		unpack: *unpack,
+		format: *forceFormat,
+
+		unpackSet: isFlagSet("unpack"),
+	}
+	if *sha256sum != "" {
+		j.digest = "sha256:" + *sha256sum
+	}
+	return j
+}
+
+func (j *job) run() error {
+	if *mirror {
+		if err := j.mirrorTarget(); err != nil {
+			return err
+		}
+	}
+	j.stdout = j.target == "-"
+
+	// is target a directory?
+	if !j.stdout && j.target != "" {
+		if strings.HasSuffix(j.target, string(filepath.Separator)) {
+			j.targetIsDir = true
+		} else {
+			fi, _ := os.Stat(j.target)
+			j.targetIsDir = fi != nil && fi.IsDir()
+		}
+	}
+
+	// repositories are always extracted
+	if strings.HasPrefix(j.source, "git::") {
+		j.unpack, j.unpackSet = true, true
+	} else if j.source, j.subdir = splitSubdir(j.source); j.subdir != "" {
+		j.unpack, j.unpackSet = true, true
+	}
+	if *entry != "" || j.format != "" && j.format != "raw" {
+		j.unpack, j.unpackSet = true, true
+	}
+
+	if *raw {
+		if j.unpack || *list {
+			return errors.New("-raw disables unpacking")
+		}
+		if j.targetIsDir {
+			return fmt.Errorf("-raw needs a file target; %q is a directory", j.target)
+		}
+	}
+
+	if *plan != "" {
+		return j.plan()
+	}
+	if *headOnly {
+		return j.head()
+	}
+
+	// is target already there?
+	if *verifyExisting && j.digest != "" && !j.unpack && !j.targetIsDir && !j.stdout {
+		if hasDigest(j.target, j.digest) {
+			return nil
+		}
+	}
+
+	// start download
+	var saved bool
+	defer func() { downloads.release(j, saved) }()
+	body, meta, err := j.open()
+	if err == errNotModified {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	j.meta = meta
+	if body, err = limitSize(body, meta); err != nil {
+		return err
+	}
+	if *patchFrom != "" {
+		if body, err = newPatcher(body, *patchFrom); err != nil {
+			return err
+		}
+		defer body.Close()
+	}
+
+	if j.digest != "" {
+		body, err = gofetch.NewVerifier(body, j.digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	// apply configured defaults
+	if !j.unpackSet && !*raw && !*list {
+		switch unpackMode(meta.name, meta.contentType) {
+		case "always":
+			j.unpack = true
+		case "never":
+			j.unpack = false
+		case "decompress":
+			j.unpack, j.decompress = true, true
+		}
+	}
+
+	// keep concurrent runs from writing the same files
+	if !j.stdout && !*list {
+		path := j.target
+		if j.targetIsDir && !j.unpack {
+			path = filepath.Join(path, filepath.Base(meta.name))
+		}
+		unlock, err := lockPath(path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	// digest the payload as it's downloaded
+	digest := sha256.New()
+	var size counter
+	payload := io.TeeReader(body, io.MultiWriter(digest, &size))
+
+	if *showProgress {
+		total, read := int64(-1), &size
+		if meta.res != nil {
+			total = meta.res.ContentLength
+			// count the bytes transferred, rather than decoded
+			if d := decodedBody(meta.res.Body); d != nil {
+				total, read = d.size, &d.encoded
+			}
+		}
+		if batch != nil {
+			batch.add(total, read)
+		} else {
+			stop := startProgress(meta.name, total, read)
+			defer stop()
+		}
+	}
+
+	j.received = &size
+	t := &gofetch.Target{
+		Path:        j.target,
+		IsDir:       j.targetIsDir,
+		Stdout:      j.stdout,
+		Name:        meta.name,
+		ContentType: meta.contentType,
+		Subdir:      j.subdir,
+		Received:    size.load,
+		Track:       *manifest != "",
+	}
+	if meta.res != nil {
+		t.Size = meta.res.ContentLength
+	}
+	if *list {
+		t.List = listPrint
+	}
+	ctx := context.Background()
+	var ranged bool
+	if j.unpack || *list {
+		br := bufio.NewReader(payload)
+		if r := j.openRanged(br); r != nil {
+			// the rest of the download isn't needed
+			ranged = true
+			body.Close()
+			err = j.unpacker().SaveZip(ctx, r, r.size, t)
+		} else {
+			err = j.unpacker().Save(ctx, br, t)
+		}
+	} else {
+		err = j.unpacker().Save(ctx, payload, t)
+	}
+	j.destination, j.created = t.Destination, t.Created
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		return nil
+	}
+	saved = true
+
+	if *link && !j.unpack && !j.stdout {
+		if err := linkCached(j.destination, digest.Sum(nil), size.load()); err != nil {
+			return err
+		}
+	}
+
+	if *remoteTime && !j.stdout {
+		if err := j.setRemoteTime(meta); err != nil {
+			return err
+		}
+	}
+	if *manifest != "" && !j.stdout {
+		if err := j.writeManifest(); err != nil {
+			return err
+		}
+	}
+
+	var header http.Header
+	if meta.res != nil {
+		header = meta.res.Header
+	}
+	// only parts of a ranged download are read
+	sum := hex.EncodeToString(digest.Sum(nil))
+	if ranged {
+		sum = ""
+	}
+	if err := recordHistory(historyEntry{
+		Time:   time.Now().UTC(),
+		URL:    j.source,
+		SHA256: sum,
+		Size:   size.load(),
+		Target: j.destination,
+
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}); err != nil {
+		log.Print("history: ", err)
+	}
+	return nil
+}
+
+// conditional returns headers to skip downloading the source
+// if it hasn't changed since it was last saved to the target.
+func (j *job) conditional() http.Header {
+	if *raw || *list || *plan != "" || j.stdout || j.subdir != "" {
+		return nil
+	}
+	target, err := filepath.Abs(j.target)
+	if err != nil {
+		return nil
+	}
+
+	// like curl -z, compare with the target's modification time;
+	// a target that doesn't match the expected digest is downloaded again
+	if *newerThanTarget && (!j.targetIsDir || j.unpack) {
+		if fi, err := os.Stat(target); err == nil && (j.digest == "" || hasDigest(target, j.digest)) {
+			return http.Header{"If-Modified-Since": {fi.ModTime().UTC().Format(http.TimeFormat)}}
+		}
+	}
+
+	e := lastDownload(j.source, target, j.targetIsDir && !j.unpack)
+	if e == nil || e.ETag == "" && e.LastModified == "" {
+		return nil
+	}
+
+	// the target must not have changed either
+	if fi, err := os.Stat(e.Target); err != nil {
+		return nil
+	} else if !fi.IsDir() && !hasDigest(e.Target, "sha256:"+e.SHA256) {
+		return nil
+	} else if j.digest != "" && !fi.IsDir() && !hasDigest(e.Target, j.digest) {
+		// nor be other than expected
+		return nil
+	} else if j.digest != "" && fi.IsDir() && j.digest != "sha256:"+e.SHA256 {
+		// an extracted download can only be checked by its recorded digest
+		return nil
+	}
+
+	h := http.Header{}
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	} else {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+	return h
+}
+
+// open fetches the source, unless another job is downloading the same.
+func (j *job) open() (io.ReadCloser, *meta, error) {
+	// with -patch, the digest is of the patched file
+	if j.digest != "" && *patchFrom == "" {
+		if f, m := downloads.reuse(j, "digest:"+j.digest); f != nil {
+			return f, m, nil
+		}
+	}
+
+	var body io.ReadCloser
+	var meta *meta
+	var err error
+	if strings.HasPrefix(j.source, "zsync::") && !j.unpack && !j.stdout {
+		// the target is reused, if it's there
+		body, meta, err = fetchZsync(j.source, j.target, j.targetIsDir)
+	} else {
+		body, meta, err = j.fetchRegion()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.res != nil {
+		if f, _ := downloads.reuse(j, "url:"+meta.res.Request.URL.String()); f != nil {
+			body.Close()
+			return f, meta, nil
+		}
+	}
+	return limitSpeed(limitRate(body)), meta, nil
+}
+
+// fetchRegion fetches the source, failing over between the configured
+// regions, if it has a {region} placeholder.
+func (j *job) fetchRegion() (io.ReadCloser, *meta, error) {
+	if !strings.Contains(j.source, "{region}") || len(conf.Regions) == 0 {
+		return fetch(j.source, j.conditional())
+	}
+	var err error
+	for i, region := range conf.Regions {
+		source := strings.Replace(j.source, "{region}", region, -1)
+		var body io.ReadCloser
+		var meta *meta
+		body, meta, err = fetch(source, j.conditional())
+		if err == nil || err == errNotModified {
+			return body, meta, err
+		}
+		if i+1 < len(conf.Regions) {
+			log.Printf("region %s: %v; trying %s", region, err, conf.Regions[i+1])
+		}
+	}
+	return nil, nil, err
+}
+
+// setRemoteTime sets the modification time of a saved file
+// from the Last-Modified header.
+func (j *job) setRemoteTime(meta *meta) error {
+	if meta.res == nil {
+		return nil
+	}
+	t, err := http.ParseTime(meta.res.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil
+	}
+	if fi, err := os.Stat(j.destination); err != nil || !fi.Mode().IsRegular() {
+		return err
+	}
+	return os.Chtimes(j.destination, t, t)
+}
+
+// mirrorTarget reproduces the url path hierarchy under the target directory.
+func (j *job) mirrorTarget() error {
+	u, err := url.Parse(j.source)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("-mirror needs a url; got %q", j.source)
+	}
+
+	dirs := strings.Split(strings.Trim(path.Dir(u.Path), "/"), "/")
+	if dirs[0] == "" {
+		dirs = nil
+	}
+	if *cutDirs < len(dirs) {
+		dirs = dirs[*cutDirs:]
+	} else {
+		dirs = nil
+	}
+	if !*noHostDirs {
+		dirs = append([]string{asciiHost(u.Hostname())}, dirs...)
+	}
+
+	name := path.Base(u.Path)
+	if strings.HasSuffix(u.Path, "/") || name == "/" || name == "." {
+		name = "index.html"
+	}
+	if !j.unpack {
+		dirs = append(dirs, name)
+	}
+
+	j.target = filepath.Join(append([]string{j.target}, dirs...)...)
+	if j.unpack {
+		j.target += string(filepath.Separator)
+	}
+	return nil
+}
+
+// splitSubdir splits a go-getter style url//subdir source,
+// keeping any query string with the url.
+// data: URIs and magnet links, which may embed urls, aren't split.
+func splitSubdir(source string) (string, string) {
+	i := strings.Index(source, "://")
+	if i < 0 || strings.HasPrefix(source, "data:") || strings.HasPrefix(source, "magnet:") {
+		return source, ""
+	}
+	i += len("://")
+
+	rest, query := source[i:], ""
+	if q := strings.IndexByte(rest, '?'); q >= 0 {
+		rest, query = rest[:q], rest[q:]
+	}
+	s := strings.Index(rest, "//")
+	if s < 0 {
+		return source, ""
+	}
+	subdir := path.Clean(strings.Trim(rest[s+2:], "/"))
+	if subdir == "." {
+		subdir = ""
+	}
+	return source[:i] + rest[:s] + query, subdir
+}
+
+// unpacker configures the fetch package to save, or unpack, the job,
+// as the flags say.
+func (j *job) unpacker() *gofetch.Fetcher {
+	policy := gofetch.UnpackAuto
+	switch {
+	case !j.unpack && !*list:
+		policy = gofetch.UnpackNever
+	case j.decompress:
+		policy = gofetch.UnpackDecompress
+	}
+	opts := []gofetch.Option{
+		gofetch.WithUnpack(policy),
+		gofetch.WithLimits(gofetch.Limits{MaxSize: int64(maxExtractSize), MaxFiles: maxFiles, MaxRatio: maxRatio}),
+		gofetch.WithFormat(j.format),
+		gofetch.WithEntry(*entry),
+		gofetch.WithStripComponents(*stripComponents),
+		gofetch.WithStripTop(*stripTop),
+		gofetch.WithClean(*cleanTarget),
+		gofetch.WithNested(*unpackDepth),
+		gofetch.WithOverwrite(gofetch.Overwrite(*overwrite)),
+		gofetch.WithSymlinks(gofetch.Symlinks(*symlinks)),
+		gofetch.WithPortableNames(gofetch.PortableNames(*portableNames)),
+		gofetch.WithPermissions(gofetch.Permissions{
+			PreserveSuid:       *preserveSuid,
+			StripSetuid:        *stripSetuid,
+			StripWorldWritable: *stripWorldWritable,
+			DenySetuid:         *denySetuid,
+			DenyWorldWritable:  *denyWorldWritable,
+		}),
+		gofetch.WithOwner(ownerUID, ownerGID),
+		gofetch.WithXattrs(*xattrs),
+		gofetch.WithSpecialFiles(*specialFiles),
+		gofetch.WithZipPassword(*zipPassword),
+		gofetch.WithZipEncoding(zipCharset),
+		gofetch.WithWorkers(*extractWorkers),
+		gofetch.WithDecompressJobs(*decompressJobs),
+		gofetch.WithFsync(*fsync),
+		gofetch.WithTrailer(*trailerFile),
+		gofetch.WithLogger(log.Printf),
+	}
+	if *sameOwner {
+		opts = append(opts, gofetch.WithSameOwner(*numericOwner))
+	}
+	return fetcher.With(opts...)
+}
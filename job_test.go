@@ -0,0 +1,523 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+func TestJob_run(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		digest  string
+		want    string // file written
+		wantErr bool
+	}{
+		{name: "file", source: "data:,hello", target: "file.txt", want: "file.txt"},
+		{name: "directory", source: "data:,hello", target: "dir/", want: "dir/data"},
+		{name: "digest", source: "data:,hello", target: "checked.txt", want: "checked.txt",
+			digest: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{name: "mismatch", source: "data:,hello", target: "bad.txt", wantErr: true,
+			digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+	}
+	for _, tt := range tests {
+		j := newJob(tt.source, filepath.Join(dir, tt.target))
+		if tt.target[len(tt.target)-1] == '/' {
+			j.target += string(filepath.Separator)
+		}
+		j.digest = tt.digest
+		err := j.run()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: run() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(tt.want)))
+		if err != nil || string(got) != "hello" {
+			t.Errorf("%s: wrote %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestJob_raw(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	defer func(old bool, c *http.Client) { *raw, client = old, c }(*raw, client)
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+
+	for _, isRaw := range []bool{false, true} {
+		*raw = isRaw
+		configureClient()
+
+		name := fmt.Sprint("raw-", isRaw)
+		target := filepath.Join(dir, name)
+		if err := newJob(srv.URL+"/"+name, target).run(); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadFile(target)
+		if want := "hello"; isRaw {
+			if !bytes.Equal(got, gz.Bytes()) {
+				t.Errorf("-raw wrote %q, want the encoded bytes", got)
+			}
+		} else if string(got) != want {
+			t.Errorf("wrote %q, want %q", got, want)
+		}
+	}
+
+	j := newJob(srv.URL+"/file", dir)
+	j.unpack = true
+	if err := j.run(); err == nil {
+		t.Error("-raw with -unpack: want error")
+	}
+}
+
+func TestJob_config(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file.txt", Mode: 0644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.Close()
+	tarball := buf.Bytes()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(tarball)
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	defer func(old string, c config) { *history, conf = old, c }(*history, conf)
+	*history = "off"
+
+	dir := t.TempDir()
+	for _, mode := range []string{"always", "never", "decompress"} {
+		conf.Unpack = map[string]string{".tgz": mode}
+		target := filepath.Join(dir, mode) + string(filepath.Separator)
+		if err := newJob(srv.URL+"/file.tgz", target).run(); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		var name string
+		var want []byte
+		switch mode {
+		case "always":
+			name, want = "file.txt", []byte("hello")
+		case "never":
+			name, want = "file.tgz", gz.Bytes()
+		case "decompress":
+			name, want = "file.tgz", tarball
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, mode, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: wrote %q, %v", mode, got, err)
+		}
+	}
+}
+
+func TestSplitSubdir(t *testing.T) {
+	tests := []struct {
+		source string
+		url    string
+		subdir string
+	}{
+		{"https://host/a.tar.gz", "https://host/a.tar.gz", ""},
+		{"https://host/a.tar.gz//sub/dir", "https://host/a.tar.gz", "sub/dir"},
+		{"https://host/a.tar.gz//sub/?x=1", "https://host/a.tar.gz?x=1", "sub"},
+		{"https://host/a.tar.gz//", "https://host/a.tar.gz", ""},
+		{"https://host/a.zip?u=http://x//y", "https://host/a.zip?u=http://x//y", ""},
+		{"git::https://host/repo.git//sub", "git::https://host/repo.git", "sub"},
+		{"a.tar.gz", "a.tar.gz", ""},
+		{"data:text/plain,https://host//sub", "data:text/plain,https://host//sub", ""},
+		{"magnet:?xt=urn:btih:0&tr=udp://host//sub", "magnet:?xt=urn:btih:0&tr=udp://host//sub", ""},
+	}
+	for _, tt := range tests {
+		url, subdir := splitSubdir(tt.source)
+		if url != tt.url || subdir != tt.subdir {
+			t.Errorf("splitSubdir(%q) = %q, %q; want %q, %q", tt.source, url, subdir, tt.url, tt.subdir)
+		}
+	}
+}
+
+func TestJob_subdir(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"./top.txt", "./sub/", "./sub/a.txt", "./sub/dir/", "./sub/dir/b.txt", "./subway.txt"} {
+		if strings.HasSuffix(name, "/") {
+			tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755})
+			continue
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+
+	dir := t.TempDir()
+	j := &job{unpack: true}
+	if err := j.unpacker().Save(context.Background(), &buf, &gofetch.Target{Path: dir, Subdir: "sub"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"a.txt", "dir/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("extracted %q, want %q", got, want)
+	}
+}
+
+func TestJob_mirrorTarget(t *testing.T) {
+	defer func(cut int, noHost bool) { *cutDirs, *noHostDirs = cut, noHost }(*cutDirs, *noHostDirs)
+
+	tests := []struct {
+		source  string
+		unpack  bool
+		cut     int
+		noHost  bool
+		want    string
+		wantErr bool
+	}{
+		{source: "https://host:8080/a/b/file.txt", want: "out/host/a/b/file.txt"},
+		{source: "https://host/a/b/file.txt", noHost: true, want: "out/a/b/file.txt"},
+		{source: "https://host/a/b/file.txt", cut: 1, want: "out/host/b/file.txt"},
+		{source: "https://host/a/b/file.txt", cut: 5, noHost: true, want: "out/file.txt"},
+		{source: "https://host/a/", want: "out/host/a/index.html"},
+		{source: "https://host", want: "out/host/index.html"},
+		{source: "https://Bücher.example/a.txt", want: "out/xn--bcher-kva.example/a.txt"},
+		{source: "https://host/a/b.tar.gz", unpack: true, want: "out/host/a/"},
+		{source: "-", wantErr: true},
+	}
+	for _, tt := range tests {
+		*cutDirs, *noHostDirs = tt.cut, tt.noHost
+		j := &job{source: tt.source, target: "out", unpack: tt.unpack}
+		err := j.mirrorTarget()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mirrorTarget(%q) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); err == nil && j.target != want {
+			t.Errorf("mirrorTarget(%q) = %q, want %q", tt.source, j.target, want)
+		}
+	}
+}
+
+func TestJob_conditional(t *testing.T) {
+	var downloaded int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloaded++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	defer func(old string) { *history = old }(*history)
+	*history = filepath.Join(dir, "history")
+
+	target := filepath.Join(dir, "file")
+	for i, want := range []int{1, 1, 2} {
+		if i == 2 {
+			// a changed target is downloaded again
+			ioutil.WriteFile(target, []byte("changed"), 0666)
+		}
+		if err := newJob(srv.URL+"/file", target).run(); err != nil {
+			t.Fatal(err)
+		}
+		if downloaded != want {
+			t.Errorf("run %d: downloaded %d times, want %d", i, downloaded, want)
+		}
+		if got, _ := ioutil.ReadFile(target); string(got) != "hello" {
+			t.Errorf("run %d: wrote %q", i, got)
+		}
+	}
+}
+
+func TestJob_fetchRegion(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if !strings.HasPrefix(r.URL.Path, "/us/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string, c config) { *history, conf = old, c }(*history, conf)
+	*history = "off"
+	conf.Regions = []string{"eu", "us", "ap"}
+
+	target := filepath.Join(t.TempDir(), "file")
+	if err := newJob(srv.URL+"/{region}/file", target).run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); string(got) != "hello" {
+		t.Errorf("wrote %q", got)
+	}
+	if want := []string{"/eu/file", "/us/file"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("requested %q, want %q", paths, want)
+	}
+
+	paths = nil
+	conf.Regions = []string{"eu"}
+	if err := newJob(srv.URL+"/{region}/file", target).run(); err == nil {
+		t.Error("all regions failed: want error")
+	}
+}
+
+func TestJob_newerThanTarget(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", modified, strings.NewReader("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string, newer bool) { *history, *newerThanTarget = old, newer }(*history, *newerThanTarget)
+	*history = "off"
+	*newerThanTarget = true
+
+	target := filepath.Join(t.TempDir(), "file")
+	tests := []struct {
+		mtime time.Time
+		want  string
+	}{
+		{mtime: modified.Add(time.Hour), want: "local"},
+		{mtime: modified.Add(-time.Hour), want: "hello"},
+	}
+	for _, tt := range tests {
+		ioutil.WriteFile(target, []byte("local"), 0666)
+		os.Chtimes(target, tt.mtime, tt.mtime)
+		if err := newJob(srv.URL+"/file", target).run(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadFile(target); string(got) != tt.want {
+			t.Errorf("target modified %v: got %q, want %q", tt.mtime, got, tt.want)
+		}
+	}
+}
+
+func TestJob_remoteTime(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", modified, strings.NewReader("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string, remote bool) { *history, *remoteTime = old, remote }(*history, *remoteTime)
+	*history = "off"
+
+	dir := t.TempDir()
+	for _, remote := range []bool{false, true} {
+		*remoteTime = remote
+		target := filepath.Join(dir, fmt.Sprint("remote-", remote))
+		if err := newJob(srv.URL+"/file", target).run(); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.ModTime().Equal(modified); got != remote {
+			t.Errorf("-remote-time=%v: modified %v", remote, fi.ModTime())
+		}
+	}
+}
+
+func TestJob_existing(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+
+	sum := sha256.Sum256([]byte("hello"))
+	target := filepath.Join(t.TempDir(), "file")
+	for i, local := range []string{"hello", "stale"} {
+		requests = 0
+		ioutil.WriteFile(target, []byte(local), 0666)
+
+		j := newJob(srv.URL+fmt.Sprint("/file", i), target)
+		j.digest = "sha256:" + hex.EncodeToString(sum[:])
+		if err := j.run(); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]int{"hello": 0, "stale": 1}[local]; requests != want {
+			t.Errorf("target %q: made %d requests, want %d", local, requests, want)
+		}
+		if got, _ := ioutil.ReadFile(target); string(got) != "hello" {
+			t.Errorf("target %q: got %q", local, got)
+		}
+	}
+}
+
+func TestJob_link(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	defer func(old, c string, l bool) { *history, *cache, *link = old, c, l }(*history, *cache, *link)
+	*history, *cache, *link = "off", filepath.Join(dir, "cache"), true
+
+	var files []os.FileInfo
+	for _, name := range []string{"a", "b"} {
+		target := filepath.Join(dir, name)
+		if err := newJob(srv.URL+"/"+name, target).run(); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fi)
+	}
+	if !os.SameFile(files[0], files[1]) {
+		t.Error("-link: downloads aren't linked")
+	}
+}
+
+func TestJob_partial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "file")
+	ioutil.WriteFile(target, []byte("previous"), 0666)
+
+	// a download that fails verification leaves the target alone
+	j := newJob(srv.URL+"/file", target)
+	j.digest = "sha256:" + strings.Repeat("0", 64)
+	if err := j.run(); err == nil {
+		t.Fatal("want verification error")
+	}
+	if got, _ := ioutil.ReadFile(target); string(got) != "previous" {
+		t.Errorf("failed download changed the target to %q", got)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("failed download left %d files behind", len(files))
+	}
+
+	if err := newJob(srv.URL+"/file", target).run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); string(got) != "hello" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+func TestJob_entry(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "tool-1.0/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "tool-1.0/README", Mode: 0644, Size: 6})
+	tw.Write([]byte("readme"))
+	tw.WriteHeader(&tar.Header{Name: "tool-1.0/bin/tool", Mode: 0755, Size: 4})
+	tw.Write([]byte("tool"))
+	tw.Close()
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	defer func(old, e string) { *history, *entry = old, e }(*history, *entry)
+	*history = "off"
+
+	dir := t.TempDir()
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{entry: "tool-1.0/bin/tool", want: "tool"},
+		{entry: "./tool-1.0/README", want: "readme"},
+		{entry: "tool-1.0/", wantErr: true},
+		{entry: "missing", wantErr: true},
+	}
+	for i, tt := range tests {
+		*entry = tt.entry
+		target := filepath.Join(dir, fmt.Sprint("entry", i))
+		err := newJob(srv.URL+fmt.Sprintf("/tool%d.tgz", i), target).run()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("-entry %q: want error", tt.entry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadFile(target); string(got) != tt.want {
+			t.Errorf("-entry %q wrote %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+
+	*entry = "tool-1.0/bin/tool"
+	out := captureStdout(t, func() {
+		if err := newJob(srv.URL+"/stdout.tgz", "-").run(); err != nil {
+			t.Error(err)
+		}
+	})
+	if out != "tool" {
+		t.Errorf("-entry to stdout wrote %q", out)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sizeFlag is a size in bytes, with an optional K, M, G or T suffix
+// (powers of 1024, like curl).
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeFlag) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	num, mul := strings.TrimSpace(v), int64(1)
+	if num != "" {
+		switch strings.ToUpper(num[len(num)-1:]) {
+		case "K":
+			mul = 1 << 10
+		case "M":
+			mul = 1 << 20
+		case "G":
+			mul = 1 << 30
+		case "T":
+			mul = 1 << 40
+		}
+		if mul > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return int64(f * float64(mul)), nil
+}
+
+var (
+	rateLimit   sizeFlag // bytes per second
+	speedLimit  sizeFlag // bytes per second
+	speedTime   time.Duration
+	maxFilesize sizeFlag
+
+	// limits on unarchiving
+	maxExtractSize sizeFlag
+	maxFiles       int
+	maxRatio       float64
+)
+
+func init() {
+	flag.Var(&rateLimit, "limit-rate", "limit download speed to `bytes` per second (e.g. 500K, 2M)")
+	flag.Var(&speedLimit, "speed-limit", "fail downloads slower than `bytes` per second for -speed-time")
+	flag.DurationVar(&speedTime, "speed-time", 30*time.Second, "`duration` over which -speed-limit is measured")
+	flag.Var(&maxFilesize, "max-filesize", "fail downloads larger than `bytes` (e.g. 100M)")
+	flag.Var(&maxExtractSize, "max-extract-size", "fail to unpack archives larger than `bytes`, once extracted")
+	flag.IntVar(&maxFiles, "max-files", 0, "fail to unpack archives with more than `count` entries")
+	flag.Float64Var(&maxRatio, "max-ratio", 0, "fail to unpack archives that expand more than `ratio` times their download")
+}
+
+// limitSpeed wraps r to fail if, over -speed-time,
+// it's read slower than -speed-limit, including when it stalls.
+func limitSpeed(r io.ReadCloser) io.ReadCloser {
+	if speedLimit <= 0 || speedTime <= 0 {
+		return r
+	}
+	s := &speedReader{ReadCloser: r, done: make(chan struct{})}
+	go s.watch()
+	return s
+}
+
+type speedReader struct {
+	io.ReadCloser
+	read counter
+	done chan struct{}
+	once sync.Once
+	slow int32 // atomic
+}
+
+func (s *speedReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.read.Write(p[:n])
+	if atomic.LoadInt32(&s.slow) != 0 {
+		return n, fmt.Errorf("download slower than -speed-limit for %v", speedTime)
+	}
+	if err != nil {
+		s.stop()
+	}
+	return n, err
+}
+
+func (s *speedReader) Close() error {
+	s.stop()
+	return s.ReadCloser.Close()
+}
+
+func (s *speedReader) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// watch samples how much was read every second, and if too little was
+// read over the last -speed-time, closes the download to abort reads.
+func (s *speedReader) watch() {
+	interval := time.Second
+	if speedTime < interval {
+		interval = speedTime
+	}
+	samples := make([]int64, int((speedTime+interval-1)/interval)+1)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for i := 1; ; i++ {
+		select {
+		case <-s.done:
+			return
+		case <-tick.C:
+		}
+		samples[i%len(samples)] = s.read.load()
+		if i < len(samples)-1 {
+			continue
+		}
+		oldest := samples[(i+1)%len(samples)]
+		if float64(samples[i%len(samples)]-oldest) < float64(speedLimit)*speedTime.Seconds() {
+			atomic.StoreInt32(&s.slow, 1)
+			s.ReadCloser.Close()
+			return
+		}
+	}
+}
+
+// limitSize wraps r to fail once it exceeds -max-filesize,
+// failing right away if the server says it will.
+func limitSize(r io.ReadCloser, m *meta) (io.ReadCloser, error) {
+	if maxFilesize <= 0 {
+		return r, nil
+	}
+	if m.res != nil && m.res.ContentLength > int64(maxFilesize) {
+		return nil, fmt.Errorf("download is %d bytes, larger than -max-filesize", m.res.ContentLength)
+	}
+	return &sizeReader{r, int64(maxFilesize)}, nil
+}
+
+type sizeReader struct {
+	io.ReadCloser
+	left int64
+}
+
+func (r *sizeReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.left -= int64(n); r.left < 0 {
+		return n, errors.New("download is larger than -max-filesize")
+	}
+	return n, err
+}
+
+// rateLimiter is a token bucket, shared by every download,
+// that holds up to a second worth of bytes.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+var limiter rateLimiter
+
+// take waits until n bytes can be read.
+func (l *rateLimiter) take(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = l.rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// limitRate wraps r to read no faster than -limit-rate.
+func limitRate(r io.ReadCloser) io.ReadCloser {
+	if rateLimit <= 0 {
+		return r
+	}
+	limiter.mu.Lock()
+	limiter.rate = float64(rateLimit)
+	limiter.mu.Unlock()
+	return &rateReader{r}
+}
+
+type rateReader struct {
+	io.ReadCloser
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	// read small chunks, to keep the rate smooth
+	limiter.mu.Lock()
+	rate := limiter.rate
+	limiter.mu.Unlock()
+	if max := int(rate / 10); len(p) > max && max > 0 {
+		p = p[:max]
+	}
+	n, err := r.ReadCloser.Read(p)
+	limiter.take(n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "100", want: 100},
+		{in: "500K", want: 500 << 10},
+		{in: "2m", want: 2 << 20},
+		{in: "1.5G", want: 3 << 29},
+		{in: "1T", want: 1 << 40},
+		{in: "", wantErr: true},
+		{in: "M", wantErr: true},
+		{in: "-1K", wantErr: true},
+		{in: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	defer func(old sizeFlag) { rateLimit, limiter = old, rateLimiter{} }(rateLimit)
+	rateLimit, limiter = 200<<10, rateLimiter{}
+
+	// a second worth of bytes is allowed at once, the rest waits
+	data := make([]byte, 300<<10)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, limitRate(ioutil.NopCloser(bytes.NewReader(data))))
+	elapsed := time.Since(start)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %d, %v", n, err)
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("took %v, want about 500ms", elapsed)
+	}
+}
+
+func TestLimitSize(t *testing.T) {
+	defer func(old sizeFlag) { maxFilesize = old }(maxFilesize)
+	maxFilesize = 10
+
+	body := func(n int) io.ReadCloser {
+		return ioutil.NopCloser(bytes.NewReader(make([]byte, n)))
+	}
+	tests := []struct {
+		size       int
+		length     int64 // Content-Length, or -1
+		wantErr    bool  // up front
+		wantErrEOF bool  // reading
+	}{
+		{size: 10, length: 10},
+		{size: 10, length: -1},
+		{size: 11, length: 11, wantErr: true},
+		{size: 11, length: -1, wantErrEOF: true},
+		{size: 11, length: 5, wantErrEOF: true}, // the server lied
+	}
+	for _, tt := range tests {
+		m := &meta{res: &http.Response{ContentLength: tt.length}}
+		r, err := limitSize(body(tt.size), m)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("limitSize(%d, %d) error = %v, wantErr %v", tt.size, tt.length, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := ioutil.ReadAll(r); (err != nil) != tt.wantErrEOF {
+			t.Errorf("limitSize(%d, %d) read error = %v, wantErr %v", tt.size, tt.length, err, tt.wantErrEOF)
+		}
+	}
+}
+
+func TestLimitSpeed(t *testing.T) {
+	defer func(l sizeFlag, d time.Duration) { speedLimit, speedTime = l, d }(speedLimit, speedTime)
+	speedLimit, speedTime = 1<<10, 200*time.Millisecond
+
+	fast := limitSpeed(ioutil.NopCloser(bytes.NewReader(make([]byte, 1<<20))))
+	if _, err := ioutil.ReadAll(fast); err != nil {
+		t.Error(err)
+	}
+	fast.Close()
+
+	// a stalled download is aborted
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("some"))
+
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(limitSpeed(pr))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("stalled download: want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled download wasn't aborted")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+type listEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	Uid     int       `json:"uid"`
+	Gid     int       `json:"gid"`
+	Uname   string    `json:"uname,omitempty"`
+	Gname   string    `json:"gname,omitempty"`
+	Link    string    `json:"link,omitempty"`
+	Flags   []string  `json:"flags,omitempty"`
+}
+
+// listPrint prints an entry of an archive, with -list.
+func listPrint(e gofetch.Entry) error {
+	if !*asJSON {
+		// like tar -tv
+		mtime := "                "
+		if t := e.ModTime; !t.IsZero() {
+			mtime = t.Local().Format("2006-01-02 15:04")
+		}
+		name := e.Name
+		switch {
+		case e.Type == "hardlink":
+			name += " link to " + e.Link
+		case e.Link != "":
+			name += " -> " + e.Link
+		}
+		_, err := fmt.Printf("%s %10d %s %s\n", modeString(e), e.Size, mtime, name)
+		return err
+	}
+
+	mode := e.Mode
+	l := listEntry{
+		Name:    e.Name,
+		Type:    e.Type,
+		Size:    e.Size,
+		Mode:    fmt.Sprintf("%#o", mode.Perm()),
+		ModTime: e.ModTime,
+		Uid:     e.UID,
+		Gid:     e.GID,
+		Uname:   e.Uname,
+		Gname:   e.Gname,
+		Link:    e.Link,
+	}
+	if mode&os.ModeSetuid != 0 {
+		l.Flags = append(l.Flags, "setuid")
+	}
+	if mode&os.ModeSetgid != 0 {
+		l.Flags = append(l.Flags, "setgid")
+	}
+	if mode&os.ModeSticky != 0 {
+		l.Flags = append(l.Flags, "sticky")
+	}
+	if worldWritable(e) {
+		l.Flags = append(l.Flags, "world-writable")
+	}
+
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", buf)
+	return err
+}
+
+// modeString formats the mode of an entry like ls -l.
+func modeString(e gofetch.Entry) string {
+	mode := e.Mode
+	buf := []byte("?rwxrwxrwx")
+	switch e.Type {
+	case "hardlink":
+		buf[0] = 'h'
+	case "dir":
+		buf[0] = 'd'
+	case "file":
+		buf[0] = '-'
+	case "symlink":
+		buf[0] = 'l'
+	case "fifo":
+		buf[0] = 'p'
+	case "char":
+		buf[0] = 'c'
+	case "block":
+		buf[0] = 'b'
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			buf[i+1] = '-'
+		}
+	}
+	special := func(i int, set bool, c byte) {
+		if set {
+			if buf[i] == '-' {
+				c -= 'a' - 'A'
+			}
+			buf[i] = c
+		}
+	}
+	special(3, mode&os.ModeSetuid != 0, 's')
+	special(6, mode&os.ModeSetgid != 0, 's')
+	special(9, mode&os.ModeSticky != 0, 't')
+	return string(buf)
+}
+
+// worldWritable reports if an entry is writable by others.
+func worldWritable(e gofetch.Entry) bool {
+	return e.HasMode && e.Type != "symlink" && e.Mode&0002 != 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+func TestListArchive(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "bin/su", Mode: 04755, Size: 2, Uid: 0, Uname: "root"})
+	tw.Write([]byte("su"))
+	tw.WriteHeader(&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "su", Mode: 0777})
+	tw.WriteHeader(&tar.Header{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 01777, Uid: 1000, Gid: 100})
+	tw.Close()
+
+	defer func(old bool) { *asJSON = old }(*asJSON)
+	*asJSON = true
+
+	var err error
+	out := captureStdout(t, func() {
+		err = gofetch.New(gofetch.WithUnpack(gofetch.UnpackAuto)).Save(context.Background(), &buf, &gofetch.Target{List: listPrint})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []listEntry{
+		{Name: "bin/", Type: "dir", Mode: "0755"},
+		{Name: "bin/su", Type: "file", Size: 2, Mode: "0755", Uname: "root", Flags: []string{"setuid"}},
+		{Name: "bin/sh", Type: "symlink", Mode: "0777", Link: "su"},
+		{Name: "tmp/", Type: "dir", Mode: "0777", Uid: 1000, Gid: 100, Flags: []string{"sticky", "world-writable"}},
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d entries, want %d:\n%s", len(lines), len(want), out)
+	}
+	for i, line := range lines {
+		var got listEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		got.ModTime = want[i].ModTime
+		if g, w := jsonString(got), jsonString(want[i]); g != w {
+			t.Errorf("got %s, want %s", g, w)
+		}
+	}
+}
+
+func jsonString(v interface{}) string {
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
+
+func TestListArchive_text(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "bin/su", Mode: 04755, Size: 2})
+	tw.Write([]byte("su"))
+	tw.WriteHeader(&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "su", Mode: 0777})
+	tw.WriteHeader(&tar.Header{Name: "bin/ln", Typeflag: tar.TypeLink, Linkname: "bin/su", Mode: 0644})
+	tw.WriteHeader(&tar.Header{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 01777})
+	tw.WriteHeader(&tar.Header{Name: "run/", Typeflag: tar.TypeDir, Mode: 02750})
+	tw.Close()
+
+	defer func(old bool) { *asJSON = old }(*asJSON)
+	*asJSON = false
+
+	var err error
+	out := captureStdout(t, func() {
+		err = gofetch.New(gofetch.WithUnpack(gofetch.UnpackAuto)).Save(context.Background(), &buf, &gofetch.Target{List: listPrint})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ mode, size, name string }{
+		{"drwxr-xr-x", "0", "bin/"},
+		{"-rwsr-xr-x", "2", "bin/su"},
+		{"lrwxrwxrwx", "0", "bin/sh -> su"},
+		{"hrw-r--r--", "0", "bin/ln link to bin/su"},
+		{"drwxrwxrwt", "0", "tmp/"},
+		{"drwxr-s---", "0", "run/"},
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d entries, want %d:\n%s", len(lines), len(want), out)
+	}
+	for i, line := range lines {
+		w := want[i]
+		if f := strings.Fields(line); len(f) < 2 || f[0] != w.mode || f[1] != w.size || !strings.HasSuffix(line, " "+w.name) {
+			t.Errorf("got %q, want %s %s ... %s", line, w.mode, w.size, w.name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var errLocked = errors.New("locked")
+
+// lockPath takes an advisory lock on path, to keep concurrent runs
+// from writing it at the same time, and returns a function to release it.
+//
+// The lock is a file next to path, which is removed when released.
+func lockPath(path string) (unlock func(), err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, base := filepath.Split(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, partPrefix+base+lockSuffix)
+
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+
+		err = lockFile(f, false)
+		if err == errLocked {
+			if *noWait {
+				f.Close()
+				return nil, fmt.Errorf("%s is being written by another go-fetch", path)
+			}
+			log.Printf("waiting for another go-fetch writing %s", path)
+			err = lockFile(f, true)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// the previous owner may have removed the file we locked
+		fi, ferr := f.Stat()
+		ni, nerr := os.Stat(name)
+		if ferr == nil && nerr == nil && os.SameFile(fi, ni) {
+			return func() {
+				os.Remove(name)
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		unlockFile(f)
+		f.Close()
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "os"
+
+// As a fallback, files aren't locked.
+
+func lockFile(f *os.File, wait bool) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
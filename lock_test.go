@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockPath(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "sub", "file")
+	lock := filepath.Join(filepath.Dir(target), partPrefix+"file"+lockSuffix)
+
+	unlock, err := lockPath(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old bool) { *noWait = old }(*noWait)
+	*noWait = true
+	if _, err := lockPath(target); err == nil {
+		t.Error("-no-wait: want error while locked")
+	}
+
+	*noWait = false
+	done := make(chan func())
+	go func() {
+		unlock, err := lockPath(target)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- unlock
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("got the lock while locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+
+	select {
+	case unlock := <-done:
+		if unlock != nil {
+			unlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting for the lock timed out")
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// lockMain migrates the digests of an artifacts document (a lockfile),
+// moving single digests into a digests object, and adding, dropping
+// or preferring algorithms.
+//
+// New digests are computed from targets already on disk, after verifying
+// them against an existing digest, so nothing needs to be downloaded again.
+func lockMain(args []string) {
+	flags := flag.NewFlagSet("lock", flag.ExitOnError)
+	add := flags.String("add", "", "add digests with `algorithm`, computed from existing targets")
+	drop := flags.String("drop", "", "remove digests with `algorithm`")
+	prefer := flags.String("prefer", "", "verify with `algorithm`, when artifacts have it")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "go-fetch lock [flags] <file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	name := flags.Arg(0)
+	for _, algo := range []string{*add, *prefer} {
+		if algo != "" && gofetch.NewHash(algo) == nil {
+			log.Fatalf("unsupported digest algorithm %q", algo)
+		}
+	}
+
+	doc, err := decodeArtifacts(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *prefer != "" {
+		doc.Prefer = *prefer
+	}
+
+	var failed int
+	for i := range doc.Artifacts {
+		a := &doc.Artifacts[i]
+		a.Digests, a.Digest = a.digests(), ""
+
+		if *add != "" && a.Digests[*add] == "" {
+			if err := addDigest(a, *add, doc.Prefer); err != nil {
+				log.Printf("%s: %v", a.URL, err)
+				failed++
+			}
+		}
+		if *drop != "" && a.Digests[*drop] != "" {
+			if len(a.Digests) == 1 {
+				log.Printf("%s: not dropping its only digest", a.URL)
+				failed++
+			} else {
+				delete(a.Digests, *drop)
+			}
+		}
+		if len(a.Digests) == 0 {
+			a.Digests = nil
+		}
+	}
+
+	buf, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf = append(buf, '\n')
+	if name == "-" {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		err = ioutil.WriteFile(name, buf, 0666)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d artifacts not updated", failed, len(doc.Artifacts))
+	}
+}
+
+// addDigest computes a digest for an artifact from its target file,
+// which must match one of the digests it already has.
+func addDigest(a *artifact, algo, prefer string) error {
+	have, err := a.digest(prefer)
+	if err != nil {
+		return err
+	}
+	if have == "" {
+		return errors.New("no digest to verify the target with")
+	}
+	if a.Unpack != nil && *a.Unpack {
+		return errors.New("target is unpacked; download it to add a digest")
+	}
+
+	f, err := os.Open(expand(a.Target))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := gofetch.NewVerifier(f, have)
+	if err != nil {
+		return err
+	}
+	h := gofetch.NewHash(algo)
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	a.Digests[algo] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLockMain(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool")
+	ioutil.WriteFile(target, []byte("tool"), 0666)
+
+	sha256sum := fmt.Sprintf("%x", sha256.Sum256([]byte("tool")))
+	sha512sum := fmt.Sprintf("%x", sha512.Sum512([]byte("tool")))
+
+	name := filepath.Join(dir, "artifacts.json")
+	doc := fmt.Sprintf(`[{"url": "https://host/tool", "target": %q, "digest": %q}]`, target, sha256sum)
+	ioutil.WriteFile(name, []byte(doc), 0666)
+
+	defer log.SetFlags(log.Flags())
+	lockMain([]string{"-add", "sha512", "-prefer", "sha512", name})
+
+	got, err := decodeArtifacts(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"sha256": sha256sum, "sha512": sha512sum}
+	if got.Prefer != "sha512" || len(got.Artifacts) != 1 || got.Artifacts[0].Digest != "" ||
+		!reflect.DeepEqual(got.Artifacts[0].Digests, want) {
+		buf, _ := json.Marshal(got)
+		t.Fatalf("lock -add sha512 wrote %s", buf)
+	}
+
+	lockMain([]string{"-drop", "sha256", name})
+	got, err = decodeArtifacts(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"sha512": sha512sum}; !reflect.DeepEqual(got.Artifacts[0].Digests, want) {
+		t.Errorf("lock -drop sha256 wrote %v", got.Artifacts[0].Digests)
+	}
+}
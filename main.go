@@ -1,11 +1,7 @@
 package main
 
 import (
-	"archive/tar"
-	"bufio"
-	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -13,267 +9,370 @@ import (
 	"log"
 	"mime"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/krolaw/zipstream"
+	gofetch "github.com/ncruces/go-fetch/fetch"
 )
 
 var (
-	unpack = flag.Bool("unpack", false, "unpack downloaded file")
-	source string
-	target string
-)
-
-var (
-	stdout      bool
-	targetIsDir bool
-	targetName  string
+	unpack          = flag.Bool("unpack", false, "unpack downloaded file")
+	manifest        = flag.String("manifest", "", "write a JSON manifest of the files created to `file` (- for stdout), a line per download")
+	cleanTarget     = flag.Bool("clean", false, "remove the contents of the target directory before unpacking to it")
+	symlinks        = flag.String("symlinks", "keep", "when unpacking symlinks, `policy`: keep, skip, deref (copy the target) or rewrite (absolute targets to the target directory)")
+	overwrite       = flag.String("overwrite", "always", "when unpacking over existing files, `policy`: always, never, newer or error")
+	portableNames   = flag.String("portable-names", "keep", "when unpacking names reserved on Windows, or that differ only in case, `policy`: keep, rename, skip or error")
+	zipEncoding     = flag.String("zip-encoding", "", "decode zip entry names not flagged as UTF-8 with `charset` (default CP437, unless valid UTF-8)")
+	zipPassword     = flag.String("zip-password", "", "decrypt zip entries with `password` (- to prompt for it)")
+	forceFormat     = flag.String("format", "", "unpack as `format` (tar, zip, gz, bz2, zst, tar.gz, tgz, … or raw), rather than detecting it")
+	entry           = flag.String("entry", "", "extract only the file at `path` in the archive to the target")
+	stripComponents = flag.Int("strip-components", 0, "strip `n` leading path elements from archive entries")
+	stripTop        = flag.Bool("strip-top", false, "strip the top directory of archives, if all entries are in one")
+	unpackDepth     = flag.Int("unpack-depth", 0, "also unpack archives nested up to `n` levels deep")
+	rangedZip       = flag.Bool("ranged", false, "read remote zips with range requests, downloading only the entries to unpack or list")
+	decompressJobs  = flag.Int("j", 0, "decompress gzip with `n` goroutines: blocked gzip (BGZF) members in parallel, other streams overlapped with reading and unpacking (0 to disable)")
+	extractWorkers  = flag.Int("workers", 4, "write unpacked files on `n` goroutines (1 to write them in order)")
+	keepEncoding    = flag.Bool("keep-encoding", false, "save the bytes sent with a Content-Encoding (e.g. gzip, br, zstd) as is, rather than decoding them")
+	raw             = flag.Bool("raw", false, "write exactly the bytes received to exactly the target path")
+	patchFrom       = flag.String("patch", "", "apply the download, a bsdiff or VCDIFF (xdelta3) patch, to `file`")
+	method          = flag.String("method", "", "request downloads with HTTP `method` (default GET, or POST with -data)")
+	data            = flag.String("data", "", "send `data` (or @file, @- for stdin) in the body of download requests")
+	cookieJarFile   = flag.String("cookie-jar", "", "read cookies from, and save those received to, Netscape (curl) format `file`")
+	cache           = flag.String("cache", "", "cache downloads in `dir` (\"off\" to disable)")
+	link            = flag.Bool("link", false, "hard link downloaded files to the cache, to store them once")
+	offline         = flag.Bool("offline", false, "serve downloads only from the cache, without network access")
+	history         = flag.String("history", "", "record downloads in history `file` (\"off\" to disable)")
+	sha256sum       = flag.String("sha256", "", "expected SHA-256 `digest` of the download, in hex")
+	newerThanTarget = flag.Bool("newer-than-target", false, "only download if the source was modified after the target")
+	remoteTime      = flag.Bool("remote-time", false, "set the modification time of downloaded files from the server")
+	verifyExisting  = flag.Bool("verify-only-if-exists", true, "skip the download if the target file exists and matches the expected digest")
+	fsync           = flag.Bool("fsync", false, "flush downloaded files to disk before exiting")
+	noWait          = flag.Bool("no-wait", false, "fail, instead of waiting, if another go-fetch is writing the target")
+	configFile      = flag.String("config", "", "read defaults from config `file` (\"off\" to disable)")
+
+	ipfsGateway    = flag.String("ipfs-gateway", "", "fetch ipfs:// urls from the trustless gateway at `url` (default $IPFS_GATEWAY, or "+defaultIPFSGateway+")")
+	proxy          = flag.String("proxy", "", "use the http, https or socks5 proxy at `url` (may include user:password)")
+	connectTimeout = flag.Duration("connect-timeout", 0, "give up connecting after `duration` (default 30s)")
+	retries        = flag.Int("retry", 3, "retry up to `N` times when the server asks to retry later")
+	retryOn        = flag.String("retry-on", "429,503", "retry on these `statuses` (comma separated codes, or ranges like 5xx)")
+	maxTime        = flag.Duration("max-time", 0, "give up on each request after `duration`, including the download")
+	ipv4           = flag.Bool("4", false, "connect only to IPv4 addresses")
+	ipv6           = flag.Bool("6", false, "connect only to IPv6 addresses")
+	dnsResolver    = flag.String("dns-resolver", "", "resolve names with the DNS server at `host[:port]`")
+	doh            = flag.String("doh", "", "resolve names with the DNS-over-HTTPS server at `url`")
+	unixSocket     = flag.String("unix", "", "connect through the Unix domain `socket`, instead of to the url host")
+	cacert         = flag.String("cacert", "", "trust the CA certificates in PEM `file`, in addition to the system's")
+	insecure       = flag.Bool("insecure", false, "skip TLS certificate verification (dangerous)")
+	cert           = flag.String("cert", "", "authenticate with the client certificate in PEM or PKCS#12 (.p12, .pfx) `file`")
+	key            = flag.String("key", "", "private key PEM `file` for -cert, if not in the same file")
+
+	certPassword = flag.String("cert-password", "", "`password` of the -cert PKCS#12 file")
+	pin          = flag.String("pin", "", "require a server certificate whose public key `hash` is sha256//BASE64 (; separated)")
+
+	artifacts = flag.String("artifacts", "", "download the artifacts described by a JSON `file` (- for stdin)")
+	inputFile = flag.String("input-file", "", "download the urls listed in `file` (- for stdin), a line each, with an optional target and digest")
+	parallel  = flag.Int("parallel", 4, "run up to `n` downloads at once, of -artifacts, -input-file, or several urls")
+
+	mirror     = flag.Bool("mirror", false, "save downloads under the target directory as host/path/to/file")
+	noHostDirs = flag.Bool("no-host-dirs", false, "with -mirror, don't create host directories")
+	cutDirs    = flag.Int("cut-dirs", 0, "with -mirror, ignore `N` leading directories of the url path")
+
+	trailerFile = flag.String("trailer", "", "save data appended to a compressed stream (e.g. a signature) to `file`")
+
+	plan         = flag.String("plan", "", "print the resolved requests in `format` (json), without downloading")
+	headOnly     = flag.Bool("head", false, "print the status, final url, size, type, ETag and Last-Modified of downloads, without writing anything")
+	showProgress = flag.Bool("progress", false, "report download progress to stderr")
+
+	list   = flag.Bool("list", false, "list archive contents, without extracting")
+	asJSON = flag.Bool("json", false, "list archive contents, or print -head, as JSON")
+
+	sameOwner    = flag.Bool("same-owner", false, "when running as root, extract files with the owner recorded in the archive")
+	numericOwner = flag.Bool("numeric-owner", false, "with -same-owner, use the archive's numeric ids, rather than user and group names")
+	specialFiles = flag.Bool("special-files", false, "extract FIFOs, and devices when running as root")
+	xattrs       = flag.Bool("xattrs", false, "extract extended attributes recorded in tar archives")
+	owner        = flag.String("owner", "", "extract files owned by `user` (name or id)")
+	group        = flag.String("group", "", "extract files owned by `group` (name or id)")
+
+	denySetuid         = flag.Bool("deny-setuid", false, "refuse archives containing setuid/setgid files")
+	denyWorldWritable  = flag.Bool("deny-world-writable", false, "refuse archives containing world-writable files")
+	preserveSuid       = flag.Bool("preserve-suid", false, "extract files with their setuid, setgid and sticky bits")
+	stripSetuid        = flag.Bool("strip-setuid", false, "extract files without setuid/setgid bits, even with -preserve-suid")
+	stripWorldWritable = flag.Bool("strip-world-writable", false, "extract files without world-writable permissions")
 )
 
 func usage() {
 	fmt.Fprint(flag.CommandLine.Output(), "go-fetch [flags] <url> <target>\n")
+	fmt.Fprint(flag.CommandLine.Output(), "go-fetch [flags] <url>... <dir>/\n")
+	fmt.Fprint(flag.CommandLine.Output(), "go-fetch -list [flags] <url>\n")
+	fmt.Fprint(flag.CommandLine.Output(), "go-fetch -head [flags] <url>\n")
+	fmt.Fprint(flag.CommandLine.Output(), "go-fetch -artifacts <file> [flags]\n")
+	fmt.Fprint(flag.CommandLine.Output(), "go-fetch -input-file <file> [flags] [target]\n")
+	fmt.Fprint(flag.CommandLine.Output(), "\nUse - as the url to read from stdin, or as the target to write to stdout.\n")
+	fmt.Fprint(flag.CommandLine.Output(), "Flags, url and target can also be set with GO_FETCH_<NAME> environment variables.\n")
 	flag.PrintDefaults()
 }
 
 func main() {
-	// parse command line args
-	flag.Usage = usage
-	flag.Parse()
-
-	if len(flag.Args()) < 2 {
-		usage()
-		os.Exit(2)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			historyMain(os.Args[2:])
+			return
+		case "clean":
+			cleanMain(os.Args[2:])
+			return
+		case "lock":
+			lockMain(os.Args[2:])
+			return
+		case "sum":
+			sumMain(os.Args[2:])
+			return
+		}
 	}
 
-	source = flag.Arg(0)
-	target = flag.Arg(1)
-	stdout = target == "-"
-
 	log.SetFlags(0)
+	flag.Usage = usage
+	parseFlags(os.Args[1:])
 
-	// is target a directory?
-	if !stdout {
-		if strings.HasSuffix(target, string(filepath.Separator)) {
-			targetIsDir = true
-		} else {
-			fi, _ := os.Stat(target)
-			targetIsDir = fi != nil && fi.IsDir()
+	if *artifacts != "" {
+		jobs, err := readArtifacts(*artifacts)
+		if err != nil {
+			log.Fatal(err)
 		}
+		runJobs(jobs)
+		return
 	}
-
-	// start download
-	res, err := http.Get(source)
-	if err != nil {
-		log.Fatal(err)
+	if *inputFile != "" {
+		// the target, if any, is the default
+		jobs, err := readInputFile(*inputFile, flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		runJobs(jobs)
+		return
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		log.Fatal("http error: ", res.Status)
+	if jobs := multipleJobs(flag.Args()); jobs != nil {
+		runJobs(jobs)
+		return
 	}
 
-	// target file name
-	if targetIsDir {
-		// use content disposition
-		if disp := res.Header.Get("Content-Disposition"); disp != "" {
-			if _, params, err := mime.ParseMediaType(disp); err != nil {
-				targetName = params["filename"]
-			}
-		}
-
-		// use the base name of the final URL, if it has an extension
-		if targetName == "" {
-			targetName = path.Base(res.Request.URL.Path)
-		}
-
-		// use the base name of the source url, since it's more predictable
-		if len(path.Ext(targetName)) <= 1 {
-			u, _ := url.Parse(source)
-			targetName = path.Base(u.Path)
-		}
+	source := os.Getenv(envName("url"))
+	target := os.Getenv(envName("target"))
+	if flag.NArg() > 0 {
+		source = flag.Arg(0)
+	}
+	if flag.NArg() > 1 {
+		target = flag.Arg(1)
+	}
+	if source == "" || target == "" && !*list && !*headOnly {
+		usage()
+		os.Exit(2)
 	}
 
-	if *unpack {
-		err = uncompress(bufio.NewReader(res.Body))
-	} else {
-		err = write(res.Body, targetFile())
+	err := newJob(source, target).run()
+	if serr := saveCookieJar(); err == nil {
+		err = serr
 	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func targetFile() *os.File {
-	if stdout {
-		return os.Stdout
+// parseFlags parses the environment, then the command line args,
+// checks the flags, reads the config file, and configures the client,
+// for the main command, and subcommands that download.
+func parseFlags(args []string) {
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if err := flag.Set(f.Name, v); err != nil {
+				log.Fatalf("invalid value %q for %s: %v", v, name, err)
+			}
+		}
+	})
+	flag.CommandLine.Parse(args)
+	if *plan != "" && *plan != "json" {
+		log.Fatalf("unsupported -plan format: %q", *plan)
 	}
-
-	path := target
-	if targetIsDir {
-		name := filepath.FromSlash(targetName)
-		if strings.ContainsRune(name, filepath.Separator) {
-			log.Fatalf("illegal file path: %q", targetName)
+	switch *symlinks {
+	case "keep", "skip", "deref", "rewrite":
+	default:
+		log.Fatalf("invalid -symlinks policy: %q", *symlinks)
+	}
+	switch *overwrite {
+	case "always", "never", "newer", "error":
+	default:
+		log.Fatalf("invalid -overwrite policy: %q", *overwrite)
+	}
+	switch *portableNames {
+	case "keep", "rename", "skip", "error":
+	default:
+		log.Fatalf("invalid -portable-names policy: %q", *portableNames)
+	}
+	if *forceFormat != "" {
+		f, err := gofetch.ParseFormat(*forceFormat)
+		if err != nil {
+			log.Fatal(err)
 		}
-		path = filepath.Join(path, name)
+		*forceFormat = f
 	}
-
-	path, err := filepath.Abs(path)
-	if err != nil {
+	if err := resolveZipEncoding(); err != nil {
 		log.Fatal(err)
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+	if err := resolveZipPassword(); err != nil {
 		log.Fatal(err)
 	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
+	if err := resolveOwner(); err != nil {
 		log.Fatal(err)
 	}
-	return f
-}
-
-func write(r io.Reader, w io.WriteCloser) error {
-	_, err := io.Copy(w, r)
-	if cerr := w.Close(); err == nil {
-		err = cerr
+	if *parallel < 1 {
+		log.Fatalf("invalid -parallel: %d", *parallel)
+	}
+	if err := resolveData(); err != nil {
+		log.Fatal(err)
 	}
-	return err
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	configureClient()
 }
 
-func uncompress(r *bufio.Reader) error {
-	magic, _ := r.Peek(264)
-
-	switch {
-	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
-		zr, err := gzip.NewReader(r)
-		if err != nil {
-			return err
+// multipleJobs makes a job for each url, given several,
+// all saved to the target directory, which comes last.
+// With -list or -head, there's no target.
+func multipleJobs(args []string) []*job {
+	target := ""
+	if !*list && !*headOnly {
+		if len(args) < 3 {
+			return nil
 		}
-		defer zr.Close()
-
-		if zr.Name != "" {
-			targetName = zr.Name
-		} else {
-			targetName = strings.TrimSuffix(targetName, ".gz")
+		target, args = args[len(args)-1], args[:len(args)-1]
+		fi, _ := os.Stat(target)
+		if !strings.HasSuffix(target, string(filepath.Separator)) && (fi == nil || !fi.IsDir()) {
+			log.Fatalf("with several urls, the target must be a directory: %q", target)
 		}
-
-		return uncompress(bufio.NewReader(zr))
-
-	case bytes.HasPrefix(magic, []byte("BZh")):
-		targetName = strings.TrimSuffix(targetName, ".bz2")
-		br := bzip2.NewReader(r)
-		return uncompress(bufio.NewReader(br))
-
-	case !stdout && bytes.HasPrefix(magic, []byte("PK")):
-		return unarchive(zipstream.NewReader(r), target)
-
-	case !stdout && len(magic) > 257 && bytes.HasPrefix(magic[257:], []byte("ustar")):
-		return unarchive(tar.NewReader(r), target)
-
-	default:
-		return write(r, targetFile())
+	} else if len(args) < 2 {
+		return nil
 	}
-}
 
-func unarchive(r io.Reader, dir string) error {
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return err
+	var jobs []*job
+	for _, source := range args {
+		jobs = append(jobs, newJob(source, target))
 	}
-	dir += string(filepath.Separator)
-
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		return err
-	}
-
-	for {
-		name, fi, err := unarchiveNext(r)
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+	return jobs
+}
 
-		path := filepath.Join(dir, filepath.FromSlash(name))
-		if !strings.HasPrefix(path, dir) {
-			return fmt.Errorf("illegal file path %q", name)
+// isFlagSet reports whether a flag was set,
+// on the command line or in the environment.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
 
-		switch mode := fi.Mode(); {
-		case mode.IsDir():
-			if err := os.MkdirAll(path, unarchivePerm(mode)); err != nil {
-				return err
-			}
-
-		case mode.IsRegular():
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
-			if err != nil {
-				return err
-			}
-
-			n, err := io.Copy(f, r)
-			if cerr := f.Close(); err == nil {
-				err = cerr
-			}
-			if err != nil {
-				return fmt.Errorf("error writing to %q: %w", name, err)
-			}
-			if size := fi.Size(); n != size {
-				return fmt.Errorf("wrote %d bytes to %q; expected %d", n, name, size)
-			}
-
-			if time := fi.ModTime(); !time.IsZero() {
-				_ = os.Chtimes(path, time, time)
-			}
+// envName is the environment variable for a flag.
+func envName(flag string) string {
+	return "GO_FETCH_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
 
-		case mode&os.ModeSymlink != 0:
-			old, err := ioutil.ReadAll(r)
-			if err != nil {
-				return err
-			}
+// meta describes the contents of a source.
+type meta struct {
+	name        string         // suggested file name
+	contentType string         // media type, without parameters
+	res         *http.Response // if fetched over HTTP
+}
 
-			err = os.Symlink(string(old), path)
-			if err != nil {
-				return err
-			}
+func responseMeta(res *http.Response, name string) *meta {
+	typ, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return &meta{name: name, contentType: typ, res: res}
+}
 
-		default:
-			return fmt.Errorf("archive contained unsupported file %q of type %v", name, mode)
-		}
+// errNotModified is returned by fetch when a conditional request
+// finds the source unchanged.
+var errNotModified = errors.New("not modified")
+
+// fetch opens source for reading,
+// and describes its contents.
+// For HTTP sources, header is added to the request.
+func fetch(source string, header http.Header) (io.ReadCloser, *meta, error) {
+	if source == "-" {
+		// stdin has no name
+		return ioutil.NopCloser(os.Stdin), &meta{name: "stdin"}, nil
+	}
+	if strings.HasPrefix(source, "data:") {
+		return fetchData(source)
+	}
+	if strings.HasPrefix(source, "oci://") {
+		return fetchOCI(source)
+	}
+	if strings.HasPrefix(source, "git::") {
+		return fetchGit(source)
+	}
+	if strings.HasPrefix(source, "github://") {
+		return fetchGitHub(source)
+	}
+	if strings.HasPrefix(source, "gitlab://") {
+		return fetchGitLab(source)
+	}
+	if strings.HasPrefix(source, "gitlab+package://") {
+		return fetchGitLabPackage(source)
+	}
+	if strings.HasPrefix(source, "metalink::") {
+		return fetchMetalink(source)
+	}
+	if strings.HasPrefix(source, "zsync::") {
+		return fetchZsync(source, "", false)
+	}
+	if strings.HasPrefix(source, "ipfs://") {
+		return fetchIPFS(source)
+	}
+	if strings.HasPrefix(source, "torrent::") || strings.HasPrefix(source, "magnet:") {
+		return fetchTorrent(source)
 	}
-}
 
-func unarchivePerm(mode os.FileMode) os.FileMode {
-	if mode&0007 != 0 {
-		mode |= 0001
+	req, err := newPayloadRequest(source)
+	if err != nil {
+		return nil, nil, err
 	}
-	if mode&0070 != 0 {
-		mode |= 0010
+	addCookies(req.URL)
+	for k, v := range header {
+		req.Header[k] = v
 	}
-	return mode | 0300
-}
+	req.Header.Set("Want-Content-Digest", wantDigest)
+	req.Header.Set("Want-Repr-Digest", wantDigest)
 
-func unarchiveNext(a io.Reader) (string, os.FileInfo, error) {
-	switch v := a.(type) {
-	case *tar.Reader:
-		h, err := v.Next()
-		if err != nil {
-			return "", nil, err
-		}
-		return h.Name, h.FileInfo(), nil
+	res, err := send(withEarlyHints(req), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode == http.StatusNotModified && header != nil {
+		res.Body.Close()
+		return nil, nil, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, nil, errors.New("http error: " + res.Status)
+	}
 
-	case *zipstream.Reader:
-		h, err := v.Next()
-		if err != nil {
-			return "", nil, err
+	// opportunistically verify the digest the server sent
+	var body io.ReadCloser = res.Body
+	if digest := responseDigest(res); digest != "" {
+		if body, err = gofetch.NewVerifier(body, digest); err != nil {
+			res.Body.Close()
+			return nil, nil, err
 		}
-		return h.Name, h.FileInfo(), nil
-
-	default:
-		panic(fmt.Sprintf("unarchive: unknown type %T", v))
 	}
+
+	name := fetcher.Name(source, res)
+	return body, responseMeta(res, name), nil
 }
+
+// fetcher suggests file names for HTTP downloads.
+// Embedders can substitute their own naming rules with fetch.WithName.
+var fetcher = gofetch.New()
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+func TestMain(m *testing.M) {
+	// don't fill the user's cache
+	*cache = "off"
+	os.Exit(m.Run())
+}
+
+func TestFetch_stdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *os.File) { os.Stdin = old }(os.Stdin)
+	os.Stdin = r
+
+	go func() {
+		w.WriteString("piped")
+		w.Close()
+	}()
+
+	body, m, err := fetch("-", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "piped" || m.name != "stdin" {
+		t.Errorf("fetch(-) = %q, %q", got, m.name)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"url":                   "GO_FETCH_URL",
+		"sha256":                "GO_FETCH_SHA256",
+		"verify-only-if-exists": "GO_FETCH_VERIFY_ONLY_IF_EXISTS",
+	}
+	for flag, want := range tests {
+		if got := envName(flag); got != want {
+			t.Errorf("envName(%q) = %q, want %q", flag, got, want)
+		}
+	}
+}
+
+func TestFetch_resolveName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest":
+			http.Redirect(w, r, "/files/tool.tar.gz", http.StatusFound)
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename="tool.zip"; filename*=UTF-8''t%C3%B6%C3%B6l.zip`)
+		}
+	}))
+	defer srv.Close()
+
+	tests := map[string]string{
+		"/latest":          "tool.tar.gz",
+		"/files/other.zip": "other.zip",
+		"/":                "index.html",
+		"/download":        "tööl.zip",
+	}
+	for path, want := range tests {
+		body, m, err := fetch(srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Close()
+		if m.name != want {
+			t.Errorf("fetch(%q) named %q, want %q", path, m.name, want)
+		}
+	}
+
+	defer func(old *gofetch.Fetcher) { fetcher = old }(fetcher)
+	fetcher = gofetch.New(gofetch.WithName(func(source string, res *http.Response) string {
+		return "custom-" + gofetch.DefaultName(source, res)
+	}))
+	body, m, err := fetch(srv.URL+"/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if m.name != "custom-tool.tar.gz" {
+		t.Errorf("fetch() with hook named %q", m.name)
+	}
+}
+
+func TestMultipleJobs(t *testing.T) {
+	defer func(old bool) { *list = old }(*list)
+	dir := t.TempDir()
+
+	tests := []struct {
+		list    bool
+		args    []string
+		sources []string
+		target  string
+	}{
+		{args: []string{"https://host/a", "out"}},
+		{args: []string{"https://host/a", "https://host/b", dir}, sources: []string{"https://host/a", "https://host/b"}, target: dir},
+		{args: []string{"https://host/a", "https://host/b", "new" + string(filepath.Separator)},
+			sources: []string{"https://host/a", "https://host/b"}, target: "new" + string(filepath.Separator)},
+		{list: true, args: []string{"https://host/a"}},
+		{list: true, args: []string{"https://host/a", "https://host/b"}, sources: []string{"https://host/a", "https://host/b"}},
+	}
+	for _, tt := range tests {
+		*list = tt.list
+		jobs := multipleJobs(tt.args)
+		var sources []string
+		for _, j := range jobs {
+			sources = append(sources, j.source)
+			if j.target != tt.target {
+				t.Errorf("multipleJobs(%q): target %q, want %q", tt.args, j.target, tt.target)
+			}
+		}
+		if !reflect.DeepEqual(sources, tt.sources) {
+			t.Errorf("multipleJobs(%q) = %q, want %q", tt.args, sources, tt.sources)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type manifestDoc struct {
+	Source string          `json:"source"`
+	Target string          `json:"target"`
+	Files  []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path    string    `json:"path"` // relative to the target, slash separated
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Link    string    `json:"link,omitempty"`
+}
+
+// writeManifest describes the files a job created, as they are on disk.
+func (j *job) writeManifest() error {
+	doc := manifestDoc{Source: j.source, Target: j.destination, Files: []manifestEntry{}}
+	base := j.destination
+	if fi, err := os.Stat(base); err == nil && !fi.IsDir() {
+		base = filepath.Dir(base)
+	}
+
+	sort.Strings(j.created)
+	for _, p := range j.created {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+
+		e := manifestEntry{
+			Path:    filepath.ToSlash(rel),
+			Type:    "other",
+			Mode:    fmt.Sprintf("%#o", fi.Mode().Perm()),
+			ModTime: fi.ModTime().UTC(),
+		}
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			e.Type = "dir"
+		case mode.IsRegular():
+			e.Type, e.Size = "file", fi.Size()
+			if e.SHA256, err = fileSHA256(p); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			e.Type = "symlink"
+			if e.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		doc.Files = append(doc.Files, e)
+	}
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return manifestOut.write(append(buf, '\n'))
+}
+
+// manifestOut is the -manifest file, created when the first job is
+// done; each job writes it a line.
+var manifestOut manifestWriter
+
+type manifestWriter struct {
+	sync.Mutex
+	f *os.File
+}
+
+func (m *manifestWriter) write(line []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.f == nil {
+		if *manifest == "-" {
+			m.f = os.Stdout
+		} else {
+			f, err := os.Create(*manifest)
+			if err != nil {
+				return err
+			}
+			m.f = f
+		}
+	}
+	_, err := m.f.Write(line)
+	return err
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJob_manifest(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Mode: 0755, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "bin/link", Typeflag: tar.TypeSymlink, Linkname: "tool", Mode: 0777})
+	tw.Close()
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	defer func(old, m string) { *history, *manifest = old, m }(*history, *manifest)
+	*history, *manifest = "off", filepath.Join(dir, "manifest.json")
+	defer func() {
+		manifestOut.f.Close()
+		manifestOut.f = nil
+	}()
+
+	j := newJob(srv.URL+"/tool.tgz", filepath.Join(dir, "out")+string(filepath.Separator))
+	j.unpack = true
+	if err := j.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(*manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc manifestDoc
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var got []manifestEntry
+	for _, e := range doc.Files {
+		got = append(got, manifestEntry{Path: e.Path, Type: e.Type, Size: e.Size, SHA256: e.SHA256, Link: e.Link})
+	}
+	want := []manifestEntry{
+		{Path: "bin", Type: "dir"},
+		{Path: "bin/link", Type: "symlink", Link: "tool"},
+		{Path: "bin/tool", Type: "file", Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+	if doc.Source != j.source || !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %s", out)
+	}
+}
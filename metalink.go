@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// metalinkDoc is a Metalink document: version 4 (RFC 5854, .meta4),
+// or version 3 (.metalink), which nests its elements differently.
+type metalinkDoc struct {
+	Files  []metalinkFile `xml:"file"`
+	Files3 []metalinkFile `xml:"files>file"`
+}
+
+type metalinkFile struct {
+	Name    string         `xml:"name,attr"`
+	Size    int64          `xml:"size"`
+	Hashes  []metalinkHash `xml:"hash"`
+	Hashes3 []metalinkHash `xml:"verification>hash"`
+	URLs    []metalinkURL  `xml:"url"`
+	URLs3   []metalinkURL  `xml:"resources>url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"` // like sha-256, or sha256 in version 3
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority   int    `xml:"priority,attr"`   // 1 is best, in version 4
+	Preference int    `xml:"preference,attr"` // 100 is best, in version 3
+	URL        string `xml:",chardata"`
+}
+
+// Up to mirrorProbes of the preferred mirrors are raced,
+// for up to mirrorTimeout, to find the fastest.
+const (
+	mirrorProbes  = 4
+	mirrorTimeout = 5 * time.Second
+)
+
+// fetchMetalink downloads a file described by a Metalink document,
+// referenced as metalink::url[#pattern], where url can also be a local file.
+//
+// The pattern is a glob that picks the file, if the document lists several.
+// The file is downloaded from the fastest mirror, failing over to the others,
+// and verified against its size, and strongest hash.
+func fetchMetalink(source string) (io.ReadCloser, *meta, error) {
+	loc := strings.TrimPrefix(source, "metalink::")
+	pattern := ""
+	if i := strings.IndexByte(loc, '#'); i >= 0 {
+		loc, pattern = loc[:i], loc[i+1:]
+	}
+
+	doc, err := readMetalink(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := selectMetalinkFile(append(doc.Files, doc.Files3...), pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("metalink %s: %w", loc, err)
+	}
+
+	name := gofetch.SanitizeName(file.Name)
+	digest := metalinkDigest(file)
+	mirrors := fastestMirrors(metalinkMirrors(file))
+	if len(mirrors) == 0 {
+		return nil, nil, fmt.Errorf("metalink %s: no http mirrors for %s", loc, file.Name)
+	}
+
+	// on failure, try the next mirror
+	for i, url := range mirrors {
+		var res *http.Response
+		res, err = getMirror(url)
+		var p *plannedRequest
+		if errors.As(err, &p) {
+			p.name, p.digest = name, digest
+		}
+		if err == nil {
+			var body io.ReadCloser = res.Body
+			if file.Size > 0 {
+				body = &sizeChecker{ReadCloser: body, size: file.Size}
+			}
+			if digest != "" {
+				if body, err = gofetch.NewVerifier(body, digest); err != nil {
+					res.Body.Close()
+					return nil, nil, err
+				}
+			}
+			return body, responseMeta(res, name), nil
+		}
+		var h *headResponse
+		if p != nil || errors.As(err, &h) || i+1 == len(mirrors) {
+			break
+		}
+		log.Printf("mirror %s: %v; trying %s", url, err, mirrors[i+1])
+	}
+	return nil, nil, err
+}
+
+func readMetalink(loc string) (*metalinkDoc, error) {
+	var buf []byte
+	var err error
+	if strings.Contains(loc, "://") {
+		var req *http.Request
+		var res *http.Response
+		req, err = http.NewRequest(http.MethodGet, loc, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/metalink4+xml, application/metalink+xml")
+		if res, err = send(req, false); err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.New("http error: " + res.Status)
+		}
+		buf, err = ioutil.ReadAll(io.LimitReader(res.Body, 16<<20))
+	} else {
+		buf, err = ioutil.ReadFile(loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var doc metalinkDoc
+	if err := xml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("reading metalink %s: %w", loc, err)
+	}
+	return &doc, nil
+}
+
+// selectMetalinkFile finds the one file that matches pattern.
+func selectMetalinkFile(files []metalinkFile, pattern string) (*metalinkFile, error) {
+	var found []*metalinkFile
+	for i, f := range files {
+		if pattern == "" {
+			found = append(found, &files[i])
+		} else if ok, err := path.Match(pattern, f.Name); err != nil {
+			return nil, err
+		} else if ok {
+			found = append(found, &files[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no file matches %q", pattern)
+	case 1:
+		return found[0], nil
+	default:
+		var names []string
+		for _, f := range found {
+			names = append(names, f.Name)
+		}
+		return nil, fmt.Errorf("%d files match %q: %s", len(found), pattern, strings.Join(names, ", "))
+	}
+}
+
+// metalinkDigest picks the strongest supported hash of a file,
+// in algorithm:hex form.
+func metalinkDigest(f *metalinkFile) string {
+	sums := map[string]string{}
+	for _, h := range append(f.Hashes, f.Hashes3...) {
+		algo := strings.Replace(strings.ToLower(h.Type), "-", "", -1)
+		sums[algo] = strings.ToLower(strings.TrimSpace(h.Value))
+	}
+	for _, algo := range digestAlgorithms {
+		if sum, ok := sums[algo]; ok {
+			return algo + ":" + sum
+		}
+	}
+	return ""
+}
+
+// metalinkMirrors lists the http mirrors of a file, preferred first.
+func metalinkMirrors(f *metalinkFile) []string {
+	type mirror struct {
+		url  string
+		rank int // lower is better
+	}
+	var mirrors []mirror
+	for _, u := range append(f.URLs, f.URLs3...) {
+		url := strings.TrimSpace(u.URL)
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		rank := 1 << 20
+		switch {
+		case u.Priority > 0:
+			rank = u.Priority
+		case u.Preference > 0:
+			rank = 101 - u.Preference
+		}
+		mirrors = append(mirrors, mirror{url, rank})
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return mirrors[i].rank < mirrors[j].rank
+	})
+
+	urls := make([]string, len(mirrors))
+	for i, m := range mirrors {
+		urls[i] = m.url
+	}
+	return urls
+}
+
+// fastestMirrors races HEAD requests to the preferred mirrors,
+// and moves the first one to answer to the front.
+func fastestMirrors(mirrors []string) []string {
+	n := len(mirrors)
+	if n > mirrorProbes {
+		n = mirrorProbes
+	}
+	if n < 2 || *offline {
+		return mirrors
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	answers := make(chan int, n)
+	for i := range mirrors[:n] {
+		go func(i int) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, mirrors[i], nil)
+			if err == nil {
+				var res *http.Response
+				if res, err = client.Do(req); err == nil {
+					res.Body.Close()
+					if res.StatusCode != http.StatusOK {
+						err = errors.New(res.Status)
+					}
+				}
+			}
+			if err != nil {
+				i = -1
+			}
+			answers <- i
+		}(i)
+	}
+
+	for range mirrors[:n] {
+		if i := <-answers; i >= 0 {
+			fastest := append([]string{mirrors[i]}, mirrors[:i]...)
+			return append(fastest, mirrors[i+1:]...)
+		}
+	}
+	return mirrors
+}
+
+// getMirror requests url, failing on anything but 200 OK.
+func getMirror(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := send(req, true)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.New("http error: " + res.Status)
+	}
+	return res, nil
+}
+
+// sizeChecker fails at EOF, if it didn't read the expected size.
+type sizeChecker struct {
+	io.ReadCloser
+	size int64
+	read int64
+}
+
+func (s *sizeChecker) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.read += int64(n)
+	if s.read > s.size || err == io.EOF && s.read != s.size {
+		return n, fmt.Errorf("size mismatch: got %d bytes, expected %d", s.read, s.size)
+	}
+	return n, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMetalink(t *testing.T) {
+	v4 := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="tool.iso">
+    <size>4</size>
+    <hash type="sha-256">ABCD</hash>
+    <hash type="sha-512">ef01</hash>
+    <hash type="md5">0000</hash>
+    <url priority="2">https://second/tool.iso</url>
+    <url priority="1">https://first/tool.iso</url>
+    <url>ftp://ignored/tool.iso</url>
+    <url>http://last/tool.iso</url>
+  </file>
+  <file name="tool.iso.asc"/>
+</metalink>`
+	v3 := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="tool.iso">
+      <size>4</size>
+      <verification><hash type="sha256">abcd</hash></verification>
+      <resources>
+        <url type="http" preference="10">https://worse/tool.iso</url>
+        <url type="http" preference="90">https://better/tool.iso</url>
+      </resources>
+    </file>
+  </files>
+</metalink>`
+
+	tests := []struct {
+		name    string
+		doc     string
+		digest  string
+		mirrors []string
+	}{
+		{name: "v4", doc: v4, digest: "sha512:ef01",
+			mirrors: []string{"https://first/tool.iso", "https://second/tool.iso", "http://last/tool.iso"}},
+		{name: "v3", doc: v3, digest: "sha256:abcd",
+			mirrors: []string{"https://better/tool.iso", "https://worse/tool.iso"}},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "tool.meta4")
+		ioutil.WriteFile(file, []byte(tt.doc), 0666)
+
+		doc, err := readMetalink(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := selectMetalinkFile(append(doc.Files, doc.Files3...), "*.iso")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if f.Name != "tool.iso" || f.Size != 4 {
+			t.Errorf("%s: selected %q, size %d", tt.name, f.Name, f.Size)
+		}
+		if got := metalinkDigest(f); got != tt.digest {
+			t.Errorf("%s: digest %q, want %q", tt.name, got, tt.digest)
+		}
+		if got := metalinkMirrors(f); !reflect.DeepEqual(got, tt.mirrors) {
+			t.Errorf("%s: mirrors %q, want %q", tt.name, got, tt.mirrors)
+		}
+	}
+
+	files := []metalinkFile{{Name: "a.iso"}, {Name: "b.iso"}}
+	for _, pattern := range []string{"", "*.iso", "c.*", "["} {
+		if _, err := selectMetalinkFile(files, pattern); err == nil {
+			t.Errorf("selectMetalinkFile(%q): want error", pattern)
+		}
+	}
+}
+
+func TestFetchMetalink(t *testing.T) {
+	data := "tool"
+	sum := sha256.Sum256([]byte(data))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/down/"):
+			http.Error(w, "mirror down", http.StatusServiceUnavailable)
+		case strings.HasPrefix(r.URL.Path, "/short/"):
+			w.Write([]byte(data[:2]))
+		default:
+			w.Write([]byte(data))
+		}
+	}))
+	defer srv.Close()
+
+	metalink := func(digest string, mirrors ...string) string {
+		doc := `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="tool.bin"><size>4</size>`
+		doc += `<hash type="sha-256">` + digest + `</hash>`
+		for i, m := range mirrors {
+			doc += fmt.Sprintf(`<url priority="%d">%s/%s/tool.bin</url>`, i+1, srv.URL, m)
+		}
+		doc += `</file></metalink>`
+
+		file := filepath.Join(t.TempDir(), "tool.meta4")
+		ioutil.WriteFile(file, []byte(doc), 0666)
+		return "metalink::" + file
+	}
+
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("00", sha256.Size)
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{name: "failover", source: metalink(good, "down", "up")},
+		{name: "all down", source: metalink(good, "down"), wantErr: true},
+		{name: "wrong hash", source: metalink(bad, "up"), wantErr: true},
+		{name: "wrong size", source: metalink(good, "short"), wantErr: true},
+	}
+	for _, tt := range tests {
+		body, m, err := fetch(tt.source, nil)
+		var got []byte
+		if err == nil {
+			got, err = ioutil.ReadAll(body)
+			body.Close()
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != data || m.name != "tool.bin" {
+			t.Errorf("%s: got %q, named %q", tt.name, got, m.name)
+		}
+	}
+}
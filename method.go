@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// requestData is the body sent with -data, once resolved,
+// and requestType its Content-Type.
+var (
+	requestData []byte
+	requestType string
+)
+
+// resolveData reads the -data, if it's @file (or @- for stdin),
+// and picks the -method.
+func resolveData() error {
+	*method = strings.ToUpper(*method)
+	if *data == "" {
+		if *method == "" {
+			*method = http.MethodGet
+		}
+		return nil
+	}
+	// like curl, sending data makes it a POST
+	if *method == "" {
+		*method = http.MethodPost
+	}
+	if *method == http.MethodGet || *method == http.MethodHead {
+		return fmt.Errorf("-data can't be sent with -method %s", *method)
+	}
+
+	if !strings.HasPrefix(*data, "@") {
+		requestData = []byte(*data)
+		requestType = "application/x-www-form-urlencoded"
+		return nil
+	}
+
+	var err error
+	file := (*data)[1:]
+	if file == "-" {
+		requestData, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		requestData, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("-data: %w", err)
+	}
+	requestType = mime.TypeByExtension(filepath.Ext(file))
+	if requestType == "" {
+		requestType = "application/octet-stream"
+	}
+	return nil
+}
+
+// newPayloadRequest makes the request for an HTTP source,
+// with the -method and -data.
+func newPayloadRequest(source string) (*http.Request, error) {
+	if *method == http.MethodGet || *method == "" {
+		return http.NewRequest(http.MethodGet, source, nil)
+	}
+	// a bytes.Reader can be resent, on retries and redirects
+	req, err := http.NewRequest(*method, source, bytes.NewReader(requestData))
+	if err != nil {
+		return nil, err
+	}
+	if requestType != "" {
+		req.Header.Set("Content-Type", requestType)
+	}
+	return req, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveData(t *testing.T) {
+	defer func(m, d string) { *method, *data = m, d }(*method, *data)
+	defer func() { requestData, requestType = nil, "" }()
+
+	file := filepath.Join(t.TempDir(), "query.json")
+	ioutil.WriteFile(file, []byte(`{"id":1}`), 0666)
+
+	tests := []struct {
+		method, data string
+		wantMethod   string
+		wantData     string
+		wantType     string
+		wantErr      bool
+	}{
+		{wantMethod: "GET"},
+		{method: "put", wantMethod: "PUT"},
+		{data: "a=1", wantMethod: "POST", wantData: "a=1", wantType: "application/x-www-form-urlencoded"},
+		{method: "PATCH", data: "@" + file, wantMethod: "PATCH", wantData: `{"id":1}`, wantType: "application/json"},
+		{method: "GET", data: "a=1", wantErr: true},
+		{data: "@" + file + ".missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		*method, *data = tt.method, tt.data
+		requestData, requestType = nil, ""
+		err := resolveData()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("-method %q -data %q: want error", tt.method, tt.data)
+			}
+			continue
+		}
+		if err != nil || *method != tt.wantMethod || string(requestData) != tt.wantData || requestType != tt.wantType {
+			t.Errorf("-method %q -data %q: got %q, %q, %q, %v", tt.method, tt.data, *method, requestData, requestType, err)
+		}
+	}
+}
+
+func TestFetch_method(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/export" {
+			// a 307 resends the body
+			http.Redirect(w, r, "/download", http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.Header.Get("Content-Type") + " " + string(body)))
+	}))
+	defer srv.Close()
+
+	defer func(m, d string) { *method, *data = m, d }(*method, *data)
+	defer func() { requestData, requestType = nil, "" }()
+	*method, *data = "", "a=1"
+	if err := resolveData(); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _, err := fetch(srv.URL+"/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(body)
+	body.Close()
+	if want := "POST application/x-www-form-urlencoded a=1"; string(got) != want {
+		t.Errorf("fetch() sent %q, want %q", got, want)
+	}
+}
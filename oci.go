@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+const (
+	ociIndex          = "application/vnd.oci.image.index.v1+json"
+	ociManifest       = "application/vnd.oci.image.manifest.v1+json"
+	dockerList        = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	ociTitle          = "org.opencontainers.image.title"
+	ociManifestAccept = ociIndex + ", " + ociManifest + ", " + dockerList + ", " + dockerManifest
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+type ociImage struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// registry is a client for an OCI distribution API repository.
+type registry struct {
+	base  string
+	repo  string
+	user  *url.Userinfo
+	token string
+}
+
+// fetchOCI pulls a blob or a single-layer artifact,
+// referenced as oci://host/repo[:tag|@digest].
+func fetchOCI(source string) (io.ReadCloser, *meta, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, ref := strings.TrimPrefix(u.Path, "/"), "latest"
+	if i := strings.LastIndexByte(repo, '@'); i >= 0 {
+		repo, ref = repo[:i], repo[i+1:]
+	} else if i := strings.LastIndexByte(repo, ':'); i > strings.LastIndexByte(repo, '/') {
+		repo, ref = repo[:i], repo[i+1:]
+	}
+	if repo == "" {
+		return nil, nil, fmt.Errorf("malformed OCI reference %q", source)
+	}
+
+	// like docker, assume registries on loopback don't use TLS
+	scheme := "https"
+	if host := u.Hostname(); host == "localhost" || net.ParseIP(host).IsLoopback() {
+		scheme = "http"
+	}
+	r := &registry{
+		base: scheme + "://" + u.Host + "/v2/" + repo,
+		repo: repo,
+		user: u.User,
+	}
+
+	layer, err := r.resolve(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := layer.Annotations[ociTitle]
+	if name == "" {
+		name = path.Base(repo)
+	}
+
+	res, err := r.get("/blobs/"+layer.Digest, "", true)
+	var p *plannedRequest
+	if errors.As(err, &p) {
+		p.name, p.digest = name, layer.Digest
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := gofetch.NewVerifier(res.Body, layer.Digest)
+	if err != nil {
+		res.Body.Close()
+		return nil, nil, err
+	}
+
+	m := responseMeta(res, name)
+	if layer.MediaType != "" {
+		m.contentType = layer.MediaType
+	}
+	return body, m, nil
+}
+
+// resolve finds the single layer of the artifact ref points to.
+// A digest that isn't a manifest is assumed to be a blob.
+func (r *registry) resolve(ref string) (*ociDescriptor, error) {
+	for {
+		res, err := r.get("/manifests/"+ref, ociManifestAccept, false)
+		if res != nil && res.StatusCode == http.StatusNotFound && strings.Contains(ref, ":") {
+			return &ociDescriptor{Digest: ref}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.ReadCloser = res.Body
+		if strings.Contains(ref, ":") {
+			body, err = gofetch.NewVerifier(body, ref)
+			if err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+		}
+		var img ociImage
+		buf, err := ioutil.ReadAll(body)
+		res.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(buf, &img)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+
+		if img.MediaType == "" {
+			img.MediaType, _, _ = mime.ParseMediaType(res.Header.Get("Content-Type"))
+		}
+		switch img.MediaType {
+		case ociIndex, dockerList:
+			m, err := ociPlatform(img.Manifests)
+			if err != nil {
+				return nil, err
+			}
+			ref = m.Digest
+			continue
+		}
+
+		if len(img.Layers) != 1 {
+			return nil, fmt.Errorf("artifact has %d layers; expected 1", len(img.Layers))
+		}
+		return &img.Layers[0], nil
+	}
+}
+
+// ociPlatform selects the manifest for this platform from an index.
+func ociPlatform(manifests []ociDescriptor) (*ociDescriptor, error) {
+	if len(manifests) == 1 {
+		return &manifests[0], nil
+	}
+	for i, m := range manifests {
+		if p := m.Platform; p != nil && p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH {
+			return &manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// get performs an API request, authenticating if challenged.
+// On error, the response is returned if there was one.
+func (r *registry) get(path, accept string, payload bool) (*http.Response, error) {
+	for retry := false; ; retry = true {
+		req, err := http.NewRequest(http.MethodGet, r.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+
+		res, err := send(req, payload)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusUnauthorized && !retry {
+			if err := r.authenticate(res.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return res, errors.New("registry error: " + res.Status)
+	}
+}
+
+// authenticate obtains a bearer token, as challenged by the registry.
+func (r *registry) authenticate(challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("unsupported registry authentication: %q", challenge)
+	}
+	params := challengeParams(challenge[len("Bearer "):])
+	if params["scope"] == "" {
+		params["scope"] = "repository:" + r.repo + ":pull"
+	}
+
+	q := url.Values{}
+	q.Set("scope", params["scope"])
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if r.user != nil {
+		pass, _ := r.user.Password()
+		req.SetBasicAuth(r.user.Username(), pass)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("registry authentication error: " + res.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tok); err != nil {
+		return err
+	}
+	r.token = tok.Token
+	if r.token == "" {
+		r.token = tok.AccessToken
+	}
+	return nil
+}
+
+// challengeParams parses the key=value, or key="quoted value", parameters
+// of a challenge; quoted values may contain commas, and escaped quotes.
+func challengeParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			s = s[i:]
+			s = strings.TrimPrefix(s, `"`)
+		} else {
+			i = strings.IndexByte(s, ',')
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		params[key] = value.String()
+	}
+}
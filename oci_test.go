@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchOCI(t *testing.T) {
+	blob := []byte("tool binary")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	manifest, _ := json.Marshal(ociImage{
+		MediaType: ociManifest,
+		Layers: []ociDescriptor{{
+			MediaType:   "application/octet-stream",
+			Digest:      digest,
+			Size:        int64(len(blob)),
+			Annotations: map[string]string{ociTitle: "tool"},
+		}},
+	})
+	index, _ := json.Marshal(ociImage{
+		MediaType: ociIndex,
+		Manifests: []ociDescriptor{{
+			MediaType: ociManifest,
+			Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)),
+		}},
+	})
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:org/tool:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/org/tool/manifests/v1":
+			w.Write(index)
+		case "/v2/org/tool/manifests/sha256:" + fmt.Sprintf("%x", sha256.Sum256(manifest)):
+			w.Write(manifest)
+		case "/v2/org/tool/blobs/" + digest:
+			w.Write(blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	body, m, err := fetchOCI("oci://" + host + "/org/tool:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(blob) || m.name != "tool" {
+		t.Errorf("fetchOCI() = %q, %q", got, m.name)
+	}
+
+	// a blob, by digest
+	body, m, err = fetchOCI("oci://" + host + "/org/tool@" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != string(blob) || m.name != "tool" {
+		t.Errorf("fetchOCI(@digest) = %q, %q, %v", got, m.name, err)
+	}
+
+	if _, _, err := fetchOCI("oci://" + host + "/org/missing:v1"); err == nil {
+		t.Error("fetchOCI(missing): want error")
+	}
+}
+
+func TestChallengeParams(t *testing.T) {
+	tests := []struct {
+		challenge string
+		want      map[string]string
+	}{
+		{
+			`realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`,
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/alpine:pull"},
+		},
+		{
+			`Realm="https://host/token", scope="repository:a:pull,push"`,
+			map[string]string{"realm": "https://host/token", "scope": "repository:a:pull,push"},
+		},
+		{
+			`realm=https://host/token,error="say \"hi\""`,
+			map[string]string{"realm": "https://host/token", "error": `say "hi"`},
+		},
+		{"", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := challengeParams(tt.challenge); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("challengeParams(%q) = %v; want %v", tt.challenge, got, tt.want)
+		}
+	}
+}
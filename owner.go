@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// The -owner and -group of extracted files, or -1.
+var ownerUID, ownerGID = -1, -1
+
+// resolveOwner finds the ids of the -owner and -group flags.
+func resolveOwner() error {
+	if *owner != "" {
+		id, err := strconv.Atoi(*owner)
+		if err != nil {
+			u, lerr := user.Lookup(*owner)
+			if lerr != nil {
+				return fmt.Errorf("-owner: %w", lerr)
+			}
+			id, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return fmt.Errorf("-owner: user %s has no numeric id", *owner)
+			}
+		}
+		ownerUID = id
+	}
+	if *group != "" {
+		id, err := strconv.Atoi(*group)
+		if err != nil {
+			g, lerr := user.LookupGroup(*group)
+			if lerr != nil {
+				return fmt.Errorf("-group: %w", lerr)
+			}
+			id, err = strconv.Atoi(g.Gid)
+			if err != nil {
+				return fmt.Errorf("-group: group %s has no numeric id", *group)
+			}
+		}
+		ownerGID = id
+	}
+	return nil
+}
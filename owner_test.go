@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestResolveOwner(t *testing.T) {
+	defer func(o, g string, uid, gid int) {
+		*owner, *group, ownerUID, ownerGID = o, g, uid, gid
+	}(*owner, *group, ownerUID, ownerGID)
+
+	tests := []struct {
+		owner, group string
+		uid, gid     int
+		wantErr      bool
+		linux        bool
+	}{
+		{uid: -1, gid: -1},
+		{owner: "1000", group: "100", uid: 1000, gid: 100},
+		{owner: "go-fetch-no-such-user", wantErr: true},
+		{group: "go-fetch-no-such-group", wantErr: true},
+		{owner: "root", group: "root", uid: 0, gid: 0, linux: true},
+	}
+	for _, tt := range tests {
+		if tt.linux && runtime.GOOS != "linux" {
+			continue
+		}
+		*owner, *group, ownerUID, ownerGID = tt.owner, tt.group, -1, -1
+		err := resolveOwner()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("-owner %q -group %q: want error", tt.owner, tt.group)
+			}
+			continue
+		}
+		if err != nil || ownerUID != tt.uid || ownerGID != tt.gid {
+			t.Errorf("-owner %q -group %q = %d, %d, %v; want %d, %d",
+				tt.owner, tt.group, ownerUID, ownerGID, err, tt.uid, tt.gid)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// readPassword prompts for a password on the terminal, without echoing it,
+// or reads it from stdin, if there's no terminal.
+func readPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return readLine(os.Stdin, os.Stderr, prompt)
+	}
+	defer tty.Close()
+
+	fd := tty.Fd()
+	var term syscall.Termios
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&term))); e == 0 {
+		noecho := term
+		noecho.Lflag &^= syscall.ECHO
+		syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&noecho)))
+		defer syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&term)))
+		defer tty.WriteString("\n") // the newline wasn't echoed
+	}
+	return readLine(tty, tty, prompt)
+}
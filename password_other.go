@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// readPassword prompts for a password, reading it from stdin.
+func readPassword(prompt string) (string, error) {
+	return readLine(os.Stdin, os.Stderr, prompt)
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// With -patch, the download is a binary patch to a local file,
+// rather than the file itself, so that updates can be small.
+// The patched file goes on like a download: it's verified, unpacked, etc.
+
+var errCorruptPatch = errors.New("corrupt patch")
+
+// patchReader reads a patched file.
+type patchReader struct {
+	*io.PipeReader
+	patch io.Closer
+}
+
+func (p *patchReader) Close() error {
+	p.PipeReader.Close()
+	return p.patch.Close()
+}
+
+// newPatcher applies the patch read from r to the old file,
+// as a bsdiff (BSDIFF40), or VCDIFF (xdelta3), delta.
+func newPatcher(r io.ReadCloser, old string) (io.ReadCloser, error) {
+	f, err := os.Open(old)
+	if err != nil {
+		return nil, fmt.Errorf("-patch: %w", err)
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(8)
+
+	var apply func(w io.Writer) error
+	switch {
+	case bytes.HasPrefix(magic, []byte("BSDIFF40")):
+		apply = func(w io.Writer) error { return bspatch(w, br, f) }
+	case bytes.HasPrefix(magic, []byte("\xd6\xc3\xc4\x00")):
+		apply = func(w io.Writer) error { return vcdecode(w, br, f) }
+	default:
+		f.Close()
+		return nil, errors.New("-patch: the download isn't a bsdiff or VCDIFF (xdelta3) patch")
+	}
+
+	// the old file is closed once patched, as it may be replaced
+	pr, pw := io.Pipe()
+	go func() {
+		err := apply(pw)
+		f.Close()
+		pw.CloseWithError(err)
+	}()
+	return &patchReader{PipeReader: pr, patch: r}, nil
+}
+
+// bspatch applies a bsdiff patch: a header, and three bzip2 streams,
+// with an add, copy, seek program, the bytes to add to old, and the new bytes.
+func bspatch(w io.Writer, r io.Reader, old io.ReaderAt) error {
+	// the streams are read in parallel
+	patch, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(patch) < 32 {
+		return errCorruptPatch
+	}
+	ctrlLen, diffLen, newSize := offtin(patch[8:]), offtin(patch[16:]), offtin(patch[24:])
+	size := int64(len(patch)) - 32
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || ctrlLen > size || diffLen > size-ctrlLen {
+		return errCorruptPatch
+	}
+	if err := checkPatchedSize(newSize); err != nil {
+		return err
+	}
+	patch = patch[32:]
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[:ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[ctrlLen:][:diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[ctrlLen+diffLen:]))
+
+	var newPos, oldPos int64
+	buf := make([]byte, 32*1024)
+	oldBuf := make([]byte, len(buf))
+	for newPos < newSize {
+		var prog [24]byte
+		if _, err := io.ReadFull(ctrl, prog[:]); err != nil {
+			return errCorruptPatch
+		}
+		add, extraLen, seek := offtin(prog[0:]), offtin(prog[8:]), offtin(prog[16:])
+		if add < 0 || extraLen < 0 || newPos+add+extraLen > newSize {
+			return errCorruptPatch
+		}
+
+		// add old bytes to the diff; past the ends of old, they're zero
+		for n := add; n > 0; {
+			b := buf[:min64(n, int64(len(buf)))]
+			if _, err := io.ReadFull(diff, b); err != nil {
+				return errCorruptPatch
+			}
+			o := oldBuf[:len(b)]
+			for i := range o {
+				o[i] = 0
+			}
+			if oldPos < 0 {
+				if skip := -oldPos; skip < int64(len(o)) {
+					old.ReadAt(o[skip:], 0)
+				}
+			} else {
+				old.ReadAt(o, oldPos)
+			}
+			for i := range b {
+				b[i] += o[i]
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			oldPos += int64(len(b))
+			n -= int64(len(b))
+		}
+
+		if _, err := io.CopyN(w, extra, extraLen); err == io.EOF {
+			return errCorruptPatch
+		} else if err != nil {
+			return err
+		}
+		newPos += add + extraLen
+		oldPos += seek
+	}
+	return nil
+}
+
+// offtin decodes a bsdiff integer: little-endian, in sign and magnitude.
+func offtin(b []byte) int64 {
+	n := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -n
+	}
+	return n
+}
+
+// checkPatchedSize fails if the patched file, or a window of it,
+// is larger than the -max-filesize.
+func checkPatchedSize(size int64) error {
+	if maxFilesize > 0 && size > int64(maxFilesize) {
+		return fmt.Errorf("-patch: patched file is %d bytes, larger than -max-filesize", size)
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// VCDIFF (RFC 3284) instruction types.
+const (
+	vcNoop = iota
+	vcAdd
+	vcRun
+	vcCopy
+)
+
+type vcInst struct {
+	typ  byte
+	size byte // 0 if it's in the instructions
+	mode byte // of copies
+}
+
+// vcCodeTable is the default instruction code table.
+var vcCodeTable = func() (t [256][2]vcInst) {
+	i := 0
+	next := func(a, b vcInst) {
+		t[i] = [2]vcInst{a, b}
+		i++
+	}
+	next(vcInst{typ: vcRun}, vcInst{})
+	for size := 0; size <= 17; size++ {
+		next(vcInst{typ: vcAdd, size: byte(size)}, vcInst{})
+	}
+	for mode := 0; mode <= 8; mode++ {
+		next(vcInst{typ: vcCopy, mode: byte(mode)}, vcInst{})
+		for size := 4; size <= 18; size++ {
+			next(vcInst{typ: vcCopy, size: byte(size), mode: byte(mode)}, vcInst{})
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		copies := 6
+		if mode > 5 {
+			copies = 4
+		}
+		for add := 1; add <= 4; add++ {
+			for size := 4; size <= copies; size++ {
+				next(vcInst{typ: vcAdd, size: byte(add)}, vcInst{typ: vcCopy, size: byte(size), mode: byte(mode)})
+			}
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		next(vcInst{typ: vcCopy, size: 4, mode: byte(mode)}, vcInst{typ: vcAdd, size: 1})
+	}
+	return t
+}()
+
+// vcdecode decodes a VCDIFF delta, as made by xdelta3,
+// without secondary compression, or custom code tables.
+func vcdecode(w io.Writer, r *bufio.Reader, old io.ReaderAt) error {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return errCorruptPatch
+	}
+	switch ind := header[4]; {
+	case ind&0x01 != 0:
+		return errors.New("unsupported VCDIFF secondary compression (use xdelta3 -S none)")
+	case ind&0x02 != 0:
+		return errors.New("unsupported VCDIFF code table")
+	case ind&0x04 != 0:
+		// an application header, like xdelta3's file names
+		n, err := vcInt(r)
+		if err == nil {
+			_, err = io.CopyN(ioutil.Discard, r, n)
+		}
+		if err != nil {
+			return errCorruptPatch
+		}
+	}
+
+	for {
+		ind, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := vcWindow(w, r, old, ind); err != nil {
+			return err
+		}
+	}
+}
+
+// vcWindow decodes a window of a VCDIFF delta.
+func vcWindow(w io.Writer, r *bufio.Reader, old io.ReaderAt, ind byte) error {
+	if ind&0x02 != 0 {
+		return errors.New("unsupported VCDIFF target segments")
+	}
+	var source []byte
+	if ind&0x01 != 0 {
+		size, err1 := vcInt(r)
+		pos, err2 := vcInt(r)
+		if err1 != nil || err2 != nil || size > 1<<31 {
+			return errCorruptPatch
+		}
+		// read, rather than allocated, as it may be past the end of the file
+		var err error
+		source, err = ioutil.ReadAll(io.NewSectionReader(old, pos, size))
+		if err != nil {
+			return fmt.Errorf("-patch: reading the old file: %w", err)
+		}
+		if int64(len(source)) != size {
+			return errCorruptPatch
+		}
+	}
+
+	var sizes [5]int64 // delta, target, data, instructions, addresses
+	var deltaInd byte
+	var err error
+	for i := range sizes {
+		if sizes[i], err = vcInt(r); err != nil || sizes[i] > 1<<31 {
+			return errCorruptPatch
+		}
+		if i == 1 {
+			if deltaInd, err = r.ReadByte(); err != nil {
+				return errCorruptPatch
+			}
+		}
+	}
+	if deltaInd != 0 {
+		return errors.New("unsupported VCDIFF secondary compression (use xdelta3 -S none)")
+	}
+	if err := checkPatchedSize(sizes[1]); err != nil {
+		return err
+	}
+	var checksum []byte
+	if ind&0x04 != 0 {
+		// xdelta3's Adler-32 of the target window
+		checksum = make([]byte, 4)
+		if _, err := io.ReadFull(r, checksum); err != nil {
+			return errCorruptPatch
+		}
+	}
+	// sizes are checked as the sections are read, not trusted
+	total := sizes[2] + sizes[3] + sizes[4]
+	sections, err := ioutil.ReadAll(io.LimitReader(r, total))
+	if err != nil {
+		return err
+	}
+	if int64(len(sections)) != total {
+		return errCorruptPatch
+	}
+	data := bytes.NewReader(sections[:sizes[2]])
+	insts := bytes.NewReader(sections[sizes[2]:][:sizes[3]])
+	addrs := bytes.NewReader(sections[sizes[2]+sizes[3]:])
+
+	// the target grows to its size, as it's decoded
+	var target []byte
+	var near [4]int64
+	var same [3 * 256]int64
+	nextNear := 0
+	for insts.Len() > 0 {
+		code, _ := insts.ReadByte()
+		for _, in := range vcCodeTable[code] {
+			if in.typ == vcNoop {
+				continue
+			}
+			size := int64(in.size)
+			if size == 0 {
+				if size, err = vcInt(insts); err != nil {
+					return errCorruptPatch
+				}
+			}
+			if int64(len(target))+size > sizes[1] {
+				return errCorruptPatch
+			}
+
+			switch in.typ {
+			case vcAdd:
+				if size > int64(data.Len()) {
+					return errCorruptPatch
+				}
+				start := len(target)
+				target = append(target, make([]byte, size)...)
+				data.Read(target[start:])
+			case vcRun:
+				b, err := data.ReadByte()
+				if err != nil {
+					return errCorruptPatch
+				}
+				for ; size > 0; size-- {
+					target = append(target, b)
+				}
+			case vcCopy:
+				here := int64(len(source)) + int64(len(target))
+				var addr int64
+				switch m := in.mode; {
+				case m == 0:
+					addr, err = vcInt(addrs)
+				case m == 1:
+					addr, err = vcInt(addrs)
+					addr = here - addr
+				case m < 6:
+					addr, err = vcInt(addrs)
+					addr += near[m-2]
+				default:
+					var b byte
+					b, err = addrs.ReadByte()
+					addr = same[int(m-6)*256+int(b)]
+				}
+				if err != nil || addr < 0 || addr >= here {
+					return errCorruptPatch
+				}
+				near[nextNear] = addr
+				nextNear = (nextNear + 1) % len(near)
+				same[addr%int64(len(same))] = addr
+
+				// copies from the target can overlap what they write
+				for ; size > 0; size-- {
+					if addr < int64(len(source)) {
+						target = append(target, source[addr])
+					} else {
+						target = append(target, target[addr-int64(len(source))])
+					}
+					addr++
+				}
+			}
+		}
+	}
+
+	if int64(len(target)) != sizes[1] {
+		return errCorruptPatch
+	}
+	if checksum != nil && adler32.Checksum(target) != binary.BigEndian.Uint32(checksum) {
+		return errCorruptPatch
+	}
+	_, err = w.Write(target)
+	return err
+}
+
+// vcInt reads a VCDIFF integer: big-endian, 7 bits a byte.
+func vcInt(r io.ByteReader) (int64, error) {
+	var n int64
+	for i := 0; i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | int64(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errCorruptPatch
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/adler32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	patchOld = "hello, world\n"
+	patchNew = "hello, there, world\n"
+)
+
+// bsdiffPatch patches patchOld to patchNew; its bzip2 streams
+// copy "hello, ", add "there, ", and copy "world\n".
+const bsdiffPatch = "42534449464634302d0000000000000025000000000000001400000000000000" +
+	"425a68393141592653596c2c039b000007600049880800200021287a20c011e3096a78bb9229c2848361601cd8" +
+	"425a683931415926535941622ef00000004000402020002100828317724538509041622ef0" +
+	"425a6839314159265359bcf4fe4c0000031180400402401400200021800c013b74dc5dc914e14242f3d3f930"
+
+// vcdiffPatch patches patchOld to patchNew, with a window that
+// copies "hello, ", adds "there, ", and copies "world\n",
+// checked against checksum.
+func vcdiffPatch(checksum uint32) []byte {
+	data := []byte("there, ")
+	insts := []byte{16 + 7, 1 + 7, 16 + 6} // COPY 7, ADD 7, COPY 6, in mode 0
+	addrs := []byte{0, 7}
+
+	var p bytes.Buffer
+	p.WriteString("\xd6\xc3\xc4\x00\x00")
+	p.WriteByte(0x01 | 0x04) // source, and Adler-32
+	p.WriteByte(byte(len(patchOld)))
+	p.WriteByte(0)
+	p.WriteByte(byte(1 + 1 + 3 + 4 + len(data) + len(insts) + len(addrs)))
+	p.WriteByte(byte(len(patchNew)))
+	p.WriteByte(0)
+	p.WriteByte(byte(len(data)))
+	p.WriteByte(byte(len(insts)))
+	p.WriteByte(byte(len(addrs)))
+	binary.Write(&p, binary.BigEndian, checksum)
+	p.Write(data)
+	p.Write(insts)
+	p.Write(addrs)
+	return p.Bytes()
+}
+
+func TestNewPatcher(t *testing.T) {
+	bsdiff, err := hex.DecodeString(bsdiffPatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a patch for a file of a different size
+	bsdiffSize := append([]byte(nil), bsdiff...)
+	bsdiffSize[24]++
+	// lengths that overflow, when added
+	bsdiffLens := append([]byte(nil), bsdiff...)
+	binary.LittleEndian.PutUint64(bsdiffLens[8:], 1<<62)
+	binary.LittleEndian.PutUint64(bsdiffLens[16:], 1<<62)
+	// a window with sections larger than the patch
+	vcdiffLarge := vcdiffPatch(adler32.Checksum([]byte(patchNew)))
+	vcdiffLarge = append(append(vcdiffLarge[:11:11], 0x84, 0x80, 0x80, 0x80, 0x00), vcdiffLarge[12:]...)
+
+	sum := adler32.Checksum([]byte(patchNew))
+
+	tests := []struct {
+		name    string
+		patch   []byte
+		wantErr bool
+	}{
+		{"bsdiff", bsdiff, false},
+		{"bsdiff truncated", bsdiff[:len(bsdiff)-40], true},
+		{"bsdiff size", bsdiffSize, true},
+		{"bsdiff lengths", bsdiffLens, true},
+		{"vcdiff", vcdiffPatch(sum), false},
+		{"vcdiff checksum", vcdiffPatch(sum + 1), true},
+		{"vcdiff truncated", vcdiffPatch(sum)[:20], true},
+		{"vcdiff large", vcdiffLarge, true},
+	}
+
+	old := filepath.Join(t.TempDir(), "old")
+	if err := ioutil.WriteFile(old, []byte(patchOld), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newPatcher(ioutil.NopCloser(bytes.NewReader(tt.patch)), old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != patchNew {
+				t.Errorf("got %q, want %q", got, patchNew)
+			}
+		})
+	}
+}
+
+func TestNewPatcher_notPatch(t *testing.T) {
+	old := filepath.Join(t.TempDir(), "old")
+	if err := ioutil.WriteFile(old, []byte(patchOld), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newPatcher(ioutil.NopCloser(bytes.NewReader([]byte(patchNew))), old)
+	if err == nil {
+		t.Error("want error")
+	}
+	_, err = newPatcher(ioutil.NopCloser(bytes.NewReader([]byte(patchNew))), old+".missing")
+	if !os.IsNotExist(errors.Unwrap(err)) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNewPatcher_maxFilesize(t *testing.T) {
+	defer func(old sizeFlag) { maxFilesize = old }(maxFilesize)
+	maxFilesize = sizeFlag(len(patchNew) - 1)
+
+	bsdiff, _ := hex.DecodeString(bsdiffPatch)
+	old := filepath.Join(t.TempDir(), "old")
+	if err := ioutil.WriteFile(old, []byte(patchOld), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, patch := range [][]byte{bsdiff, vcdiffPatch(adler32.Checksum([]byte(patchNew)))} {
+		r, err := newPatcher(ioutil.NopCloser(bytes.NewReader(patch)), old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadAll(r); err == nil {
+			t.Errorf("got %q, want error", got)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// planEntry is a resolved job, printed by -plan.
+type planEntry struct {
+	Source  string      `json:"source"`
+	URL     string      `json:"url,omitempty"`
+	Method  string      `json:"method,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Name    string      `json:"name,omitempty"`
+	Target  string      `json:"target"`
+	Unpack  bool        `json:"unpack"`
+	Subdir  string      `json:"subdir,omitempty"`
+	Verify  []string    `json:"verify,omitempty"` // digests, in algorithm:hex form
+}
+
+// plannedRequest is returned by send instead of downloading,
+// when only planning.
+type plannedRequest struct {
+	req    *http.Request
+	name   string // suggested file name, if known
+	digest string // verified by the fetcher, if any
+}
+
+func (p *plannedRequest) Error() string {
+	return "planned request: " + p.req.URL.String()
+}
+
+// send performs a request, unless it's for the payload,
+// and we're only planning, or only want its headers.
+// Payloads are cached.
+func send(req *http.Request, payload bool) (*http.Response, error) {
+	if !payload {
+		return client.Do(req)
+	}
+	if *plan != "" {
+		return nil, &plannedRequest{req: req}
+	}
+	if *headOnly {
+		return sendHead(req)
+	}
+	return cachedSend(req)
+}
+
+var planMutex sync.Mutex
+
+// plan resolves the job, and prints the request that would download it.
+func (j *job) plan() error {
+	e := planEntry{
+		Source: j.source,
+		Target: j.target,
+		Unpack: j.unpack,
+		Subdir: j.subdir,
+	}
+	if j.digest != "" {
+		e.Verify = append(e.Verify, j.digest)
+	}
+
+	// repositories are cloned, not requested
+	if !strings.HasPrefix(j.source, "git::") {
+		body, _, err := fetch(j.source, nil)
+		if err == nil {
+			body.Close() // stdin and data need no request
+		} else {
+			var p *plannedRequest
+			if !errors.As(err, &p) {
+				return err
+			}
+			e.URL = p.req.URL.String()
+			e.Method = p.req.Method
+			e.Headers = redactHeaders(p.req.Header)
+			e.Name = p.name
+			if p.digest != "" {
+				e.Verify = append(e.Verify, p.digest)
+			}
+		}
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	planMutex.Lock()
+	defer planMutex.Unlock()
+	_, err = os.Stdout.Write(append(buf, '\n'))
+	return err
+}
+
+// credentialHeaders are the request headers that carry credentials.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Private-Token", "Job-Token"}
+
+// redactHeaders hides credentials.
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range credentialHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, "REDACTED")
+		}
+	}
+	return h
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestJob_plan(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	defer func(old string) { *plan = old }(*plan)
+	*plan = "json"
+
+	j := newJob(srv.URL+"/tool.tar.gz//bin", "out")
+	j.digest = "sha256:abcd"
+	var err error
+	out := captureStdout(t, func() { err = j.run() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 0 {
+		t.Errorf("-plan sent %d requests", requests)
+	}
+
+	var got planEntry
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Headers = nil
+	want := planEntry{
+		Source: srv.URL + "/tool.tar.gz",
+		URL:    srv.URL + "/tool.tar.gz",
+		Method: "GET",
+		Target: "out",
+		Unpack: true,
+		Subdir: "bin",
+		Verify: []string{"sha256:abcd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("-plan printed %+v, want %+v", got, want)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Private-Token", "secret")
+	h.Set("Accept", "application/json")
+
+	got := redactHeaders(h)
+	if got.Get("Authorization") != "REDACTED" || got.Get("Private-Token") != "REDACTED" || got.Get("Accept") != "application/json" {
+		t.Errorf("redactHeaders() = %v", got)
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("redactHeaders() modified its argument")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// startProgress periodically reports how much of a download has been read,
+// until stopped. When unpacking, the position in the (compressed) download
+// is the best measure of progress, as the unpacked size isn't known.
+func startProgress(name string, total int64, read *counter) (stop func()) {
+	return startReports(func() string {
+		n := read.load()
+		if total > 0 {
+			return fmt.Sprintf("%s: %s of %s (%d%%)", name, formatSize(n), formatSize(total), n*100/total)
+		}
+		return fmt.Sprintf("%s: %s", name, formatSize(n))
+	})
+}
+
+// startReports periodically prints a report to stderr, until stopped.
+func startReports(report func() string) (stop func()) {
+	// on a terminal, update a single line
+	interval, eol := 5*time.Second, "\n"
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		interval, eol = time.Second/2, "\r"
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				fmt.Fprint(os.Stderr, report()+eol)
+			case <-done:
+				fmt.Fprint(os.Stderr, report()+eol)
+				if eol == "\r" {
+					fmt.Fprintln(os.Stderr)
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// batch, if set, aggregates the progress of several downloads,
+// which then don't report their own.
+var batch *batchProgress
+
+type batchProgress struct {
+	mtx   sync.Mutex
+	jobs  int
+	done  int
+	reads []*counter
+	total int64 // of the downloads started, or -1 if unknown
+}
+
+func newBatchProgress(jobs int) *batchProgress {
+	return &batchProgress{jobs: jobs}
+}
+
+// add counts a download that started.
+func (b *batchProgress) add(total int64, read *counter) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.reads = append(b.reads, read)
+	if total < 0 || b.total < 0 {
+		b.total = -1
+	} else {
+		b.total += total
+	}
+}
+
+// finish counts the downloads that ended.
+func (b *batchProgress) finish(done int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.done = done
+}
+
+func (b *batchProgress) start() (stop func()) {
+	return startReports(func() string {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+		var n int64
+		for _, r := range b.reads {
+			n += r.load()
+		}
+		// the total is known only once every download started
+		if len(b.reads) == b.jobs && b.total > 0 {
+			return fmt.Sprintf("%d of %d downloads: %s of %s (%d%%)", b.done, b.jobs, formatSize(n), formatSize(b.total), n*100/b.total)
+		}
+		return fmt.Sprintf("%d of %d downloads: %s", b.done, b.jobs, formatSize(n))
+	})
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// counter counts bytes written to it, and can be read concurrently.
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	atomic.AddInt64((*int64)(c), int64(len(p)))
+	return len(p), nil
+}
+
+func (c *counter) load() int64 {
+	return atomic.LoadInt64((*int64)(c))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 30:       "3.0 GiB",
+		1<<40 + 1<<39: "1.5 TiB",
+	}
+	for n, want := range tests {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestStartProgress(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *os.File) { os.Stderr = old }(os.Stderr)
+	os.Stderr = w
+
+	var read counter
+	read.Write(make([]byte, 512))
+	stop := startProgress("file.tar.gz", 2048, &read)
+	stop()
+	w.Close()
+
+	// the last report is written when stopped
+	got, _ := ioutil.ReadAll(r)
+	if want := "file.tar.gz: 512 B of 2.0 KiB (25%)\n"; string(got) != want {
+		t.Errorf("reported %q, want %q", got, want)
+	}
+}
+
+func TestBatchProgress(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *os.File) { os.Stderr = old }(os.Stderr)
+	os.Stderr = w
+
+	var a, b, c counter
+	a.Write(make([]byte, 512))
+	b.Write(make([]byte, 1024))
+
+	p := newBatchProgress(3)
+	p.add(1024, &a)
+	p.add(1024, &b)
+	p.finish(1)
+	p.start()()
+	p.add(2048, &c)
+	p.finish(2)
+	p.start()()
+
+	unknown := newBatchProgress(2)
+	unknown.add(1024, &a)
+	unknown.add(-1, &b)
+	unknown.start()()
+	w.Close()
+
+	// the total is known once all downloads started, if all sizes are
+	got, _ := ioutil.ReadAll(r)
+	want := "1 of 3 downloads: 1.5 KiB\n" +
+		"2 of 3 downloads: 1.5 KiB of 4.0 KiB (37%)\n" +
+		"0 of 2 downloads: 1.5 KiB\n"
+	if string(got) != want {
+		t.Errorf("reported %q, want %q", got, want)
+	}
+}
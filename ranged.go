@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// With -ranged, remote zips are read from their central directory,
+// with range requests, so that only the parts of the archive that are
+// listed or extracted are downloaded.
+
+// rangeChunk is the least that's requested at once, so that reading
+// the central directory, and entries, needs few requests.
+const rangeChunk = 1 << 20
+
+// openRanged opens the download as a zip read with range requests,
+// if it's a zip, and the server supports them; otherwise, it returns nil.
+func (j *job) openRanged(br *bufio.Reader) *rangeReader {
+	res := j.meta.res
+	if !*rangedZip || res == nil || j.digest != "" || j.decompress || j.stdout && *entry == "" {
+		return nil
+	}
+	// cached downloads are local already
+	if _, cached := res.Body.(*os.File); cached || res.ContentLength <= 0 ||
+		res.Request.Method != http.MethodGet || res.Header.Get("Accept-Ranges") != "bytes" {
+		return nil
+	}
+	if j.format != "" {
+		if j.format != "zip" {
+			return nil
+		}
+	} else if magic, _ := br.Peek(4); !bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return nil
+	}
+
+	// fail, rather than mix ranges of different versions of the file
+	validator := res.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = res.Header.Get("Last-Modified")
+	}
+
+	return &rangeReader{
+		req:       res.Request,
+		size:      res.ContentLength,
+		validator: validator,
+		received:  j.received,
+	}
+}
+
+// rangeReader reads a remote file with range requests,
+// buffering a chunk of it.
+type rangeReader struct {
+	req       *http.Request // repeated for each range
+	size      int64
+	validator string // for If-Range
+	received  *counter
+	buf       []byte
+	off       int64 // of buf
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		if pos < r.off || pos >= r.off+int64(len(r.buf)) {
+			if err := r.fetch(pos, len(p)-n); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.buf[pos-r.off:])
+	}
+	return n, nil
+}
+
+func (r *rangeReader) fetch(off int64, n int) error {
+	if n < rangeChunk {
+		n = rangeChunk
+	}
+	// near the end, read back from it, as the central directory is there
+	start, end := off, off+int64(n)
+	if end > r.size {
+		end = r.size
+		if start = end - int64(n); start < 0 {
+			start = 0
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, r.req.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = r.req.Header.Clone()
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return fmt.Errorf("range request for %s: the file changed, or the server ignored the range", req.URL)
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request for %s: http error: %s", req.URL, res.Status)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/", start, end-1); !strings.HasPrefix(res.Header.Get("Content-Range"), want) {
+		return fmt.Errorf("range request for %s: unexpected range %q", req.URL, res.Header.Get("Content-Range"))
+	}
+
+	buf, err := ioutil.ReadAll(io.TeeReader(io.LimitReader(res.Body, end-start), r.received))
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) != end-start {
+		return io.ErrUnexpectedEOF
+	}
+	r.buf, r.off = buf, start
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJob_ranged(t *testing.T) {
+	big := make([]byte, 8*rangeChunk)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("wanted")
+	w.Write([]byte("wanted"))
+	w, _ = zw.CreateHeader(&zip.FileHeader{Name: "big", Method: zip.Store})
+	w.Write(big)
+	zw.Close()
+
+	var requested int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			atomic.AddInt64(&requested, end-start+1)
+		}
+		etag := `"v1"`
+		if strings.HasPrefix(r.URL.Path, "/changing") {
+			etag = fmt.Sprintf(`"%d"`, time.Now().UnixNano())
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+	}))
+	defer srv.Close()
+
+	defer func(old, e string, r bool) { *history, *entry, *rangedZip = old, e, r }(*history, *entry, *rangedZip)
+	*history, *entry, *rangedZip = "off", "wanted", true
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "wanted")
+	if err := newJob(srv.URL+"/ranged.zip", target).run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); string(got) != "wanted" {
+		t.Errorf("ranged -entry wrote %q", got)
+	}
+	if n := atomic.LoadInt64(&requested); n == 0 || n >= int64(len(big)) {
+		t.Errorf("ranged -entry requested %d bytes", n)
+	}
+
+	if err := newJob(srv.URL+"/changing.zip", filepath.Join(dir, "changed")).run(); err == nil {
+		t.Error("ranged -entry of a changing file: want error")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+type jobState int
+
+const (
+	jobPending jobState = iota
+	jobRunning
+	jobDone
+	jobFailed
+)
+
+// runJobs runs every job, even if some fail,
+// reporting the failures.
+//
+// Jobs run after the ones they depend on succeed, higher priorities first;
+// jobs that don't depend on each other run in parallel.
+func runJobs(jobs []*job) {
+	order := make([]*job, len(jobs))
+	copy(order, jobs)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].priority > order[j].priority
+	})
+
+	type result struct {
+		j   *job
+		err error
+	}
+	results := make(chan result)
+	state := map[*job]jobState{}
+	var running, finished, failed int
+
+	var failures []string
+	fail := func(j *job, err error) {
+		log.Printf("%s: %v", j.source, err)
+		failures = append(failures, j.source)
+		state[j] = jobFailed
+		finished++
+		failed++
+	}
+
+	stop := func() {}
+	if *showProgress && len(jobs) > 1 {
+		batch = newBatchProgress(len(jobs))
+		stop = batch.start()
+	}
+
+	for finished < len(jobs) {
+		// start what's ready, until nothing changes
+		for changed := true; changed; {
+			changed = false
+			for _, j := range order {
+				if state[j] != jobPending || running >= *parallel {
+					continue
+				}
+				var failedDep *job
+				ready := true
+				for _, d := range j.deps {
+					switch state[d] {
+					case jobFailed:
+						failedDep = d
+					case jobPending, jobRunning:
+						ready = false
+					}
+				}
+				if failedDep != nil {
+					fail(j, fmt.Errorf("dependency %s failed", failedDep.source))
+					changed = true
+				} else if ready {
+					state[j] = jobRunning
+					running++
+					changed = true
+					go func(j *job) { results <- result{j, j.run()} }(j)
+				}
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			fail(r.j, r.err)
+		} else {
+			state[r.j] = jobDone
+			finished++
+		}
+		if batch != nil {
+			batch.finish(finished)
+		}
+	}
+	stop()
+
+	if err := saveCookieJar(); err != nil {
+		log.Fatal(err)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d downloads failed:\n\t%s", failed, len(jobs), strings.Join(failures, "\n\t"))
+	}
+}
+
+// checkCycles reports jobs that depend on themselves.
+func checkCycles(jobs []*job) error {
+	state := map[*job]jobState{}
+	var visit func(j *job) error
+	visit = func(j *job) error {
+		switch state[j] {
+		case jobRunning:
+			return fmt.Errorf("artifacts depend on each other: %s", j.source)
+		case jobDone:
+			return nil
+		}
+		state[j] = jobRunning
+		for _, d := range j.deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		state[j] = jobDone
+		return nil
+	}
+	for _, j := range jobs {
+		if err := visit(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
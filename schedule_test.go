@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRunJobs(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	defer func(old string, n int) { *history, *parallel = old, n }(*history, *parallel)
+	*history = "off"
+	*parallel = 1 // to observe the order
+
+	dir := t.TempDir()
+	newTestJob := func(name string, priority int, deps ...*job) *job {
+		j := newJob(srv.URL+"/"+name, filepath.Join(dir, name))
+		j.priority, j.deps = priority, deps
+		return j
+	}
+	tool := newTestJob("tool", 0)
+	jobs := []*job{
+		newTestJob("low", -1),
+		newTestJob("verified", 5, tool),
+		newTestJob("high", 10),
+		tool,
+	}
+	runJobs(jobs)
+
+	want := []string{"/high", "/tool", "/verified", "/low"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("ran %q, want %q", order, want)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// sumMain prints the digests of sources in SHA256SUMS format,
+// streaming them without saving.
+func sumMain(args []string) {
+	// the flags of the main command apply, like the proxy, or -offline
+	algo := flag.String("a", "sha256", "digest `algorithm` (sha256 or sha512)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), "go-fetch sum [flags] <url>...\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+
+	parseFlags(args)
+	if gofetch.NewHash(*algo) == nil {
+		log.Fatalf("unsupported digest algorithm %q", *algo)
+	}
+
+	var failed int
+	for _, source := range flag.Args() {
+		sum, name, err := sumSource(expand(source), *algo)
+		if err != nil {
+			log.Printf("%s: %v", source, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s  %s\n", sum, name)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d downloads failed", failed, flag.NArg())
+	}
+}
+
+func sumSource(source, algo string) (sum, name string, err error) {
+	body, meta, err := fetch(source, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+	body = limitRate(body)
+
+	h := gofetch.NewHash(algo)
+	if _, err := io.Copy(h, body); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), meta.name, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestSumMain(t *testing.T) {
+	defer log.SetFlags(log.Flags())
+	out := captureStdout(t, func() {
+		sumMain([]string{"-a", "sha512", "data:,hello", "data:,world"})
+	})
+
+	want := fmt.Sprintf("%x  data\n%x  data\n", sha512.Sum512([]byte("hello")), sha512.Sum512([]byte("world")))
+	if out != want {
+		t.Errorf("sum printed %q, want %q", out, want)
+	}
+}
+
+func TestSumSource(t *testing.T) {
+	sum, name, err := sumSource("data:,hello", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || name != "data" {
+		t.Errorf("sumSource() = %q, %q", sum, name)
+	}
+}
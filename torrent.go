@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// With a torrent:: or magnet: source, a file of a web-seed-only torrent
+// is downloaded from its web seeds (BEP 19), and verified against the SHA-1
+// of its pieces. Peers aren't supported: torrents are only fetched from
+// their web seeds, and those without any can't be fetched.
+
+// torrent is the metainfo of a BitTorrent v1 torrent.
+type torrent struct {
+	name     string
+	hash     [sha1.Size]byte // of the info dictionary
+	pieceLen int64
+	pieces   []byte // the SHA-1 of each piece
+	files    []torrentFile
+	length   int64 // of all files
+	multi    bool  // or a single file, without a directory
+	seeds    []string
+}
+
+type torrentFile struct {
+	path   string // in the torrent directory, slash separated
+	length int64
+	offset int64 // of the file in the pieces
+	pad    bool  // a BEP 47 padding file, which is all zeros
+}
+
+// fetchTorrent downloads a file of a web-seed-only torrent,
+// referenced as torrent::url[#pattern], where url can also be a local file,
+// or as a magnet link with the exact source (xs) of the torrent.
+//
+// The pattern is a glob that picks the file, if the torrent has several.
+func fetchTorrent(source string) (io.ReadCloser, *meta, error) {
+	var t *torrent
+	var pattern string
+	var err error
+	if strings.HasPrefix(source, "magnet:") {
+		t, pattern, err = readMagnet(source)
+	} else {
+		loc := strings.TrimPrefix(source, "torrent::")
+		if i := strings.IndexByte(loc, '#'); i >= 0 {
+			loc, pattern = loc[:i], loc[i+1:]
+		}
+		t, err = readTorrent(loc)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := t.selectFile(pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("torrent %s: %w", t.name, err)
+	}
+
+	name := gofetch.SanitizeName(path.Base(file.path))
+	seeds := map[string]string{} // by file url
+	var urls []string
+	for _, s := range t.seeds {
+		if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+			u := t.fileURL(s, file)
+			seeds[u] = s
+			urls = append(urls, u)
+		}
+	}
+	urls = fastestMirrors(urls)
+	if len(urls) == 0 {
+		return nil, nil, fmt.Errorf("torrent %s: no http web seeds (peers aren't supported)", t.name)
+	}
+
+	// on failure, try the next web seed
+	for i, url := range urls {
+		var res *http.Response
+		res, err = getMirror(url)
+		var p *plannedRequest
+		if errors.As(err, &p) {
+			p.name = name
+		}
+		if err == nil {
+			var body io.ReadCloser
+			if body, err = t.verifier(res.Body, file, seeds[url]); err == nil {
+				return body, responseMeta(res, name), nil
+			}
+			res.Body.Close()
+		}
+		var h *headResponse
+		if p != nil || errors.As(err, &h) || i+1 == len(urls) {
+			break
+		}
+		log.Printf("web seed %s: %v; trying %s", url, err, urls[i+1])
+	}
+	return nil, nil, err
+}
+
+// readMagnet reads the torrent of a magnet link from its exact source,
+// checking it against the info hash. Web seeds in the link are added.
+func readMagnet(source string) (*torrent, string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, "", err
+	}
+	q := u.Query()
+
+	var want []byte
+	for _, xt := range q["xt"] {
+		if h := strings.TrimPrefix(xt, "urn:btih:"); h != xt {
+			if len(h) == 32 {
+				want, err = base32.StdEncoding.DecodeString(strings.ToUpper(h))
+			} else {
+				want, err = hex.DecodeString(h)
+			}
+		}
+	}
+	if err != nil || len(want) != sha1.Size {
+		return nil, "", errors.New("magnet link without a BitTorrent v1 info hash (xt=urn:btih:…)")
+	}
+	if len(q["xs"]) == 0 {
+		return nil, "", errors.New("magnet link without the url of its torrent (xs=…): peers aren't supported")
+	}
+
+	t, err := readTorrent(q["xs"][0])
+	if err != nil {
+		return nil, "", err
+	}
+	if !bytes.Equal(t.hash[:], want) {
+		return nil, "", fmt.Errorf("torrent %s doesn't match the magnet link", q["xs"][0])
+	}
+	t.seeds = append(q["ws"], t.seeds...)
+	return t, u.Fragment, nil
+}
+
+func readTorrent(loc string) (*torrent, error) {
+	var buf []byte
+	var err error
+	if strings.Contains(loc, "://") {
+		var req *http.Request
+		var res *http.Response
+		req, err = http.NewRequest(http.MethodGet, loc, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/x-bittorrent")
+		if res, err = send(req, false); err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.New("http error: " + res.Status)
+		}
+		buf, err = ioutil.ReadAll(io.LimitReader(res.Body, 16<<20))
+	} else {
+		buf, err = ioutil.ReadFile(loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := parseTorrent(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading torrent %s: %w", loc, err)
+	}
+	return t, nil
+}
+
+func parseTorrent(buf []byte) (*torrent, error) {
+	d := bdecoder{buf: buf}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	root, _ := v.(map[string]interface{})
+	info, _ := root["info"].(map[string]interface{})
+	if info == nil || d.info == nil {
+		return nil, errors.New("missing info dictionary")
+	}
+
+	t := &torrent{hash: sha1.Sum(d.info)}
+	t.name, _ = info["name"].(string)
+	t.pieceLen, _ = info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	t.pieces = []byte(pieces)
+	if pieces == "" && info["meta version"] == int64(2) {
+		return nil, errors.New("BitTorrent v2 only torrents aren't supported")
+	}
+	if t.pieceLen <= 0 || len(t.pieces)%sha1.Size != 0 || gofetch.SanitizeName(t.name) != t.name || t.name == "" {
+		return nil, errors.New("invalid info dictionary")
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		t.files = []torrentFile{{path: t.name, length: length}}
+	} else if files, ok := info["files"].([]interface{}); ok {
+		t.multi = true
+		var offset int64
+		for _, f := range files {
+			f, _ := f.(map[string]interface{})
+			length, _ := f["length"].(int64)
+			attr, _ := f["attr"].(string)
+			elems, _ := f["path"].([]interface{})
+			var parts []string
+			for _, e := range elems {
+				if e, _ := e.(string); e != "" && gofetch.SanitizeName(e) == e {
+					parts = append(parts, e)
+				} else {
+					return nil, errors.New("invalid file path")
+				}
+			}
+			if len(parts) == 0 || length < 0 {
+				return nil, errors.New("invalid file")
+			}
+			t.files = append(t.files, torrentFile{
+				path:   strings.Join(parts, "/"),
+				length: length,
+				offset: offset,
+				pad:    strings.Contains(attr, "p"),
+			})
+			offset += length
+		}
+	}
+	for _, f := range t.files {
+		t.length = f.offset + f.length
+	}
+	if len(t.files) == 0 || t.length < 0 ||
+		int64(len(t.pieces)/sha1.Size) != (t.length+t.pieceLen-1)/t.pieceLen {
+		return nil, errors.New("invalid files")
+	}
+
+	switch seeds := root["url-list"].(type) {
+	case string:
+		t.seeds = []string{seeds}
+	case []interface{}:
+		for _, s := range seeds {
+			if s, ok := s.(string); ok {
+				t.seeds = append(t.seeds, s)
+			}
+		}
+	}
+	return t, nil
+}
+
+// selectFile finds the one file that matches pattern.
+func (t *torrent) selectFile(pattern string) (*torrentFile, error) {
+	var found []*torrentFile
+	for i, f := range t.files {
+		if f.pad {
+			continue
+		}
+		if pattern == "" {
+			found = append(found, &t.files[i])
+		} else if ok, err := path.Match(pattern, f.path); err != nil {
+			return nil, err
+		} else if ok {
+			found = append(found, &t.files[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no file matches %q", pattern)
+	case 1:
+		return found[0], nil
+	default:
+		var names []string
+		for _, f := range found {
+			names = append(names, f.path)
+		}
+		return nil, fmt.Errorf("%d files match %q: %s", len(found), pattern, strings.Join(names, ", "))
+	}
+}
+
+// fileURL is the url of a file on a web seed:
+// for a multi-file torrent, the seed is a directory that contains the torrent directory.
+func (t *torrent) fileURL(seed string, f *torrentFile) string {
+	if !t.multi {
+		if strings.HasSuffix(seed, "/") {
+			return seed + url.PathEscape(t.name)
+		}
+		return seed
+	}
+	if !strings.HasSuffix(seed, "/") {
+		seed += "/"
+	}
+	u := seed + url.PathEscape(t.name)
+	for _, p := range strings.Split(f.path, "/") {
+		u += "/" + url.PathEscape(p)
+	}
+	return u
+}
+
+// verifier checks the pieces of a file, as it's read.
+// The pieces it shares with other files are completed
+// with range requests for the other files, to the same seed.
+func (t *torrent) verifier(body io.ReadCloser, f *torrentFile, seed string) (io.ReadCloser, error) {
+	v := &pieceVerifier{
+		ReadCloser: &sizeChecker{ReadCloser: body, size: f.length},
+		hash:       sha1.New(),
+		pieceLen:   t.pieceLen,
+	}
+	if f.length == 0 {
+		return v, nil
+	}
+
+	first := f.offset / t.pieceLen
+	last := (f.offset + f.length - 1) / t.pieceLen
+	end := min64((last+1)*t.pieceLen, t.length)
+	var prefix, suffix bytes.Buffer
+	if err := t.readRange(seed, first*t.pieceLen, f.offset, &prefix); err != nil {
+		return nil, err
+	}
+	if err := t.readRange(seed, f.offset+f.length, end, &suffix); err != nil {
+		return nil, err
+	}
+
+	v.pieces = t.pieces[first*sha1.Size : (last+1)*sha1.Size]
+	v.index = first
+	v.suffix = suffix.Bytes()
+	v.hash.Write(prefix.Bytes())
+	v.filled = int64(prefix.Len())
+	return v, nil
+}
+
+// readRange writes bytes start to end (exclusive) of the torrent to w.
+func (t *torrent) readRange(seed string, start, end int64, w io.Writer) error {
+	for i := range t.files {
+		f := &t.files[i]
+		s, e := start, end
+		if s < f.offset {
+			s = f.offset
+		}
+		if e > f.offset+f.length {
+			e = f.offset + f.length
+		}
+		if s >= e {
+			continue
+		}
+		if f.pad {
+			if _, err := w.Write(make([]byte, e-s)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := getRange(t.fileURL(seed, f), s-f.offset, e-f.offset, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pieceVerifier checks the SHA-1 of each piece read from it,
+// failing at the first one that doesn't match.
+type pieceVerifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	pieces   []byte // the SHA-1 of the pieces left
+	pieceLen int64
+	index    int64 // of the current piece
+	filled   int64 // bytes of the current piece hashed
+	suffix   []byte
+}
+
+func (v *pieceVerifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	if err := v.write(p[:n]); err != nil {
+		return n, err
+	}
+	if err == io.EOF {
+		// the rest of the last piece
+		if err := v.write(v.suffix); err != nil {
+			return n, err
+		}
+		v.suffix = nil
+		if v.filled > 0 {
+			if err := v.check(); err != nil {
+				return n, err
+			}
+		}
+		if len(v.pieces) > 0 {
+			return n, fmt.Errorf("missing piece %d", v.index)
+		}
+	}
+	return n, err
+}
+
+func (v *pieceVerifier) write(b []byte) error {
+	for len(b) > 0 {
+		n := min64(v.pieceLen-v.filled, int64(len(b)))
+		v.hash.Write(b[:n])
+		v.filled += n
+		b = b[n:]
+		if v.filled == v.pieceLen {
+			if err := v.check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *pieceVerifier) check() error {
+	if len(v.pieces) < sha1.Size {
+		return errors.New("more data than pieces")
+	}
+	if got := v.hash.Sum(nil); !bytes.Equal(got, v.pieces[:sha1.Size]) {
+		return fmt.Errorf("sha1 mismatch in piece %d", v.index)
+	}
+	v.pieces = v.pieces[sha1.Size:]
+	v.index++
+	v.filled = 0
+	v.hash.Reset()
+	return nil
+}
+
+var errBencode = errors.New("malformed bencoding")
+
+// bdecoder decodes bencoding into int64, string,
+// []interface{} and map[string]interface{} values.
+type bdecoder struct {
+	buf   []byte
+	pos   int
+	depth int
+	info  []byte // the encoding of the top-level info dictionary, for its hash
+}
+
+func (d *bdecoder) value() (interface{}, error) {
+	if d.pos >= len(d.buf) || d.depth > 32 {
+		return nil, errBencode
+	}
+	switch c := d.buf[d.pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.buf[d.pos:], 'e')
+		if end < 0 {
+			return nil, errBencode
+		}
+		n, err := strconv.ParseInt(string(d.buf[d.pos+1:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, errBencode
+		}
+		d.pos += end + 1
+		return n, nil
+
+	case '0' <= c && c <= '9':
+		colon := bytes.IndexByte(d.buf[d.pos:], ':')
+		if colon < 0 {
+			return nil, errBencode
+		}
+		n, err := strconv.Atoi(string(d.buf[d.pos : d.pos+colon]))
+		start := d.pos + colon + 1
+		if err != nil || n < 0 || n > len(d.buf)-start {
+			return nil, errBencode
+		}
+		d.pos = start + n
+		return string(d.buf[start:d.pos]), nil
+
+	case c == 'l':
+		d.pos++
+		d.depth++
+		defer func() { d.depth-- }()
+		list := []interface{}{}
+		for {
+			if d.pos < len(d.buf) && d.buf[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+
+	case c == 'd':
+		d.pos++
+		d.depth++
+		defer func() { d.depth-- }()
+		dict := map[string]interface{}{}
+		for {
+			if d.pos < len(d.buf) && d.buf[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			k, err := d.value()
+			key, ok := k.(string)
+			if err != nil || !ok {
+				return nil, errBencode
+			}
+			start := d.pos
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if key == "info" && d.depth == 1 {
+				d.info = d.buf[start:d.pos]
+			}
+			dict[key] = v
+		}
+	}
+	return nil, errBencode
+}
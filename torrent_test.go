@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bencode encodes int, string, []interface{} and map[string]interface{} values.
+func bencode(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return fmt.Sprintf("i%de", v)
+	case string:
+		return fmt.Sprintf("%d:%s", len(v), v)
+	case []interface{}:
+		s := "l"
+		for _, e := range v {
+			s += bencode(e)
+		}
+		return s + "e"
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s := "d"
+		for _, k := range keys {
+			s += bencode(k) + bencode(v[k])
+		}
+		return s + "e"
+	}
+	panic("can't bencode")
+}
+
+// pieces hashes data in pieces of length n.
+func pieces(data []byte, n int) string {
+	var s string
+	for off := 0; off < len(data); off += n {
+		end := off + n
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[off:end])
+		s += string(sum[:])
+	}
+	return s
+}
+
+func TestParseTorrent(t *testing.T) {
+	single := map[string]interface{}{
+		"name": "file.bin", "piece length": 4, "length": 6,
+		"pieces": pieces([]byte("abcdef"), 4),
+	}
+	multi := map[string]interface{}{
+		"name": "dist", "piece length": 4,
+		"pieces": pieces([]byte("abcdef"), 4),
+		"files": []interface{}{
+			map[string]interface{}{"length": 2, "path": []interface{}{"a", "b.txt"}},
+			map[string]interface{}{"length": 4, "path": []interface{}{"c.txt"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		doc     string
+		files   []string
+		seeds   []string
+		wantErr bool
+	}{
+		{name: "single", doc: bencode(map[string]interface{}{"info": single, "url-list": "http://seed/"}),
+			files: []string{"file.bin"}, seeds: []string{"http://seed/"}},
+		{name: "multi", doc: bencode(map[string]interface{}{"info": multi, "url-list": []interface{}{"http://a/", "http://b/"}}),
+			files: []string{"a/b.txt", "c.txt"}, seeds: []string{"http://a/", "http://b/"}},
+		{name: "no info", doc: bencode(map[string]interface{}{"url-list": "http://seed/"}), wantErr: true},
+		{name: "missing pieces", doc: bencode(map[string]interface{}{"info": map[string]interface{}{
+			"name": "file.bin", "piece length": 4, "length": 6, "pieces": pieces([]byte("abcd"), 4),
+		}}), wantErr: true},
+		{name: "traversal", doc: bencode(map[string]interface{}{"info": map[string]interface{}{
+			"name": "dist", "piece length": 4, "pieces": pieces([]byte("ab"), 4),
+			"files": []interface{}{map[string]interface{}{"length": 2, "path": []interface{}{"..", "x"}}},
+		}}), wantErr: true},
+		{name: "v2 only", doc: bencode(map[string]interface{}{"info": map[string]interface{}{
+			"name": "file.bin", "piece length": 4, "meta version": 2,
+		}}), wantErr: true},
+		{name: "truncated", doc: bencode(map[string]interface{}{"info": single})[:20], wantErr: true},
+		{name: "deep", doc: strings.Repeat("l", 100) + strings.Repeat("e", 100), wantErr: true},
+	}
+	for _, tt := range tests {
+		tor, err := parseTorrent([]byte(tt.doc))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var files []string
+		for _, f := range tor.files {
+			files = append(files, f.path)
+		}
+		if strings.Join(files, ",") != strings.Join(tt.files, ",") || strings.Join(tor.seeds, ",") != strings.Join(tt.seeds, ",") {
+			t.Errorf("%s: files %q, seeds %q", tt.name, files, tor.seeds)
+		}
+	}
+}
+
+func TestFetchTorrent(t *testing.T) {
+	// a.txt, a padding file, then b.bin and c.txt sharing a piece
+	const pieceLen = 64
+	files := []struct {
+		path string
+		data []byte
+		pad  bool
+	}{
+		{path: "a.txt", data: bytes.Repeat([]byte("a"), 100)},
+		{path: ".pad/28", data: make([]byte, 28), pad: true},
+		{path: "b.bin", data: bytes.Repeat([]byte("b"), 150)},
+		{path: "c.txt", data: bytes.Repeat([]byte("c"), 50)},
+	}
+	var all []byte
+	var list []interface{}
+	for _, f := range files {
+		all = append(all, f.data...)
+		var path []interface{}
+		for _, p := range strings.Split(f.path, "/") {
+			path = append(path, p)
+		}
+		e := map[string]interface{}{"length": len(f.data), "path": path}
+		if f.pad {
+			e["attr"] = "p"
+		}
+		list = append(list, e)
+	}
+	info := map[string]interface{}{
+		"name": "dist", "piece length": pieceLen,
+		"pieces": pieces(all, pieceLen), "files": list,
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if r.URL.Path == "/dist.torrent" {
+			w.Write([]byte(bencode(map[string]interface{}{"info": info, "url-list": srv.URL + "/seed/"})))
+			return
+		}
+		if parts[0] == "down" || len(parts) < 2 {
+			http.Error(w, "seed down", http.StatusServiceUnavailable)
+			return
+		}
+		for _, f := range files {
+			if parts[1] == "dist/"+f.path && !f.pad {
+				data := f.data
+				if parts[0] == "bad" {
+					data = append([]byte("x"), data[1:]...)
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	torrentFile := func(seeds ...string) string {
+		var urls []interface{}
+		for _, s := range seeds {
+			urls = append(urls, srv.URL+"/"+s+"/")
+		}
+		file := filepath.Join(t.TempDir(), "dist.torrent")
+		ioutil.WriteFile(file, []byte(bencode(map[string]interface{}{"info": info, "url-list": urls})), 0666)
+		return file
+	}
+
+	hash := sha1.Sum([]byte(bencode(info)))
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(hash[:]) + "&xs=" + srv.URL + "/dist.torrent"
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{name: "first", source: "torrent::" + torrentFile("seed") + "#a.txt", want: "a.txt"},
+		{name: "failover", source: "torrent::" + torrentFile("down", "seed") + "#b.*", want: "b.bin"},
+		{name: "last", source: "torrent::" + torrentFile("seed") + "#c.txt", want: "c.txt"},
+		{name: "magnet", source: magnet + "#c.txt", want: "c.txt"},
+		{name: "magnet seed", source: magnet + "&ws=" + srv.URL + "/down/#a.txt", want: "a.txt"},
+		{name: "magnet mismatch", source: "magnet:?xt=urn:btih:" + strings.Repeat("0", 40) + "&xs=" + srv.URL + "/dist.torrent", wantErr: true},
+		{name: "magnet no source", source: "magnet:?xt=urn:btih:" + hex.EncodeToString(hash[:]), wantErr: true},
+		{name: "several files", source: "torrent::" + torrentFile("seed"), wantErr: true},
+		{name: "no seeds", source: "torrent::" + torrentFile() + "#a.txt", wantErr: true},
+		{name: "bad piece", source: "torrent::" + torrentFile("bad") + "#b.bin", wantErr: true},
+		{name: "bad shared piece", source: "torrent::" + torrentFile("bad") + "#c.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		body, m, err := fetch(tt.source, nil)
+		var got []byte
+		if err == nil {
+			got, err = ioutil.ReadAll(body)
+			body.Close()
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var want []byte
+		for _, f := range files {
+			if f.path == tt.want {
+				want = f.data
+			}
+		}
+		if !bytes.Equal(got, want) || m.name != tt.want {
+			t.Errorf("%s: got %d bytes, named %q", tt.name, len(got), m.name)
+		}
+	}
+}
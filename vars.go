@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+type varsFlag map[string]string
+
+// vars holds the values of {name} placeholders,
+// which are expanded in sources and targets.
+var vars = varsFlag{
+	"os":   runtime.GOOS,
+	"arch": runtime.GOARCH,
+}
+
+func init() {
+	flag.Var(vars, "var", "set `name=value` to expand {name} in urls and targets (repeatable)")
+}
+
+func (v varsFlag) String() string {
+	var s []string
+	for k, val := range v {
+		s = append(s, k+"="+val)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[s[:i]] = s[i+1:]
+	return nil
+}
+
+// expand replaces the placeholders of defined variables.
+// Anything else between braces is left untouched.
+func expand(s string) string {
+	var pairs []string
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
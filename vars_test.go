@@ -0,0 +1,33 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	defer delete(vars, "version")
+	if err := vars.Set("version=1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vars.Set("=value"); err == nil {
+		t.Error(`Set("=value"): want error`)
+	}
+	if err := vars.Set("novalue"); err == nil {
+		t.Error(`Set("novalue"): want error`)
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"tool_{os}_{arch}", "tool_" + runtime.GOOS + "_" + runtime.GOARCH},
+		{"v{version}/tool-{version}.tgz", "v1.2.3/tool-1.2.3.tgz"},
+		{"{undefined}/{os", "{undefined}/{os"},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		if got := expand(tt.in); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
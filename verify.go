@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+)
+
+// digestAlgorithms are the digest algorithms of fetch.NewHash, strongest first.
+var digestAlgorithms = []string{"sha512", "sha256"}
+
+// hasDigest checks if a file exists and matches digest.
+func hasDigest(path, digest string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	r, err := gofetch.NewVerifier(f, digest)
+	if err != nil {
+		f.Close()
+		return false
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	r.Close()
+	return err == nil
+}
+
+// wantDigest asks servers to send integrity fields (RFC 9530).
+const wantDigest = "sha-512=5, sha-256=10"
+
+// responseDigest finds a digest sent by the server for the response body,
+// in algorithm:hex form, from Content-Digest, Repr-Digest, or legacy Digest.
+func responseDigest(res *http.Response) string {
+	// transparently decoded bodies don't match
+	if res.Uncompressed {
+		return ""
+	}
+	fields := []string{"Content-Digest", "Repr-Digest"}
+	if res.StatusCode != http.StatusOK {
+		// partial content isn't the whole representation
+		fields = fields[:1]
+	}
+	for _, f := range fields {
+		if d := parseDigestField(res.Header.Get(f), true); d != "" {
+			return d
+		}
+	}
+	return parseDigestField(res.Header.Get("Digest"), false)
+}
+
+// parseDigestField picks the strongest supported digest in a field:
+// a dictionary of sha-256=:BASE64: items,
+// or, in legacy form, a list of SHA-256=BASE64 items.
+func parseDigestField(field string, structured bool) string {
+	var best string
+	for _, item := range strings.Split(field, ",") {
+		i := strings.IndexByte(item, '=')
+		if i < 0 {
+			continue
+		}
+		algo := strings.ToLower(strings.TrimSpace(item[:i]))
+		value := strings.TrimSpace(item[i+1:])
+		if structured {
+			if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				continue
+			}
+			value = value[1 : len(value)-1]
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		switch {
+		case algo == "sha-512" && len(sum) == sha512.Size:
+			return "sha512:" + hex.EncodeToString(sum)
+		case algo == "sha-256" && len(sum) == sha256.Size:
+			best = "sha256:" + hex.EncodeToString(sum)
+		}
+	}
+	return best
+}
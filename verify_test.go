@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasDigest(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(name, []byte("hello world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("hello world")))
+
+	tests := []struct {
+		path   string
+		digest string
+		want   bool
+	}{
+		{name, sum, true},
+		{name, fmt.Sprintf("sha256:%x", sha256.Sum256(nil)), false},
+		{name, "sha256:bad", false},
+		{name + ".missing", sum, false},
+	}
+	for _, tt := range tests {
+		if got := hasDigest(tt.path, tt.digest); got != tt.want {
+			t.Errorf("hasDigest(%q, %q) = %v, want %v", tt.path, tt.digest, got, tt.want)
+		}
+	}
+}
+
+func TestParseDigestField(t *testing.T) {
+	h256 := sha256.Sum256([]byte("hello"))
+	h512 := sha512.Sum512([]byte("hello"))
+	b256 := base64.StdEncoding.EncodeToString(h256[:])
+	b512 := base64.StdEncoding.EncodeToString(h512[:])
+	want256 := fmt.Sprintf("sha256:%x", h256)
+	want512 := fmt.Sprintf("sha512:%x", h512)
+
+	tests := []struct {
+		field      string
+		structured bool
+		want       string
+	}{
+		{"sha-256=:" + b256 + ":", true, want256},
+		{"sha-256=:" + b256 + ":, sha-512=:" + b512 + ":", true, want512},
+		{"md5=:AAAA:, sha-256=:" + b256 + ":", true, want256},
+		{"sha-256=" + b256, true, ""},
+		{"SHA-256=" + b256, false, want256},
+		{"sha-256=:AAAA:", true, ""},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		if got := parseDigestField(tt.field, tt.structured); got != tt.want {
+			t.Errorf("parseDigestField(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestFetch_contentDigest(t *testing.T) {
+	h := sha256.Sum256([]byte("hello"))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(h[:]) + ":"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Want-Content-Digest") == "" {
+			http.Error(w, "no Want-Content-Digest", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Digest", digest)
+		if r.URL.Path == "/tampered" {
+			w.Write([]byte("HELLO"))
+		} else {
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer srv.Close()
+
+	for path, wantErr := range map[string]bool{"/file": false, "/tampered": true} {
+		body, _, err := fetch(srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(body)
+		body.Close()
+		if (err != nil) != wantErr {
+			t.Errorf("fetch(%q) error = %v, wantErr %v", path, err, wantErr)
+		}
+	}
+}
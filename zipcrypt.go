@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// resolveZipPassword prompts for the -zip-password, if it's "-".
+func resolveZipPassword() error {
+	if *zipPassword != "-" {
+		return nil
+	}
+	pw, err := readPassword("zip password: ")
+	if err != nil {
+		return fmt.Errorf("-zip-password: %w", err)
+	}
+	*zipPassword = pw
+	return nil
+}
+
+// readLine prompts for, and reads, a line of input.
+func readLine(r io.Reader, w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	var prompt bytes.Buffer
+	got, err := readLine(bytes.NewBufferString("pass word\r\nnext"), &prompt, "zip password: ")
+	if err != nil || got != "pass word" || prompt.String() != "zip password: " {
+		t.Errorf("readLine() = %q, %v (prompt %q)", got, err, prompt.String())
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// zipCharset decodes zip entry names not flagged as UTF-8.
+var zipCharset encoding.Encoding
+
+// resolveZipEncoding finds the charset named by -zip-encoding.
+func resolveZipEncoding() error {
+	if *zipEncoding == "" {
+		return nil
+	}
+	enc, err := ianaindex.IANA.Encoding(*zipEncoding)
+	if err != nil || enc == nil {
+		enc, err = htmlindex.Get(*zipEncoding)
+	}
+	if err != nil || enc == nil {
+		return fmt.Errorf("unsupported -zip-encoding: %q", *zipEncoding)
+	}
+	zipCharset = enc
+	return nil
+}
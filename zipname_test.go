@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestResolveZipEncoding(t *testing.T) {
+	defer func(old string) { *zipEncoding = old }(*zipEncoding)
+	defer func() { zipCharset = nil }()
+
+	tests := map[string]bool{
+		"":                true,
+		"gbk":             true,
+		"windows-1252":    true,
+		"Shift_JIS":       true,
+		"no-such-charset": false,
+	}
+	for name, ok := range tests {
+		*zipEncoding = name
+		zipCharset = nil
+		err := resolveZipEncoding()
+		if (err == nil) != ok || ok && name != "" && zipCharset == nil {
+			t.Errorf("resolveZipEncoding(%q) = %v, charset %v", name, err, zipCharset)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gofetch "github.com/ncruces/go-fetch/fetch"
+	"golang.org/x/crypto/md4"
+)
+
+// With a zsync:: source, the control file of a zsync download is fetched,
+// and the blocks of the file that are already in the target are reused:
+// only the others are requested, with range requests.
+
+// zsyncGap is the most reused data that's requested anyway,
+// to merge the range requests around it.
+const zsyncGap = 64 << 10
+
+// Control files are checked against these limits,
+// as they size the buffers used to read them, and the file.
+const (
+	zsyncMaxLength    = 1 << 40
+	zsyncMaxBlockSize = 1 << 20
+)
+
+// zsyncControl is a zsync control file.
+type zsyncControl struct {
+	filename  string
+	url       *url.URL
+	length    int64
+	blockSize int
+	weakLen   int // bytes of the rolling checksum kept
+	strongLen int // bytes of the MD4 kept
+	sha1      []byte
+	sums      []byte // weakLen+strongLen bytes for each block
+}
+
+func (c *zsyncControl) blocks() int {
+	return int((c.length + int64(c.blockSize) - 1) / int64(c.blockSize))
+}
+
+func (c *zsyncControl) weak(i int) uint32 {
+	var w uint32
+	for _, b := range c.sums[i*(c.weakLen+c.strongLen):][:c.weakLen] {
+		w = w<<8 | uint32(b)
+	}
+	return w
+}
+
+func (c *zsyncControl) strong(i int) []byte {
+	return c.sums[i*(c.weakLen+c.strongLen)+c.weakLen:][:c.strongLen]
+}
+
+// fetchZsync fetches a zsync:: source, reusing what it can of seed,
+// the target file (or directory) it's saved to, if any.
+func fetchZsync(source, seed string, dir bool) (io.ReadCloser, *meta, error) {
+	loc := strings.TrimPrefix(source, "zsync::")
+	c, err := readZsyncControl(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := gofetch.SanitizeName(c.filename)
+	if name == "" {
+		name = filepath.Base(c.url.Path)
+	}
+
+	if seed != "" && dir {
+		seed = filepath.Join(seed, name)
+	}
+	if f, err := os.Open(seed); err == nil {
+		body, err := zsyncPatch(c, f)
+		f.Close()
+		if err == nil {
+			return body, &meta{name: name}, nil
+		}
+		log.Printf("zsync %s: %v; downloading all of it", loc, err)
+	}
+
+	body, meta, err := fetch(c.url.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta.name = name
+	return gofetch.NewHashVerifier(body, sha1.New(), c.sha1, "sha1"), meta, nil
+}
+
+func readZsyncControl(loc string) (*zsyncControl, error) {
+	req, err := http.NewRequest(http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := send(req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("http error: " + res.Status)
+	}
+
+	c := &zsyncControl{}
+	r := bufio.NewReader(res.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading zsync %s: %w", loc, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			return nil, fmt.Errorf("reading zsync %s: malformed header %q", loc, line)
+		}
+		key, value := line[:i], line[i+2:]
+		switch key {
+		case "Filename":
+			c.filename = value
+		case "URL":
+			if c.url == nil {
+				c.url, err = res.Request.URL.Parse(value)
+			}
+		case "Length":
+			c.length, err = strconv.ParseInt(value, 10, 64)
+		case "Blocksize":
+			c.blockSize, err = strconv.Atoi(value)
+		case "Hash-Lengths":
+			var seq int
+			_, err = fmt.Sscanf(value, "%d,%d,%d", &seq, &c.weakLen, &c.strongLen)
+		case "SHA-1":
+			c.sha1, err = hex.DecodeString(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading zsync %s: malformed %s: %q", loc, key, value)
+		}
+	}
+	if c.url == nil || len(c.sha1) != sha1.Size ||
+		c.length <= 0 || c.length > zsyncMaxLength ||
+		c.blockSize <= 0 || c.blockSize > zsyncMaxBlockSize ||
+		c.weakLen < 1 || c.weakLen > 4 || c.strongLen < 1 || c.strongLen > md4.Size {
+		return nil, fmt.Errorf("reading zsync %s: missing or invalid headers", loc)
+	}
+
+	// the sums grow as they're read, rather than trusting the headers
+	// to size them; they can't overflow, with the limits above,
+	// but may not fit an int
+	blocks := (c.length + int64(c.blockSize) - 1) / int64(c.blockSize)
+	size := blocks * int64(c.weakLen+c.strongLen)
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("reading zsync %s: file too large", loc)
+	}
+	if c.sums, err = ioutil.ReadAll(io.LimitReader(r, size)); err != nil {
+		return nil, fmt.Errorf("reading zsync %s: %w", loc, err)
+	}
+	if int64(len(c.sums)) != size {
+		return nil, fmt.Errorf("reading zsync %s: %w", loc, io.ErrUnexpectedEOF)
+	}
+	return c, nil
+}
+
+// zsyncPatch makes the file described by the control file,
+// from the blocks of seed that match, and range requests for the rest.
+// It's saved to a temporary file, removed when closed.
+func zsyncPatch(c *zsyncControl, seed *os.File) (io.ReadCloser, error) {
+	found, err := zsyncScan(c, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile("", partPrefix+"*"+partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	body := &tempFile{tmp}
+	if err := zsyncAssemble(c, seed, found, tmp); err != nil {
+		body.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+// zsyncScan rolls over seed, looking for the blocks of the file;
+// it returns the offset in seed of each block, or -1.
+func zsyncScan(c *zsyncControl, seed io.Reader) ([]int64, error) {
+	n := c.blocks()
+	found := make([]int64, n)
+	blocks := map[uint32][]int{}
+	for i := 0; i < n; i++ {
+		found[i] = -1
+		w := c.weak(i)
+		blocks[w] = append(blocks[w], i)
+	}
+	mask := uint32(1)<<(8*uint(c.weakLen)) - 1
+	if c.weakLen == 4 {
+		mask = ^uint32(0)
+	}
+
+	bs := c.blockSize
+	r := bufio.NewReaderSize(seed, 4*bs)
+	window := make([]byte, 0, 2*bs)
+	var pos int64 // of the window in seed
+	var a, b uint16
+	rolled := false // a and b are for the window
+	for {
+		// fill the window, to a full block
+		for len(window) < bs {
+			ch, err := r.ReadByte()
+			if err == io.EOF {
+				return found, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			window = append(window, ch)
+			rolled = false
+		}
+		if !rolled {
+			a, b = 0, 0
+			for i, ch := range window[:bs] {
+				a += uint16(ch)
+				b += uint16(bs-i) * uint16(ch)
+			}
+			rolled = true
+		}
+
+		matched := false
+		if cands := blocks[(uint32(a)<<16|uint32(b))&mask]; len(cands) > 0 {
+			var sum []byte
+			for _, i := range cands {
+				if found[i] >= 0 {
+					continue
+				}
+				if sum == nil {
+					h := md4.New()
+					h.Write(window[:bs])
+					sum = h.Sum(nil)
+				}
+				if bytes.Equal(sum[:c.strongLen], c.strong(i)) {
+					found[i] = pos
+					matched = true
+				}
+			}
+		}
+
+		if matched {
+			// the next block starts after this one
+			pos += int64(bs)
+			window = window[:0]
+			continue
+		}
+
+		next, err := r.ReadByte()
+		if err == io.EOF {
+			return found, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		old := window[0]
+		a += uint16(next) - uint16(old)
+		b += a - uint16(bs)*uint16(old)
+		window = append(window[1:], next)
+		if cap(window) < bs+1 {
+			window = append(make([]byte, 0, 2*bs), window...)
+		}
+		pos++
+	}
+}
+
+// zsyncAssemble writes the file to w, checking its SHA-1.
+func zsyncAssemble(c *zsyncControl, seed io.ReaderAt, found []int64, w io.Writer) error {
+	hash := sha1.New()
+	w = io.MultiWriter(w, hash)
+	bs := int64(c.blockSize)
+
+	size := func(i int) int64 {
+		if end := int64(i+1) * bs; end > c.length {
+			return c.length - int64(i)*bs
+		}
+		return bs
+	}
+
+	buf := make([]byte, bs)
+	for i := 0; i < len(found); {
+		if found[i] >= 0 {
+			n := size(i)
+			if _, err := seed.ReadAt(buf[:n], found[i]); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		// request the missing blocks, and small gaps between them
+		end := i + 1
+		for k := end; k < len(found) && int64(k-end)*bs <= zsyncGap; k++ {
+			if found[k] < 0 {
+				end = k + 1
+			}
+		}
+		start := int64(i) * bs
+		if err := getRange(c.url.String(), start, start+int64(end-i-1)*bs+size(end-1), w); err != nil {
+			return err
+		}
+		i = end
+	}
+
+	if !bytes.Equal(hash.Sum(nil), c.sha1) {
+		return errors.New("sha1 mismatch")
+	}
+	return nil
+}
+
+// getRange writes bytes start to end (exclusive) of url to w.
+func getRange(url string, start, end int64, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request for %s: http error: %s", req.URL, res.Status)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/", start, end-1); !strings.HasPrefix(res.Header.Get("Content-Range"), want) {
+		return fmt.Errorf("range request for %s: unexpected range %q", req.URL, res.Header.Get("Content-Range"))
+	}
+	n, err := io.Copy(w, io.LimitReader(res.Body, end-start))
+	if err == nil && n != end-start {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// tempFile is a temporary file, removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// makeZsync makes a control file for data, with 3 byte weak
+// and 8 byte strong sums.
+func makeZsync(data []byte, bs int, header string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "zsync: 0.6.2\nFilename: file.bin\nURL: file.bin\n")
+	fmt.Fprintf(&buf, "Length: %d\nBlocksize: %d\nHash-Lengths: 1,3,8\n", len(data), bs)
+	fmt.Fprintf(&buf, "SHA-1: %x\n%s\n", sha1.Sum(data), header)
+	for off := 0; off < len(data); off += bs {
+		block := make([]byte, bs)
+		copy(block, data[off:])
+		var a, b uint16
+		for i, ch := range block {
+			a += uint16(ch)
+			b += uint16(bs-i) * uint16(ch)
+		}
+		buf.Write([]byte{byte(a), byte(b >> 8), byte(b)})
+		h := md4.New()
+		h.Write(block)
+		buf.Write(h.Sum(nil)[:8])
+	}
+	return buf.Bytes()
+}
+
+func TestJob_zsync(t *testing.T) {
+	const bs = 1024
+	data := make([]byte, 64*bs)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var requested int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".zsync") {
+			w.Write(makeZsync(data, bs, ""))
+			return
+		}
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			atomic.AddInt64(&requested, end-start+1)
+		} else {
+			atomic.AddInt64(&requested, int64(len(data)))
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	defer func(old string) { *history = old }(*history)
+	*history = "off"
+
+	// the target is an older version: shifted, and with a changed block
+	seed := append([]byte("prefix"), data...)
+	copy(seed[20*bs:], bytes.Repeat([]byte{0}, bs))
+	dir := t.TempDir()
+	target := filepath.Join(dir, "file.bin")
+	ioutil.WriteFile(target, seed, 0666)
+
+	if err := newJob("zsync::"+srv.URL+"/patched/file.zsync", target).run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); !bytes.Equal(got, data) {
+		t.Error("zsync wrote the wrong data")
+	}
+	if n := atomic.LoadInt64(&requested); n == 0 || n >= int64(len(data))/4 {
+		t.Errorf("zsync requested %d bytes", n)
+	}
+
+	// without a target, it's downloaded
+	atomic.StoreInt64(&requested, 0)
+	target = filepath.Join(dir, "new.bin")
+	if err := newJob("zsync::"+srv.URL+"/new/file.zsync", target).run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(target); !bytes.Equal(got, data) {
+		t.Error("zsync wrote the wrong data")
+	}
+	if n := atomic.LoadInt64(&requested); n != int64(len(data)) {
+		t.Errorf("zsync requested %d bytes", n)
+	}
+}
+
+func TestReadZsyncControl(t *testing.T) {
+	data := []byte(strings.Repeat("zsync", 1000))
+	valid := makeZsync(data, 512, "")
+
+	tests := []struct {
+		name    string
+		control []byte
+		wantErr bool
+	}{
+		{name: "valid", control: valid},
+		{name: "truncated", control: valid[:len(valid)-1], wantErr: true},
+		{name: "no sums", control: makeZsync(nil, 512, ""), wantErr: true},
+		{name: "huge length", control: bytes.Replace(valid, []byte("Length: 5000\n"), []byte("Length: 9223372036854775807\n"), 1), wantErr: true},
+		{name: "huge blocks", control: bytes.Replace(valid, []byte("Blocksize: 512\n"), []byte("Blocksize: 1073741824\n"), 1), wantErr: true},
+		{name: "no blocks", control: bytes.Replace(valid, []byte("Blocksize: 512\n"), []byte("Blocksize: 0\n"), 1), wantErr: true},
+		{name: "malformed", control: []byte("Length 5000\n\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(tt.control)
+		}))
+		c, err := readZsyncControl(srv.URL + "/file.zsync")
+		srv.Close()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if c.blocks() != 10 || len(c.sums) != 10*11 || c.url.String() != srv.URL+"/file.bin" {
+			t.Errorf("%s: %d blocks, %d bytes of sums, url %s", tt.name, c.blocks(), len(c.sums), c.url)
+		}
+	}
+}